@@ -0,0 +1,134 @@
+// Package aggregate groups sensor readings into fixed time buckets and keeps
+// min/max/avg/count per measured and derived metric. It is used by the
+// chart endpoint and by the retention rollups.
+package aggregate
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"my-smart-farm/derived"
+	"my-smart-farm/models"
+)
+
+// Stats summarises one metric within a bucket.
+type Stats struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Avg   float64 `json:"avg"`
+	Count int     `json:"count"`
+
+	sum float64
+}
+
+func (s *Stats) add(v float64) {
+	if s.Count == 0 || v < s.Min {
+		s.Min = v
+	}
+	if s.Count == 0 || v > s.Max {
+		s.Max = v
+	}
+	s.Count++
+	s.sum += v
+	s.Avg = s.sum / float64(s.Count)
+}
+
+// Bucket holds the statistics of one device over [Start, Start+bucket size).
+type Bucket struct {
+	DeviceID    string    `json:"device_id"`
+	Start       time.Time `json:"start"`
+	Count       int       `json:"count"`
+	Temperature Stats     `json:"temperature"`
+	Humidity    Stats     `json:"humidity"`
+	Soil        Stats     `json:"soil"`
+	// Derived metrics skip readings whose humidity is out of range, so
+	// their counts may be lower.
+	VPD              Stats `json:"vpd"`
+	DewPoint         Stats `json:"dew_point"`
+	AbsoluteHumidity Stats `json:"absolute_humidity"`
+	HeatIndex        Stats `json:"heat_index"`
+}
+
+// ParseBucket parses a bucket size such as "5m", "1h" or "1d". Day units are
+// accepted in addition to the units understood by time.ParseDuration.
+func ParseBucket(s string) (time.Duration, error) {
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, errors.New("invalid bucket: " + s)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, errors.New("invalid bucket: " + s)
+		}
+	}
+	if d < time.Minute {
+		return 0, errors.New("bucket must be at least 1m")
+	}
+	return d, nil
+}
+
+// BucketStart returns the start of the bucket containing t. Buckets are
+// aligned to the local clock so that daily buckets start at local midnight.
+func BucketStart(t time.Time, size time.Duration) time.Time {
+	_, offset := t.Zone()
+	shift := time.Duration(offset) * time.Second
+	return t.Add(shift).Truncate(size).Add(-shift)
+}
+
+type bucketKey struct {
+	deviceID string
+	start    int64
+}
+
+// Accumulator collects readings into buckets of a fixed size.
+type Accumulator struct {
+	size    time.Duration
+	buckets map[bucketKey]*Bucket
+}
+
+func NewAccumulator(size time.Duration) *Accumulator {
+	return &Accumulator{size: size, buckets: make(map[bucketKey]*Bucket)}
+}
+
+// Add folds a reading into its bucket.
+func (a *Accumulator) Add(r *models.SensorData) {
+	start := BucketStart(r.Timestamp, a.size)
+	key := bucketKey{deviceID: r.DeviceID, start: start.Unix()}
+	b := a.buckets[key]
+	if b == nil {
+		b = &Bucket{DeviceID: r.DeviceID, Start: start}
+		a.buckets[key] = b
+	}
+	b.Count++
+	b.Temperature.add(r.Temperature)
+	b.Humidity.add(r.Humidity)
+	b.Soil.add(r.Soil)
+	if v, ok := derived.Compute(r.Temperature, r.Humidity); ok {
+		b.VPD.add(v.VPD)
+		b.DewPoint.add(v.DewPoint)
+		b.AbsoluteHumidity.add(v.AbsoluteHumidity)
+		b.HeatIndex.add(v.HeatIndex)
+	}
+}
+
+// Buckets returns the collected buckets ordered by device and start time.
+func (a *Accumulator) Buckets() []Bucket {
+	out := make([]Bucket, 0, len(a.buckets))
+	for _, b := range a.buckets {
+		out = append(out, *b)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].DeviceID != out[j].DeviceID {
+			return out[i].DeviceID < out[j].DeviceID
+		}
+		return out[i].Start.Before(out[j].Start)
+	})
+	return out
+}
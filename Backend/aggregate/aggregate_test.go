@@ -0,0 +1,55 @@
+package aggregate
+
+import (
+	"testing"
+	"time"
+
+	"my-smart-farm/models"
+)
+
+func TestParseBucket(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"5m": 5 * time.Minute,
+		"1h": time.Hour,
+		"1d": 24 * time.Hour,
+	} {
+		got, err := ParseBucket(s)
+		if err != nil {
+			t.Fatal(s, err)
+		}
+		if got != want {
+			t.Errorf("ParseBucket(%q) = %v, want %v", s, got, want)
+		}
+	}
+	for _, s := range []string{"", "10s", "xd", "1y"} {
+		if _, err := ParseBucket(s); err == nil {
+			t.Errorf("ParseBucket(%q) should fail", s)
+		}
+	}
+}
+
+func TestAccumulator(t *testing.T) {
+	loc := time.FixedZone("ICT", 7*3600)
+	base := time.Date(2025, 4, 4, 0, 30, 0, 0, loc)
+	acc := NewAccumulator(24 * time.Hour)
+	for i, temp := range []float64{20, 30, 25} {
+		acc.Add(&models.SensorData{
+			DeviceID:    "sensor-001",
+			Temperature: temp,
+			Timestamp:   base.Add(time.Duration(i) * time.Hour),
+		})
+	}
+	acc.Add(&models.SensorData{DeviceID: "sensor-001", Temperature: 10, Timestamp: base.Add(-time.Hour)})
+
+	buckets := acc.Buckets()
+	if len(buckets) != 2 {
+		t.Fatalf("got %d buckets, want 2", len(buckets))
+	}
+	b := buckets[1]
+	if !b.Start.Equal(time.Date(2025, 4, 4, 0, 0, 0, 0, loc)) {
+		t.Error("bucket should start at local midnight, got", b.Start)
+	}
+	if b.Count != 3 || b.Temperature.Min != 20 || b.Temperature.Max != 30 || b.Temperature.Avg != 25 {
+		t.Errorf("bad stats %+v", b.Temperature)
+	}
+}
@@ -0,0 +1,237 @@
+// Package alerts evaluates threshold rules against each stored reading and
+// tracks pending, firing and resolved alert state with hysteresis.
+package alerts
+
+import (
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"my-smart-farm/derived"
+	"my-smart-farm/models"
+
+	"gorm.io/gorm"
+)
+
+// Measured lists the metrics sensors report.
+var Measured = []string{"temperature", "humidity", "soil"}
+
+// Metrics lists the metrics rules can refer to: the measured ones and those
+// derived from temperature and humidity, such as vpd.
+var Metrics = append(slices.Clone(Measured), derived.Metrics...)
+
+// MetricValue returns the named metric of a reading. Derived metrics are
+// missing when the reading's humidity is out of range.
+func MetricValue(data *models.SensorData, metric string) (float64, bool) {
+	switch metric {
+	case "temperature":
+		return data.Temperature, true
+	case "humidity":
+		return data.Humidity, true
+	case "soil":
+		return data.Soil, true
+	}
+	return derived.Value(data, metric)
+}
+
+// Transition describes an alert changing state. From is empty for a newly
+// opened alert.
+type Transition struct {
+	Alert models.Alert
+	From  string
+	To    string
+}
+
+// Evaluator checks readings against the enabled rules.
+type Evaluator struct {
+	db *gorm.DB
+
+	// mu serialises evaluation so concurrent readings of one device do not
+	// open duplicate alerts.
+	mu        sync.Mutex
+	listeners []func(Transition)
+}
+
+func NewEvaluator(db *gorm.DB) *Evaluator {
+	return &Evaluator{db: db}
+}
+
+// OnTransition registers fn to be called for every alert state change.
+func (e *Evaluator) OnTransition(fn func(Transition)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.listeners = append(e.listeners, fn)
+}
+
+// Hook adapts the evaluator to an ingest hook, logging failures.
+func (e *Evaluator) Hook(data *models.SensorData) {
+	if err := e.Evaluate(data); err != nil {
+		log.Println("alerts: evaluate:", err)
+	}
+}
+
+// Evaluate applies every enabled rule matching the reading's device.
+func (e *Evaluator) Evaluate(data *models.SensorData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var rules []models.AlertRule
+	err := e.db.Where("enabled = ? AND (device_id = '' OR device_id = ?)", true, data.DeviceID).
+		Find(&rules).Error
+	if err != nil {
+		return err
+	}
+
+	var transitions []Transition
+	for _, rule := range rules {
+		value, ok := MetricValue(data, rule.Metric)
+		if !ok {
+			continue
+		}
+		t, err := e.evaluateRule(rule, data, value)
+		if err != nil {
+			return err
+		}
+		if t != nil {
+			transitions = append(transitions, *t)
+		}
+	}
+
+	for _, t := range transitions {
+		e.notify(t)
+	}
+	return nil
+}
+
+// Raise opens a firing system alert for the device unless one with the same
+// message is already open.
+func (e *Evaluator) Raise(deviceID, message string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var count int64
+	err := e.db.Model(&models.Alert{}).
+		Where("rule_id = 0 AND device_id = ? AND message = ? AND state = ?", deviceID, message, models.AlertFiring).
+		Count(&count).Error
+	if err != nil || count > 0 {
+		return err
+	}
+	now := time.Now()
+	alert := models.Alert{
+		DeviceID:  deviceID,
+		Message:   message,
+		State:     models.AlertFiring,
+		StartedAt: now,
+		FiredAt:   &now,
+	}
+	if err := e.db.Omit("Rule").Create(&alert).Error; err != nil {
+		return err
+	}
+	e.notify(Transition{Alert: alert, To: models.AlertFiring})
+	return nil
+}
+
+// Clear resolves the open system alert for the device with the given message.
+func (e *Evaluator) Clear(deviceID, message string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var open []models.Alert
+	err := e.db.Where("rule_id = 0 AND device_id = ? AND message = ? AND state = ?",
+		deviceID, message, models.AlertFiring).Find(&open).Error
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, alert := range open {
+		alert.State = models.AlertResolved
+		alert.ResolvedAt = &now
+		if err := e.db.Omit("Rule").Save(&alert).Error; err != nil {
+			return err
+		}
+		e.notify(Transition{Alert: alert, From: models.AlertFiring, To: models.AlertResolved})
+	}
+	return nil
+}
+
+func (e *Evaluator) notify(t Transition) {
+	for _, fn := range e.listeners {
+		fn(t)
+	}
+}
+
+// breached reports whether value violates the rule threshold.
+func breached(rule models.AlertRule, value float64) bool {
+	if rule.Operator == "<" {
+		return value < rule.Value
+	}
+	return value > rule.Value
+}
+
+// recovered reports whether value is back past the threshold by at least
+// the rule's hysteresis, so a firing alert may resolve.
+func recovered(rule models.AlertRule, value float64) bool {
+	if rule.Operator == "<" {
+		return value >= rule.Value+rule.Hysteresis
+	}
+	return value <= rule.Value-rule.Hysteresis
+}
+
+func (e *Evaluator) evaluateRule(rule models.AlertRule, data *models.SensorData, value float64) (*Transition, error) {
+	var open []models.Alert
+	err := e.db.Where("rule_id = ? AND device_id = ? AND state IN ?",
+		rule.ID, data.DeviceID, []string{models.AlertPending, models.AlertFiring}).
+		Limit(1).Find(&open).Error
+	if err != nil {
+		return nil, err
+	}
+	holdFor := time.Duration(rule.ForSeconds) * time.Second
+
+	if len(open) == 0 {
+		if !breached(rule, value) {
+			return nil, nil
+		}
+		alert := models.Alert{
+			RuleID:    rule.ID,
+			DeviceID:  data.DeviceID,
+			State:     models.AlertPending,
+			Value:     value,
+			StartedAt: data.Timestamp,
+		}
+		if holdFor == 0 {
+			alert.State = models.AlertFiring
+			alert.FiredAt = &data.Timestamp
+		}
+		if err := e.db.Omit("Rule").Create(&alert).Error; err != nil {
+			return nil, err
+		}
+		alert.Rule = rule
+		return &Transition{Alert: alert, To: alert.State}, nil
+	}
+
+	alert := open[0]
+	alert.Value = value
+	from := alert.State
+	switch {
+	case alert.State == models.AlertPending && !breached(rule, value):
+		// The condition did not hold long enough; forget it.
+		err := e.db.Delete(&alert).Error
+		return nil, err
+	case alert.State == models.AlertPending && data.Timestamp.Sub(alert.StartedAt) >= holdFor:
+		alert.State = models.AlertFiring
+		alert.FiredAt = &data.Timestamp
+	case alert.State == models.AlertFiring && recovered(rule, value):
+		alert.State = models.AlertResolved
+		alert.ResolvedAt = &data.Timestamp
+	}
+
+	if err := e.db.Omit("Rule").Save(&alert).Error; err != nil {
+		return nil, err
+	}
+	if alert.State == from {
+		return nil, nil
+	}
+	alert.Rule = rule
+	return &Transition{Alert: alert, From: from, To: alert.State}, nil
+}
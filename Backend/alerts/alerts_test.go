@@ -0,0 +1,59 @@
+package alerts
+
+import (
+	"testing"
+	"time"
+
+	"my-smart-farm/models"
+	"my-smart-farm/store/storetest"
+)
+
+func TestEvaluateHysteresis(t *testing.T) {
+	db := storetest.SQLite(t, &models.AlertRule{}, &models.Alert{})
+	db.Create(&models.AlertRule{
+		Metric: "soil", Operator: "<", Value: 25, Hysteresis: 5, ForSeconds: 600, Enabled: true,
+	})
+
+	ev := NewEvaluator(db)
+	var got []string
+	ev.OnTransition(func(tr Transition) { got = append(got, tr.To) })
+
+	start := time.Date(2025, 4, 4, 6, 0, 0, 0, time.Local)
+	for i, soil := range []float64{20, 30, 20, 22, 21, 27, 31} {
+		err := ev.Evaluate(&models.SensorData{
+			DeviceID:  "sensor-001",
+			Soil:      soil,
+			Timestamp: start.Add(time.Duration(i) * 5 * time.Minute),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 20 opens pending, 30 cancels it, 20 re-opens, 21 ten minutes later
+	// fires, 27 is inside the hysteresis band and 31 resolves.
+	want := []string{models.AlertPending, models.AlertPending, models.AlertFiring, models.AlertResolved}
+	if len(got) != len(want) {
+		t.Fatalf("transitions = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("transitions = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestDerivedMetricRule(t *testing.T) {
+	db := storetest.SQLite(t, &models.AlertRule{}, &models.Alert{})
+	db.Create(&models.AlertRule{Metric: "vpd", Operator: ">", Value: 1.5, Enabled: true})
+
+	ev := NewEvaluator(db)
+	var got []string
+	ev.OnTransition(func(tr Transition) { got = append(got, tr.To) })
+	// 30 °C at 75 % is a VPD of 1.06 kPa; at 55 % it is 1.91 kPa.
+	at := time.Date(2025, 4, 4, 12, 0, 0, 0, time.Local)
+	ev.Evaluate(&models.SensorData{DeviceID: "sensor-001", Temperature: 30, Humidity: 75, Timestamp: at})
+	ev.Evaluate(&models.SensorData{DeviceID: "sensor-001", Temperature: 30, Humidity: 55, Timestamp: at.Add(time.Minute)})
+	if len(got) != 1 || got[0] != models.AlertFiring {
+		t.Errorf("transitions = %v, want one firing", got)
+	}
+}
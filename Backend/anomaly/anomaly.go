@@ -0,0 +1,208 @@
+// Package anomaly looks for sensors that misbehave without producing
+// impossible values: probes that freeze on one value, sudden steps and
+// readings far outside the device's recent spread. Findings are stored as
+// annotations on the reading; frozen sensors also raise an alert, since a
+// corroded soil probe reports a flat line that nobody notices for days.
+package anomaly
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"my-smart-farm/alerts"
+	"my-smart-farm/models"
+)
+
+// Steps are the largest believable changes between two consecutive readings
+// of a device. Zero disables the check for that metric.
+type Steps struct {
+	Temperature float64 `yaml:"temperature"`
+	Humidity    float64 `yaml:"humidity"`
+	Soil        float64 `yaml:"soil"`
+}
+
+// Bounds are the values at or beyond which a sensor saturates. A healthy
+// sensor can rest there for hours, such as humidity at 100 % in fog or soil
+// at 0 % in dry substrate, so a run of them is not a frozen probe. Both
+// zero means the metric does not saturate.
+type Bounds struct {
+	Low  float64 `yaml:"low"`
+	High float64 `yaml:"high"`
+}
+
+// Saturation holds the bounds of each metric.
+type Saturation struct {
+	Temperature Bounds `yaml:"temperature"`
+	Humidity    Bounds `yaml:"humidity"`
+	Soil        Bounds `yaml:"soil"`
+}
+
+// Config tunes the checks.
+type Config struct {
+	// StuckSamples is how many identical readings in a row make a metric
+	// frozen.
+	StuckSamples int `yaml:"stuck_samples"`
+	// Window is how many previous readings the outlier check compares
+	// against.
+	Window int `yaml:"window"`
+	// Threshold is the robust z-score beyond which a reading is an outlier.
+	Threshold  float64    `yaml:"threshold"`
+	Steps      Steps      `yaml:"steps"`
+	Saturation Saturation `yaml:"saturation"`
+}
+
+// DefaultConfig suits sensors reporting every minute: half an hour of
+// identical values is a frozen probe. The threshold is above the usual 3.5
+// because greenhouse climate follows the sun and would otherwise flag every
+// morning.
+func DefaultConfig() Config {
+	return Config{
+		StuckSamples: 30,
+		Window:       60,
+		Threshold:    6,
+		Steps:        Steps{Temperature: 5, Humidity: 25, Soil: 15},
+		Saturation: Saturation{
+			Humidity: Bounds{Low: 0, High: 99},
+			Soil:     Bounds{Low: 0, High: 100},
+		},
+	}
+}
+
+// Validate checks that the settings make sense.
+func (c Config) Validate() error {
+	switch {
+	case c.StuckSamples < 2:
+		return fmt.Errorf("stuck_samples must be at least 2")
+	case c.Window < 10:
+		return fmt.Errorf("window must be at least 10")
+	case c.Threshold <= 0:
+		return fmt.Errorf("threshold must be positive")
+	case c.Steps.Temperature < 0 || c.Steps.Humidity < 0 || c.Steps.Soil < 0:
+		return fmt.Errorf("steps must not be negative")
+	}
+	for _, metric := range alerts.Measured {
+		if b := c.Saturation.bounds(metric); b != (Bounds{}) && b.Low >= b.High {
+			return fmt.Errorf("saturation.%s: low must be below high", metric)
+		}
+	}
+	return nil
+}
+
+// History is how many previous readings Check needs.
+func (c Config) History() int {
+	return max(c.Window, c.StuckSamples-1)
+}
+
+func (s Steps) limit(metric string) float64 {
+	switch metric {
+	case "temperature":
+		return s.Temperature
+	case "humidity":
+		return s.Humidity
+	}
+	return s.Soil
+}
+
+func (s Saturation) bounds(metric string) Bounds {
+	switch metric {
+	case "temperature":
+		return s.Temperature
+	case "humidity":
+		return s.Humidity
+	}
+	return s.Soil
+}
+
+// saturated reports whether value is at or beyond the metric's bounds.
+func (s Saturation) saturated(metric string, value float64) bool {
+	b := s.bounds(metric)
+	return b != (Bounds{}) && (value <= b.Low || value >= b.High)
+}
+
+// minMAD is the reporting resolution of the firmware. Values are sent with
+// one decimal, so a quiet sensor would otherwise have a spread of zero and
+// turn its next 0.1 wiggle into an outlier.
+const minMAD = 0.1
+
+// Check returns the anomalies of data given the device's previous readings,
+// oldest first, and the metrics that are currently frozen. A frozen run is
+// annotated once, on the reading that completes StuckSamples. Runs of
+// saturated values are never frozen.
+func (c Config) Check(history []models.SensorData, data *models.SensorData) (found []models.Anomaly, frozen []string) {
+	for _, metric := range alerts.Measured {
+		value, _ := alerts.MetricValue(data, metric)
+		note := func(kind string, score float64, format string, args ...interface{}) {
+			found = append(found, models.Anomaly{
+				ReadingID: data.ID,
+				DeviceID:  data.DeviceID,
+				Metric:    metric,
+				Kind:      kind,
+				Value:     value,
+				Score:     score,
+				Message:   metric + " " + fmt.Sprintf(format, args...),
+				Timestamp: data.Timestamp,
+			})
+		}
+
+		same := 1
+		for i := len(history) - 1; i >= 0; i-- {
+			if v, _ := alerts.MetricValue(&history[i], metric); v != value {
+				break
+			}
+			same++
+		}
+		if same >= c.StuckSamples && !c.Saturation.saturated(metric, value) {
+			frozen = append(frozen, metric)
+			if same == c.StuckSamples {
+				note(models.AnomalyStuck, float64(same), "frozen at %g for %d readings", value, same)
+			}
+		}
+
+		if len(history) > 0 {
+			prev, _ := alerts.MetricValue(&history[len(history)-1], metric)
+			if limit := c.Steps.limit(metric); limit > 0 && math.Abs(value-prev) > limit {
+				note(models.AnomalyStep, value-prev, "jumped from %g to %g", prev, value)
+			}
+		}
+
+		window := history[max(len(history)-c.Window, 0):]
+		if len(window) < c.Window/2 {
+			continue
+		}
+		values := make([]float64, len(window))
+		for i := range window {
+			values[i], _ = alerts.MetricValue(&window[i], metric)
+		}
+		last := values[len(values)-1]
+		med := median(values)
+		for i, v := range values {
+			values[i] = math.Abs(v - med)
+		}
+		mad := math.Max(median(values), minMAD)
+		// 0.6745 scales the MAD to the standard deviation of normal data.
+		z := 0.6745 * (value - med) / mad
+		// After a level shift, such as watering, the median lags for half
+		// a window. Only the first reading past the threshold is flagged.
+		zLast := 0.6745 * (last - med) / mad
+		if math.Abs(z) > c.Threshold && !(math.Abs(zLast) > c.Threshold && (z > 0) == (zLast > 0)) {
+			note(models.AnomalyOutlier, z, "%g is far from the recent median %g (z %.1f)", value, med, z)
+		}
+	}
+	return found, frozen
+}
+
+// FrozenAlert is the message of the alert raised while a metric is frozen.
+func FrozenAlert(metric string) string {
+	return metric + " sensor frozen, check the probe"
+}
+
+// median sorts values in place and returns their median.
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
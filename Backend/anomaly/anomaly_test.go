@@ -0,0 +1,148 @@
+package anomaly
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"my-smart-farm/models"
+	"my-smart-farm/store"
+	"my-smart-farm/store/storetest"
+)
+
+var start = time.Date(2025, 4, 4, 6, 0, 0, 0, time.Local)
+
+// series returns readings a minute apart with a gently varying climate and
+// soil values from soil.
+func series(soil ...float64) []models.SensorData {
+	rows := make([]models.SensorData, len(soil))
+	for i := range soil {
+		rows[i] = models.SensorData{
+			ID:          uint(i + 1),
+			DeviceID:    "sensor-001",
+			Temperature: 28 + math.Round(10*math.Sin(float64(i)))/10,
+			Humidity:    65 + math.Round(20*math.Cos(float64(i)))/10,
+			Soil:        soil[i],
+			Timestamp:   start.Add(time.Duration(i) * time.Minute),
+		}
+	}
+	return rows
+}
+
+func kinds(found []models.Anomaly) []string {
+	var list []string
+	for _, a := range found {
+		list = append(list, a.Metric+" "+a.Kind)
+	}
+	return list
+}
+
+func TestCheck(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StuckSamples = 5
+	cfg.Window = 10
+
+	noisy := []float64{40.1, 40.3, 39.9, 40.0, 40.2, 40.4, 39.8, 40.1, 40.0, 40.2}
+	rows := series(append(noisy, 40.3)...)
+	if found, frozen := cfg.Check(rows[:10], &rows[10]); len(found) != 0 || len(frozen) != 0 {
+		t.Errorf("normal noise flagged: %v, frozen %v", kinds(found), frozen)
+	}
+
+	rows = series(append(noisy, 47)...)
+	found, _ := cfg.Check(rows[:10], &rows[10])
+	if got := kinds(found); len(got) != 1 || got[0] != "soil outlier" || found[0].Score < cfg.Threshold {
+		t.Errorf("want a soil outlier, got %v", found)
+	}
+
+	rows = series(append(noisy, 20)...)
+	found, _ = cfg.Check(rows[:10], &rows[10])
+	if got := kinds(found); len(got) != 2 || got[0] != "soil step" || got[1] != "soil outlier" {
+		t.Errorf("want a soil step and outlier, got %v", got)
+	}
+
+	// After watering only the first reading at the new level is flagged.
+	rows = series(append(noisy, 47, 47.1)...)
+	if found, _ := cfg.Check(rows[:11], &rows[11]); len(found) != 0 {
+		t.Errorf("level shift flagged twice: %v", kinds(found))
+	}
+
+	// A corroded probe: the flat line is annotated once but stays frozen.
+	rows = series(40.1, 40.3, 33.3, 33.3, 33.3, 33.3, 33.3, 33.3)
+	for i, want := range []int{0, 0, 0, 0, 0, 0, 1, 0} {
+		found, frozen := cfg.Check(rows[:i], &rows[i])
+		if len(found) != want {
+			t.Errorf("reading %d: got %v", i, kinds(found))
+		}
+		if isFrozen := len(frozen) == 1 && frozen[0] == "soil"; isFrozen != (i >= 6) {
+			t.Errorf("reading %d: frozen %v", i, frozen)
+		}
+	}
+	// Fog holds humidity at 100 and bone-dry substrate holds soil at 0;
+	// both sensors are saturated, not frozen.
+	rows = series(0, 0, 0, 0, 0, 0)
+	for i := range rows {
+		rows[i].Humidity = 100
+	}
+	if found, frozen := cfg.Check(rows[:5], &rows[5]); len(found) != 0 || len(frozen) != 0 {
+		t.Errorf("saturated sensors flagged: %v, frozen %v", kinds(found), frozen)
+	}
+}
+
+type fakeAlerter struct{ calls []string }
+
+func (f *fakeAlerter) Raise(deviceID, message string) error {
+	f.calls = append(f.calls, "raise "+message)
+	return nil
+}
+
+func (f *fakeAlerter) Clear(deviceID, message string) error {
+	f.calls = append(f.calls, "clear "+message)
+	return nil
+}
+
+func TestDetector(t *testing.T) {
+	db := storetest.SQLite(t, &models.SensorData{}, &models.Anomaly{})
+	s := store.NewSQL(db)
+	d := NewDetector(db, s.Readings)
+	d.Config.StuckSamples = 3
+	alerter := &fakeAlerter{}
+	d.Alerts = alerter
+
+	for _, data := range series(40.1, 33.3, 33.3, 33.3, 33.3, 34.0) {
+		if err := s.Readings.Create(&data); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Analyze(&data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var stored []models.Anomaly
+	db.Order("id").Find(&stored)
+	if got := kinds(stored); len(got) != 1 || got[0] != "soil stuck" || stored[0].ReadingID != 4 {
+		t.Errorf("want one stuck annotation on reading 4, got %+v", stored)
+	}
+	// Every metric is cleared once at first sight; soil is raised when it
+	// freezes and cleared when it moves again.
+	want := []string{
+		"clear " + FrozenAlert("temperature"), "clear " + FrozenAlert("humidity"), "clear " + FrozenAlert("soil"),
+		"raise " + FrozenAlert("soil"), "clear " + FrozenAlert("soil"),
+	}
+	if len(alerter.calls) != len(want) {
+		t.Fatalf("alerts = %v, want %v", alerter.calls, want)
+	}
+	for i := range want {
+		if alerter.calls[i] != want[i] {
+			t.Fatalf("alerts = %v, want %v", alerter.calls, want)
+		}
+	}
+
+	// Rescanning history finds nothing new.
+	created, err := d.Scan(store.ReadingFilter{})
+	if err != nil || created != 0 {
+		t.Errorf("rescan created %d anomalies, err %v", created, err)
+	}
+	db.Where("1 = 1").Delete(&models.Anomaly{})
+	if created, err := d.Scan(store.ReadingFilter{}); err != nil || created != 1 {
+		t.Errorf("scan created %d anomalies, err %v", created, err)
+	}
+}
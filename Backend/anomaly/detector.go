@@ -0,0 +1,139 @@
+package anomaly
+
+import (
+	"log"
+	"slices"
+	"sync"
+
+	"my-smart-farm/alerts"
+	"my-smart-farm/models"
+	"my-smart-farm/store"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Alerter raises and clears system alerts; *alerts.Evaluator implements it.
+type Alerter interface {
+	Raise(deviceID, message string) error
+	Clear(deviceID, message string) error
+}
+
+// Detector checks each stored reading against the device's history.
+type Detector struct {
+	db       *gorm.DB
+	readings store.Readings
+
+	Config Config
+	// Alerts, when set, receives FrozenAlert while a metric is frozen.
+	Alerts Alerter
+
+	mu sync.Mutex
+	// frozen remembers, per device and metric, whether the last reading
+	// was frozen so alerts are only cleared on a change. Unknown pairs are
+	// cleared once to resolve alerts left open by a restart.
+	frozen map[[2]string]bool
+}
+
+func NewDetector(db *gorm.DB, readings store.Readings) *Detector {
+	return &Detector{
+		db:       db,
+		readings: readings,
+		Config:   DefaultConfig(),
+		frozen:   make(map[[2]string]bool),
+	}
+}
+
+// Hook adapts the detector to an ingest hook, logging failures.
+func (d *Detector) Hook(data *models.SensorData) {
+	if err := d.Analyze(data); err != nil {
+		log.Println("anomaly: analyze:", err)
+	}
+}
+
+// Analyze checks a stored reading, records its anomalies and raises or
+// clears frozen-sensor alerts.
+func (d *Detector) Analyze(data *models.SensorData) error {
+	history, err := d.readings.Find(store.ReadingQuery{
+		ReadingFilter: store.ReadingFilter{
+			DeviceIDs: []string{data.DeviceID},
+			To:        data.Timestamp,
+			Quality:   []string{models.QualityOK, models.QualitySuspect},
+		},
+		Limit: d.Config.History(),
+		Desc:  true,
+	})
+	if err != nil {
+		return err
+	}
+	slices.Reverse(history)
+	found, frozen := d.Config.Check(history, data)
+	if _, err := d.record(found); err != nil {
+		return err
+	}
+	if d.Alerts == nil {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, metric := range alerts.Measured {
+		key := [2]string{data.DeviceID, metric}
+		was, known := d.frozen[key]
+		is := slices.Contains(frozen, metric)
+		switch {
+		case is && !was:
+			err = d.Alerts.Raise(data.DeviceID, FrozenAlert(metric))
+		case !is && (was || !known):
+			err = d.Alerts.Clear(data.DeviceID, FrozenAlert(metric))
+		}
+		if err != nil {
+			return err
+		}
+		d.frozen[key] = is
+	}
+	return nil
+}
+
+// Scan checks the stored readings matching f, oldest first, and records
+// anomalies not found before. Readings at the start of the range are judged
+// on less history. Alerts are left alone since the readings are past. It
+// returns how many anomalies were new.
+func (d *Detector) Scan(f store.ReadingFilter) (int64, error) {
+	history := map[string][]models.SensorData{}
+	var found []models.Anomaly
+	// Anomalies are recorded once the cursor is closed; SQLite cannot
+	// commit a write while a read is still open.
+	err := d.readings.Each(f, func(data *models.SensorData) error {
+		past := history[data.DeviceID]
+		more, _ := d.Config.Check(past, data)
+		found = append(found, more...)
+		if len(past) == d.Config.History() {
+			past = past[1:]
+		}
+		history[data.DeviceID] = append(past, *data)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	var created int64
+	for start := 0; start < len(found); start += 100 {
+		n, err := d.record(found[start:min(start+100, len(found))])
+		created += n
+		if err != nil {
+			return created, err
+		}
+	}
+	return created, nil
+}
+
+// record stores anomalies, skipping those already stored for the same
+// reading, metric and kind.
+func (d *Detector) record(found []models.Anomaly) (int64, error) {
+	if len(found) == 0 {
+		return 0, nil
+	}
+	res := d.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&found)
+	return res.RowsAffected, res.Error
+}
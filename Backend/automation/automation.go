@@ -0,0 +1,266 @@
+// Package automation runs closed-loop irrigation: it switches a relay on
+// when a soil sensor reads too dry and off again after a bounded run.
+package automation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"my-smart-farm/models"
+	"my-smart-farm/relay"
+
+	"gorm.io/gorm"
+)
+
+// ErrSwitchOff is returned when a running relay could not be switched off.
+var ErrSwitchOff = errors.New("failed to switch off relay")
+
+// Engine applies IrrigationRules to incoming readings and enforces the
+// maximum run time and cool-down on a timer. Relay commands go through the
+// queue, so a slow or offline relay never holds up ingestion.
+type Engine struct {
+	db       *gorm.DB
+	commands *relay.Queue
+	// Tick is how often running relays are checked against MaxOnSeconds.
+	Tick time.Duration
+	// MaxAge is how old a reading may be and still switch a relay. Older
+	// readings, such as a backlog uploaded late, describe soil that has
+	// changed since.
+	MaxAge time.Duration
+	// Monitor, when set, stops the engine switching on offline relays.
+	Monitor *relay.Monitor
+
+	// mu serialises rule state changes between readings and the timer.
+	mu sync.Mutex
+}
+
+func NewEngine(db *gorm.DB, commands *relay.Queue) *Engine {
+	return &Engine{db: db, commands: commands, Tick: 10 * time.Second, MaxAge: 5 * time.Minute}
+}
+
+// Start runs the timer loop until ctx is done.
+func (e *Engine) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(e.Tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if err := e.CheckTimers(ctx, now); err != nil {
+					log.Println("automation:", err)
+				}
+			}
+		}
+	}()
+}
+
+// Hook adapts the engine to an ingest hook.
+func (e *Engine) Hook(data *models.SensorData) {
+	if err := e.OnReading(context.Background(), data, time.Now()); err != nil {
+		log.Println("automation:", err)
+	}
+}
+
+// OnReading starts watering for dry readings and stops it early once the
+// soil reaches the rule's target. Rules are judged at the reading's own
+// timestamp; readings more than MaxAge older than now are ignored.
+func (e *Engine) OnReading(ctx context.Context, data *models.SensorData, now time.Time) error {
+	at := data.Timestamp
+	if at.After(now) {
+		at = now
+	}
+	if now.Sub(at) > e.MaxAge {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var rules []models.IrrigationRule
+	if err := e.db.Where("enabled = ? AND sensor_id = ?", true, data.DeviceID).Find(&rules).Error; err != nil {
+		return err
+	}
+	for i := range rules {
+		rule := &rules[i]
+		switch {
+		case rule.State == models.AutomationWatering:
+			if rule.SoilTarget > 0 && data.Soil >= rule.SoilTarget {
+				e.switchOff(rule, at)
+			}
+		case data.Soil < rule.SoilBelow && e.cooledDown(rule, at):
+			e.switchOn(rule, at)
+		}
+	}
+	return nil
+}
+
+// CheckTimers stops relays that reached MaxOnSeconds and returns rules
+// whose cool-down has passed to idle. A rule whose off command was given up
+// on goes back to watering, so the next tick switches the relay off again.
+func (e *Engine) CheckTimers(ctx context.Context, now time.Time) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var rules []models.IrrigationRule
+	err := e.db.Where("state IN ?", []string{models.AutomationWatering, models.AutomationCooldown}).
+		Find(&rules).Error
+	if err != nil {
+		return err
+	}
+	for i := range rules {
+		rule := &rules[i]
+		switch rule.State {
+		case models.AutomationWatering:
+			// Disabled rules are still switched off so a relay is never left on.
+			if !rule.Enabled || rule.OnSince == nil ||
+				now.Sub(*rule.OnSince) >= time.Duration(rule.MaxOnSeconds)*time.Second {
+				e.switchOff(rule, now)
+			}
+		case models.AutomationCooldown:
+			if cmd := e.failedOff(rule); cmd != nil {
+				rule.State = models.AutomationWatering
+				rule.LastError = fmt.Sprintf("off command %d %s: %s", cmd.ID, cmd.Status, cmd.Error)
+				e.save(rule)
+			} else if e.cooledDown(rule, now) {
+				rule.State = models.AutomationIdle
+				e.save(rule)
+			}
+		}
+	}
+	return nil
+}
+
+func (e *Engine) cooledDown(rule *models.IrrigationRule, now time.Time) bool {
+	return rule.LastOffAt == nil ||
+		now.Sub(*rule.LastOffAt) >= time.Duration(rule.CooldownSeconds)*time.Second
+}
+
+func (e *Engine) switchOn(rule *models.IrrigationRule, now time.Time) {
+	if e.Monitor != nil {
+		if err := e.Monitor.Usable(rule.RelayID); err != nil {
+			rule.LastError = err.Error()
+			e.save(rule)
+			return
+		}
+	}
+	if err := e.send(rule, relay.ActionOn); err != nil {
+		rule.LastError = err.Error()
+		e.save(rule)
+		return
+	}
+	rule.State = models.AutomationWatering
+	rule.OnSince = &now
+	rule.LastError = ""
+	e.save(rule)
+}
+
+// switchOff leaves the rule watering when the command cannot be queued so
+// the next tick retries it.
+func (e *Engine) switchOff(rule *models.IrrigationRule, now time.Time) {
+	if err := e.send(rule, relay.ActionOff); err != nil {
+		rule.LastError = err.Error()
+		e.save(rule)
+		return
+	}
+	rule.State = models.AutomationCooldown
+	rule.OnSince = nil
+	rule.LastOffAt = &now
+	rule.LastError = ""
+	e.save(rule)
+}
+
+func (e *Engine) send(rule *models.IrrigationRule, action string) error {
+	_, err := e.commands.Enqueue(rule.RelayID, action, relay.ByRule(rule.ID))
+	return err
+}
+
+// failedOff returns the rule's off command when it is still the relay's
+// latest command and the queue gave up delivering it, so the relay may
+// still be on.
+func (e *Engine) failedOff(rule *models.IrrigationRule) *models.RelayCommand {
+	var cmd models.RelayCommand
+	if err := e.db.Where("device_id = ?", rule.RelayID).Order("id DESC").First(&cmd).Error; err != nil {
+		return nil
+	}
+	by := relay.ByRule(rule.ID)
+	if cmd.IssuedBy != by.Kind || cmd.IssuerID != by.ID || cmd.Action != relay.ActionOff ||
+		(cmd.Status != models.CommandFailed && cmd.Status != models.CommandExpired) {
+		return nil
+	}
+	return &cmd
+}
+
+func (e *Engine) save(rule *models.IrrigationRule) {
+	err := e.db.Model(rule).Select("State", "OnSince", "LastOffAt", "LastError").Updates(rule).Error
+	if err != nil {
+		log.Println("automation: save rule state:", err)
+	}
+}
+
+// StopRule switches the rule's relay off if the rule is currently watering.
+func (e *Engine) StopRule(ctx context.Context, id uint) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.stop(id)
+}
+
+// UpdateRule saves new settings of a rule, switching its relay off first
+// when the rule is disabled or moved to another relay. Both happen under
+// the engine lock so no reading acts on a half-updated rule.
+func (e *Engine) UpdateRule(ctx context.Context, id uint, settings models.IrrigationRule) (models.IrrigationRule, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var rule models.IrrigationRule
+	if err := e.db.First(&rule, id).Error; err != nil {
+		return rule, err
+	}
+	if !settings.Enabled || settings.RelayID != rule.RelayID {
+		if err := e.stop(id); err != nil {
+			return rule, err
+		}
+	}
+	err := e.db.Model(&rule).Select(
+		"Name", "SensorID", "RelayID", "SoilBelow", "SoilTarget",
+		"MaxOnSeconds", "CooldownSeconds", "Enabled",
+	).Updates(&settings).Error
+	if err != nil {
+		return rule, err
+	}
+	err = e.db.First(&rule, id).Error
+	return rule, err
+}
+
+// DeleteRule switches the rule's relay off if it is running and deletes
+// the rule, so the timer cannot lose track of a relay left on.
+func (e *Engine) DeleteRule(ctx context.Context, id uint) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.stop(id); err != nil {
+		return err
+	}
+	return e.db.Delete(&models.IrrigationRule{}, id).Error
+}
+
+// stop does the work of StopRule; the caller holds mu.
+func (e *Engine) stop(id uint) error {
+	var rule models.IrrigationRule
+	if err := e.db.First(&rule, id).Error; err != nil {
+		return err
+	}
+	if rule.State != models.AutomationWatering {
+		return nil
+	}
+	e.switchOff(&rule, time.Now())
+	if rule.State == models.AutomationWatering {
+		return fmt.Errorf("%w %s: %s", ErrSwitchOff, rule.RelayID, rule.LastError)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package automation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"my-smart-farm/models"
+	"my-smart-farm/relay"
+	"my-smart-farm/store/storetest"
+)
+
+func TestIrrigationCycle(t *testing.T) {
+	var actions []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actions = append(actions, strings.TrimPrefix(r.URL.Path, "/relay/"))
+	}))
+	defer srv.Close()
+
+	db := storetest.SQLite(t, &models.IrrigationRule{}, &models.RelayDevice{}, &models.RelayCommand{})
+	db.Create(&models.RelayDevice{DeviceID: "relay-001", IP: strings.TrimPrefix(srv.URL, "http://"), Updated: time.Now()})
+	rule := models.IrrigationRule{
+		SensorID: "sensor-001", RelayID: "relay-001",
+		SoilBelow: 30, MaxOnSeconds: 60, CooldownSeconds: 300,
+		Enabled: true, State: models.AutomationIdle,
+	}
+	db.Create(&rule)
+
+	queue := relay.NewQueue(db, relay.NewClient(db))
+	e := NewEngine(db, queue)
+	ctx := context.Background()
+	t0 := time.Now()
+	reading := func(soil float64, at time.Time) {
+		t.Helper()
+		if err := e.OnReading(ctx, &models.SensorData{DeviceID: "sensor-001", Soil: soil, Timestamp: at}, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state := func() string {
+		var r models.IrrigationRule
+		db.First(&r, rule.ID)
+		return r.State
+	}
+
+	backlog := &models.SensorData{DeviceID: "sensor-001", Soil: 10, Timestamp: t0.Add(-time.Hour)}
+	if err := e.OnReading(ctx, backlog, t0); err != nil || state() != models.AutomationIdle {
+		t.Fatal("an hour-old reading should not start watering, state", state(), err)
+	}
+	reading(25, t0)
+	if state() != models.AutomationWatering {
+		t.Fatal("dry reading should start watering, state", state())
+	}
+	if len(actions) != 0 {
+		t.Fatal("the relay should be reached by the queue, not the reading")
+	}
+	queue.ProcessDue(ctx, time.Now())
+	reading(20, t0.Add(10*time.Second))
+	e.CheckTimers(ctx, t0.Add(61*time.Second))
+	queue.ProcessDue(ctx, time.Now())
+	if state() != models.AutomationCooldown {
+		t.Fatal("max run time should stop watering, state", state())
+	}
+	reading(20, t0.Add(2*time.Minute))
+	if state() != models.AutomationCooldown {
+		t.Error("dry reading during cool-down should not restart watering")
+	}
+	e.CheckTimers(ctx, t0.Add(7*time.Minute))
+	if state() != models.AutomationIdle {
+		t.Error("rule should be idle after cool-down, state", state())
+	}
+	if strings.Join(actions, ",") != "on,off" {
+		t.Errorf("relay got %v, want [on off]", actions)
+	}
+}
+
+type fakeAlerts struct{ raised []string }
+
+func (f *fakeAlerts) Raise(deviceID, message string) error {
+	f.raised = append(f.raised, deviceID+": "+message)
+	return nil
+}
+
+func (f *fakeAlerts) Clear(deviceID, message string) error { return nil }
+
+func TestOfflineRelayRefused(t *testing.T) {
+	db := storetest.SQLite(t, &models.IrrigationRule{}, &models.RelayDevice{}, &models.RelayCommand{})
+	stale := time.Now().Add(-time.Hour)
+	db.Create(&models.RelayDevice{DeviceID: "relay-001", IP: "127.0.0.1:1", Updated: stale, LastHeartbeat: &stale})
+	rule := models.IrrigationRule{
+		SensorID: "sensor-001", RelayID: "relay-001",
+		SoilBelow: 30, MaxOnSeconds: 60, Enabled: true, State: models.AutomationIdle,
+	}
+	db.Create(&rule)
+
+	alerts := &fakeAlerts{}
+	e := NewEngine(db, relay.NewQueue(db, relay.NewClient(db)))
+	e.Monitor = relay.NewMonitor(db)
+	e.Monitor.Alerts = alerts
+	e.OnReading(context.Background(), &models.SensorData{DeviceID: "sensor-001", Soil: 10, Timestamp: time.Now()}, time.Now())
+
+	db.First(&rule, rule.ID)
+	if rule.State != models.AutomationIdle || rule.LastError != relay.ErrRelayOffline.Error() {
+		t.Errorf("offline relay should be refused, state %s error %q", rule.State, rule.LastError)
+	}
+	var sent int64
+	db.Model(&models.RelayCommand{}).Count(&sent)
+	if sent != 0 {
+		t.Error("no command should be sent to an offline relay")
+	}
+	if len(alerts.raised) != 1 || alerts.raised[0] != "relay-001: "+relay.OfflineAlert {
+		t.Errorf("raised %v", alerts.raised)
+	}
+}
+
+func TestFailedOffRetried(t *testing.T) {
+	db := storetest.SQLite(t, &models.IrrigationRule{}, &models.RelayDevice{}, &models.RelayCommand{})
+	db.Create(&models.RelayDevice{DeviceID: "relay-001", IP: "127.0.0.1:1", Updated: time.Now()})
+	rule := models.IrrigationRule{
+		SensorID: "sensor-001", RelayID: "relay-001",
+		SoilBelow: 30, MaxOnSeconds: 60, CooldownSeconds: 300,
+		Enabled: true, State: models.AutomationIdle,
+	}
+	db.Create(&rule)
+
+	queue := relay.NewQueue(db, relay.NewClient(db))
+	queue.MaxAttempts = 1
+	e := NewEngine(db, queue)
+	ctx := context.Background()
+	t0 := time.Now()
+	e.OnReading(ctx, &models.SensorData{DeviceID: "sensor-001", Soil: 10, Timestamp: t0}, t0)
+	e.CheckTimers(ctx, t0.Add(61*time.Second))
+	queue.ProcessDue(ctx, time.Now())
+
+	// The unreachable relay may still be on, so the rule goes back to
+	// watering and the next tick queues another off command.
+	e.CheckTimers(ctx, t0.Add(71*time.Second))
+	db.First(&rule, rule.ID)
+	if rule.State != models.AutomationWatering || rule.LastError == "" {
+		t.Fatalf("failed off command not noticed: state %s error %q", rule.State, rule.LastError)
+	}
+	e.CheckTimers(ctx, t0.Add(81*time.Second))
+	var cmd models.RelayCommand
+	db.Order("id DESC").First(&cmd)
+	if cmd.Action != relay.ActionOff || cmd.Status != models.CommandPending {
+		t.Errorf("off command not queued again: %+v", cmd)
+	}
+}
@@ -0,0 +1,158 @@
+// Package calibration turns raw soil probe readings into moisture with the
+// probe's own calibration, and recomputes stored history when a probe is
+// recalibrated. Probes without a calibration keep the moisture the firmware
+// computed, which assumes the full ADC range spans dry to wet.
+package calibration
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"my-smart-farm/models"
+	"my-smart-farm/quality"
+	"my-smart-farm/store"
+)
+
+// MaxRaw is the largest value of the 16-bit ADC.
+const MaxRaw = 65535
+
+// Validate checks that points describe a usable curve: at least two points
+// within the ADC and percent ranges, with moisture strictly rising or
+// strictly falling as the raw value grows.
+func Validate(points []models.SoilCalibrationPoint) error {
+	if len(points) < 2 {
+		return errors.New("need at least two points")
+	}
+	sorted := sortedPoints(points)
+	for _, p := range sorted {
+		if p.Raw < 0 || p.Raw > MaxRaw {
+			return fmt.Errorf("raw %d outside 0-%d", p.Raw, MaxRaw)
+		}
+		if p.Percent < 0 || p.Percent > 100 {
+			return fmt.Errorf("percent %g outside 0-100", p.Percent)
+		}
+	}
+	rising := sorted[1].Percent > sorted[0].Percent
+	for i := 1; i < len(sorted); i++ {
+		a, b := sorted[i-1], sorted[i]
+		if a.Raw == b.Raw {
+			return fmt.Errorf("raw %d given twice", a.Raw)
+		}
+		if b.Percent == a.Percent || (b.Percent > a.Percent) != rising {
+			return errors.New("percent must rise or fall steadily with raw")
+		}
+	}
+	return nil
+}
+
+func sortedPoints(points []models.SoilCalibrationPoint) []models.SoilCalibrationPoint {
+	sorted := append([]models.SoilCalibrationPoint(nil), points...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Raw < sorted[j].Raw })
+	return sorted
+}
+
+// Moisture interpolates raw between the neighbouring points. Values beyond
+// the outer points are held at their percent rather than extrapolated, so a
+// probe drier than its dry point reads as dry, which grading keeps as a
+// suspect reading rather than rejecting it. The result is rounded to one
+// decimal like firmware values.
+func Moisture(points []models.SoilCalibrationPoint, raw int) float64 {
+	sorted := sortedPoints(points)
+	i := sort.Search(len(sorted), func(i int) bool { return sorted[i].Raw >= raw })
+	var percent float64
+	switch {
+	case i == 0:
+		percent = sorted[0].Percent
+	case i == len(sorted):
+		percent = sorted[len(sorted)-1].Percent
+	default:
+		a, b := sorted[i-1], sorted[i]
+		percent = a.Percent + (b.Percent-a.Percent)*float64(raw-a.Raw)/float64(b.Raw-a.Raw)
+	}
+	return math.Round(percent*10) / 10
+}
+
+// Apply sets Soil from SoilRaw for readings of calibrated probes.
+func Apply(cals store.Calibrations, readings ...*models.SensorData) error {
+	cache := map[string]*models.SoilCalibration{}
+	for _, data := range readings {
+		if data.SoilRaw == nil {
+			continue
+		}
+		cal, ok := cache[data.DeviceID]
+		if !ok {
+			found, err := cals.Get(data.DeviceID)
+			switch {
+			case err == nil:
+				cal = &found
+			case !errors.Is(err, store.ErrNotFound):
+				return err
+			}
+			cache[data.DeviceID] = cal
+		}
+		if cal != nil {
+			data.Soil = Moisture(cal.Points, *data.SoilRaw)
+		}
+	}
+	return nil
+}
+
+// Recompute recalculates Soil of the device's stored readings in
+// [from, to) that carry a raw value, using its current calibration. With
+// rules, every reading in the range is graded again in time order, as the
+// pipeline would have graded it had the calibration been in place. It
+// returns how many readings changed. Zero times leave the range open.
+// Readings after the range and rollups of compacted readings are left as
+// they are.
+func Recompute(s *store.Store, rules *quality.Rules, deviceID string, from, to time.Time) (int, error) {
+	cal, err := s.Calibrations.Get(deviceID)
+	if err != nil {
+		return 0, err
+	}
+	var prev *models.SensorData
+	if rules != nil && !from.IsZero() {
+		rows, err := s.Readings.Find(store.ReadingQuery{
+			ReadingFilter: store.ReadingFilter{
+				DeviceIDs: []string{deviceID},
+				To:        from,
+				Quality:   []string{models.QualityOK, models.QualitySuspect},
+			},
+			Limit: 1,
+			Desc:  true,
+		})
+		if err != nil {
+			return 0, err
+		}
+		if len(rows) > 0 {
+			prev = &rows[0]
+		}
+	}
+
+	updates := map[uint]store.SoilUpdate{}
+	// Updates are written once the cursor is closed; SQLite cannot commit
+	// a write while a read is still open.
+	f := store.ReadingFilter{DeviceIDs: []string{deviceID}, From: from, To: to}
+	err = s.Readings.Each(f, func(data *models.SensorData) error {
+		was := store.SoilUpdate{Soil: data.Soil, Quality: data.Quality, QualityNote: data.QualityNote}
+		if data.SoilRaw != nil {
+			data.Soil = Moisture(cal.Points, *data.SoilRaw)
+		}
+		if rules != nil {
+			data.Quality, data.QualityNote = rules.Grade(data, prev)
+			if data.Quality != models.QualityRejected {
+				prev = data
+			}
+		}
+		if now := (store.SoilUpdate{Soil: data.Soil, Quality: data.Quality, QualityNote: data.QualityNote}); now != was {
+			updates[data.ID] = now
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(updates), s.Readings.UpdateSoil(updates)
+}
@@ -0,0 +1,132 @@
+package calibration
+
+import (
+	"testing"
+	"time"
+
+	"my-smart-farm/models"
+	"my-smart-farm/quality"
+	"my-smart-farm/store"
+)
+
+func TestMoisture(t *testing.T) {
+	twoPoint := []models.SoilCalibrationPoint{{Raw: 21000, Percent: 100}, {Raw: 52000, Percent: 0}}
+	piecewise := []models.SoilCalibrationPoint{{Raw: 52000, Percent: 0}, {Raw: 40000, Percent: 30}, {Raw: 21000, Percent: 100}}
+	for _, tc := range []struct {
+		points []models.SoilCalibrationPoint
+		raw    int
+		want   float64
+	}{
+		{twoPoint, 52000, 0},
+		{twoPoint, 21000, 100},
+		{twoPoint, 36500, 50},
+		{twoPoint, 60000, 0},
+		{twoPoint, 15000, 100},
+		{piecewise, 46000, 15},
+		{piecewise, 30500, 65},
+	} {
+		if got := Moisture(tc.points, tc.raw); got != tc.want {
+			t.Errorf("Moisture(%v, %d) = %g, want %g", tc.points, tc.raw, got, tc.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, points := range [][]models.SoilCalibrationPoint{
+		{{Raw: 52000, Percent: 0}},
+		{{Raw: 52000, Percent: 0}, {Raw: 52000, Percent: 100}},
+		{{Raw: 52000, Percent: 0}, {Raw: 21000, Percent: 0}},
+		{{Raw: 52000, Percent: 0}, {Raw: 40000, Percent: 60}, {Raw: 21000, Percent: 50}},
+		{{Raw: 70000, Percent: 0}, {Raw: 21000, Percent: 100}},
+		{{Raw: 52000, Percent: -5}, {Raw: 21000, Percent: 100}},
+	} {
+		if Validate(points) == nil {
+			t.Errorf("%v accepted", points)
+		}
+	}
+	if err := Validate([]models.SoilCalibrationPoint{{Raw: 52000, Percent: 0}, {Raw: 21000, Percent: 100}}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestApplyAndRecompute(t *testing.T) {
+	s := store.NewMemory()
+	at := time.Date(2025, 4, 4, 6, 0, 0, 0, time.Local)
+	raw := func(v int) *int { return &v }
+	old := []models.SensorData{
+		{DeviceID: "sensor-001", Soil: 44.6, SoilRaw: raw(36300), Timestamp: at},
+		{DeviceID: "sensor-001", Soil: 31, Timestamp: at.Add(time.Minute)},
+		{DeviceID: "sensor-002", Soil: 44.6, SoilRaw: raw(36300), Timestamp: at},
+	}
+	for i := range old {
+		s.Readings.Create(&old[i])
+	}
+
+	if _, err := Recompute(s, nil, "sensor-001", time.Time{}, time.Time{}); err != store.ErrNotFound {
+		t.Errorf("recompute without calibration: %v", err)
+	}
+	s.Calibrations.Put(models.SoilCalibration{
+		DeviceID: "sensor-001",
+		Points:   []models.SoilCalibrationPoint{{Raw: 52000, Percent: 0}, {Raw: 21000, Percent: 100}},
+	})
+
+	fresh := []*models.SensorData{
+		{DeviceID: "sensor-001", Soil: 44.6, SoilRaw: raw(36500)},
+		{DeviceID: "sensor-002", Soil: 44.6, SoilRaw: raw(36500)},
+	}
+	if err := Apply(s.Calibrations, fresh...); err != nil {
+		t.Fatal(err)
+	}
+	if fresh[0].Soil != 50 || fresh[1].Soil != 44.6 {
+		t.Errorf("only the calibrated probe should change: %v, %v", fresh[0].Soil, fresh[1].Soil)
+	}
+
+	updated, err := Recompute(s, nil, "sensor-001", time.Time{}, time.Time{})
+	if err != nil || updated != 1 {
+		t.Fatalf("recompute updated %d readings, err %v", updated, err)
+	}
+	rows, _ := s.Readings.Find(store.ReadingQuery{Limit: 10})
+	for _, d := range rows {
+		want := map[string]float64{"sensor-001": 50.6, "sensor-002": 44.6}[d.DeviceID]
+		if d.SoilRaw == nil {
+			want = 31
+		}
+		if d.Soil != want {
+			t.Errorf("%s at %s: soil %g, want %g", d.DeviceID, d.Timestamp, d.Soil, want)
+		}
+	}
+}
+
+func TestRecomputeRegrades(t *testing.T) {
+	s := store.NewMemory()
+	at := time.Date(2025, 4, 4, 6, 0, 0, 0, time.Local)
+	raw := func(v int) *int { return &v }
+	// Graded with the firmware's estimate: the third reading dropped 30
+	// points in a minute. Recalibrated, the first two are bone dry and the
+	// drop disappears.
+	old := []models.SensorData{
+		{DeviceID: "sensor-001", Soil: 30, SoilRaw: raw(45875), Quality: models.QualityOK, Timestamp: at},
+		{DeviceID: "sensor-001", Soil: 30, SoilRaw: raw(45875), Quality: models.QualityOK, Timestamp: at.Add(time.Minute)},
+		{DeviceID: "sensor-001", Soil: 0, SoilRaw: raw(65535), Quality: models.QualitySuspect, QualityNote: "soil changed 30.0 per minute, limit 25", Timestamp: at.Add(2 * time.Minute)},
+	}
+	for i := range old {
+		old[i].Temperature, old[i].Humidity = 28, 70
+		s.Readings.Create(&old[i])
+	}
+	s.Calibrations.Put(models.SoilCalibration{
+		DeviceID: "sensor-001",
+		Points:   []models.SoilCalibrationPoint{{Raw: 40000, Percent: 0}, {Raw: 20000, Percent: 100}},
+	})
+
+	rules := quality.DefaultRules()
+	updated, err := Recompute(s, &rules, "sensor-001", time.Time{}, time.Time{})
+	if err != nil || updated != 3 {
+		t.Fatalf("recompute updated %d readings, err %v", updated, err)
+	}
+	rows, _ := s.Readings.Find(store.ReadingQuery{Limit: 10})
+	for _, d := range rows {
+		if d.Soil != 0 || d.Quality != models.QualitySuspect || d.QualityNote != "soil 0 outside plausible range [0.1, 100]" {
+			t.Errorf("at %s: soil %g graded %s (%s), want dry and suspect", d.Timestamp, d.Soil, d.Quality, d.QualityNote)
+		}
+	}
+}
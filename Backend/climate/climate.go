@@ -0,0 +1,331 @@
+// Package climate summarises each sensor's local days into min/max/mean
+// climate and growing degree days (GDD), and sums GDD since the planting
+// date of the crop the sensor watches.
+package climate
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"my-smart-farm/aggregate"
+	"my-smart-farm/models"
+	"my-smart-farm/store"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const day = 24 * time.Hour
+
+// Status reports the outcome of the most recent run.
+type Status struct {
+	LastRun        time.Time `json:"last_run"`
+	LastError      string    `json:"last_error,omitempty"`
+	DaysSummarised int64     `json:"days_summarised"`
+}
+
+// Job summarises finished days shortly after local midnight.
+type Job struct {
+	db       *gorm.DB
+	readings store.Readings
+	// BaseTemperature is the GDD base in °C for devices whose planting
+	// does not set one.
+	BaseTemperature float64
+	// Lookback is how many finished days are summarised again on each run,
+	// so readings that devices upload late are counted.
+	Lookback int
+	// MinHours is how many hours of a day need readings for the day to be
+	// complete and count towards GDD.
+	MinHours int
+
+	// work serialises runs with recalculations after planting changes.
+	work   sync.Mutex
+	mu     sync.Mutex
+	status Status
+}
+
+func NewJob(db *gorm.DB, readings store.Readings) *Job {
+	return &Job{
+		db:              db,
+		readings:        readings,
+		BaseTemperature: 10,
+		Lookback:        2,
+		MinHours:        20,
+	}
+}
+
+// Start runs the job immediately and then a few minutes after every local
+// midnight until ctx is done.
+func (j *Job) Start(ctx context.Context) {
+	go func() {
+		for {
+			now := time.Now()
+			if err := j.RunOnce(now); err != nil {
+				log.Println("climate:", err)
+			}
+			next := aggregate.BucketStart(now, day).Add(day + 5*time.Minute)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(next)):
+			}
+		}
+	}()
+}
+
+func (j *Job) Status() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// Gap is a day missing from a device's summaries, or summarised from too
+// few hours to be complete.
+type Gap struct {
+	DeviceID string    `json:"device_id"`
+	Day      time.Time `json:"day"`
+	Reason   string    `json:"reason"` // missing or incomplete
+}
+
+// Gaps lists the gaps in days, which must be ordered by device and day:
+// incomplete days and the days missing between two summaries.
+func Gaps(days []models.DailyClimate) []Gap {
+	gaps := []Gap{}
+	for i, d := range days {
+		if i > 0 && days[i-1].DeviceID == d.DeviceID {
+			for missing := days[i-1].Day.AddDate(0, 0, 1); missing.Before(d.Day); missing = missing.AddDate(0, 0, 1) {
+				gaps = append(gaps, Gap{DeviceID: d.DeviceID, Day: missing, Reason: "missing"})
+			}
+		}
+		if !d.Complete {
+			gaps = append(gaps, Gap{DeviceID: d.DeviceID, Day: d.Day, Reason: "incomplete"})
+		}
+	}
+	return gaps
+}
+
+// GDD is the growing degree days of one day by the averaging method.
+func GDD(tmin, tmax, base float64) float64 {
+	return math.Max((tmin+tmax)/2-base, 0)
+}
+
+// RunOnce summarises every device's finished days that have no summary yet,
+// plus the last Lookback days, and refreshes GDD.
+func (j *Job) RunOnce(now time.Time) error {
+	today := aggregate.BucketStart(now, day)
+	j.work.Lock()
+	defer j.work.Unlock()
+	deviceIDs, err := j.readings.DeviceIDs()
+	if err == nil {
+		// Devices whose readings were all compacted survive as rollups.
+		var compacted []string
+		err = j.db.Model(&models.DailyRollup{}).Distinct().Pluck("device_id", &compacted).Error
+		for _, id := range compacted {
+			if !slices.Contains(deviceIDs, id) {
+				deviceIDs = append(deviceIDs, id)
+			}
+		}
+	}
+
+	var total int64
+	for _, id := range deviceIDs {
+		if err != nil {
+			break
+		}
+		var n int64
+		n, err = j.summarise(id, today)
+		total += n
+		if err == nil {
+			err = j.recalculate(id)
+		}
+	}
+
+	j.mu.Lock()
+	j.status.LastRun = now
+	j.status.DaysSummarised += total
+	j.status.LastError = ""
+	if err != nil {
+		j.status.LastError = err.Error()
+	}
+	j.mu.Unlock()
+	return err
+}
+
+// summarise writes the device's summaries of the days before today that are
+// missing or within the lookback.
+func (j *Job) summarise(deviceID string, today time.Time) (int64, error) {
+	var last []models.DailyClimate
+	err := j.db.Where("device_id = ?", deviceID).Order("day DESC").Limit(1).Find(&last).Error
+	if err != nil {
+		return 0, err
+	}
+	from := today.AddDate(0, 0, -j.Lookback)
+	if len(last) == 0 {
+		// First run for the device: start from its oldest data.
+		from = time.Time{}
+	} else if next := last[0].Day.AddDate(0, 0, 1); next.Before(from) {
+		from = next
+	}
+	if !from.Before(today) {
+		return 0, nil
+	}
+
+	days := map[int64]models.DailyClimate{}
+	hours := map[int64]int{}
+	acc := aggregate.NewAccumulator(day)
+	hourly := aggregate.NewAccumulator(time.Hour)
+	err = j.readings.Each(store.ReadingFilter{
+		DeviceIDs: []string{deviceID},
+		From:      from,
+		To:        today,
+		Quality:   []string{models.QualityOK, models.QualitySuspect},
+	}, func(data *models.SensorData) error {
+		acc.Add(data)
+		hourly.Add(data)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, b := range hourly.Buckets() {
+		hours[aggregate.BucketStart(b.Start, day).Unix()]++
+	}
+	for _, b := range acc.Buckets() {
+		days[b.Start.Unix()] = models.DailyClimate{
+			DeviceID:        deviceID,
+			Day:             b.Start,
+			Count:           b.Count,
+			HoursCovered:    hours[b.Start.Unix()],
+			TemperatureMin:  b.Temperature.Min,
+			TemperatureMax:  b.Temperature.Max,
+			TemperatureMean: b.Temperature.Avg,
+			HumidityMin:     b.Humidity.Min,
+			HumidityMax:     b.Humidity.Max,
+			HumidityMean:    b.Humidity.Avg,
+		}
+	}
+
+	// Days whose raw readings were already compacted survive as rollups;
+	// their hourly rollups tell how much of the day was covered.
+	var rollups []models.DailyRollup
+	var hourlies []models.HourlyRollup
+	inRange := func() *gorm.DB {
+		tx := j.db.Where("device_id = ? AND start < ?", deviceID, today)
+		if !from.IsZero() {
+			tx = tx.Where("start >= ?", from)
+		}
+		return tx
+	}
+	if err := inRange().Find(&rollups).Error; err != nil {
+		return 0, err
+	}
+	if err := inRange().Select("start").Find(&hourlies).Error; err != nil {
+		return 0, err
+	}
+	rollupHours := map[int64]int{}
+	for _, h := range hourlies {
+		rollupHours[aggregate.BucketStart(h.Start, day).Unix()]++
+	}
+	for _, r := range rollups {
+		start := aggregate.BucketStart(r.Start, day)
+		if _, ok := days[start.Unix()]; ok {
+			continue
+		}
+		days[start.Unix()] = models.DailyClimate{
+			DeviceID:        deviceID,
+			Day:             start,
+			Count:           r.Count,
+			HoursCovered:    rollupHours[start.Unix()],
+			TemperatureMin:  r.Temperature.Min,
+			TemperatureMax:  r.Temperature.Max,
+			TemperatureMean: r.Temperature.Avg,
+			HumidityMin:     r.Humidity.Min,
+			HumidityMax:     r.Humidity.Max,
+			HumidityMean:    r.Humidity.Avg,
+		}
+	}
+
+	if len(days) == 0 {
+		return 0, nil
+	}
+	rows := make([]models.DailyClimate, 0, len(days))
+	for _, d := range days {
+		rows = append(rows, d)
+	}
+	// Complete and the GDD columns are filled in by Recalculate.
+	err = j.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "device_id"}, {Name: "day"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"count", "hours_covered", "temperature_min", "temperature_max", "temperature_mean",
+			"humidity_min", "humidity_max", "humidity_mean",
+		}),
+	}).Create(&rows).Error
+	return int64(len(rows)), err
+}
+
+// Recalculate recomputes completeness, GDD and cumulative GDD of all the
+// device's summaries from its current planting and base temperature. It is
+// run after every summary and when a planting changes, never at the same
+// time as a run.
+func (j *Job) Recalculate(deviceID string) error {
+	j.work.Lock()
+	defer j.work.Unlock()
+	return j.recalculate(deviceID)
+}
+
+func (j *Job) recalculate(deviceID string) error {
+	var planting *models.Planting
+	var p models.Planting
+	err := j.db.First(&p, "device_id = ?", deviceID).Error
+	switch {
+	case err == nil:
+		planting = &p
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return err
+	}
+	base := j.BaseTemperature
+	if planting != nil && planting.BaseTemperature != nil {
+		base = *planting.BaseTemperature
+	}
+
+	var rows []models.DailyClimate
+	if err := j.db.Where("device_id = ?", deviceID).Order("day").Find(&rows).Error; err != nil {
+		return err
+	}
+	return j.db.Transaction(func(tx *gorm.DB) error {
+		var sum float64
+		for _, r := range rows {
+			complete := r.HoursCovered >= j.MinHours
+			var gdd *float64
+			if complete {
+				gdd = new(float64)
+				*gdd = GDD(r.TemperatureMin, r.TemperatureMax, base)
+			}
+			var cumulative *float64
+			if planting != nil && !r.Day.Before(planting.PlantedOn) {
+				if gdd != nil {
+					sum += *gdd
+				}
+				cumulative = new(float64)
+				*cumulative = sum
+			}
+			err := tx.Model(&models.DailyClimate{}).
+				Where("device_id = ? AND day = ?", r.DeviceID, r.Day).
+				Updates(map[string]interface{}{
+					"complete":         complete,
+					"base_temperature": base,
+					"gdd":              gdd,
+					"cumulative_gdd":   cumulative,
+				}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
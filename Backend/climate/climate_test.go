@@ -0,0 +1,125 @@
+package climate
+
+import (
+	"testing"
+	"time"
+
+	"my-smart-farm/models"
+	"my-smart-farm/store"
+	"my-smart-farm/store/storetest"
+)
+
+func TestRunOnce(t *testing.T) {
+	db := storetest.SQLite(t, &models.SensorData{}, &models.HourlyRollup{}, &models.DailyRollup{}, &models.Planting{}, &models.DailyClimate{})
+	s := store.NewSQL(db)
+	day1 := time.Date(2025, 4, 1, 0, 0, 0, 0, time.Local)
+	reading := func(day time.Time, hour int, temp float64) {
+		s.Readings.Create(&models.SensorData{
+			DeviceID: "sensor-001", Temperature: temp, Humidity: 70,
+			Timestamp: day.Add(time.Duration(hour) * time.Hour),
+		})
+	}
+	// fullDay records a reading every hour, coldest at 05:00 and warmest
+	// at 14:00.
+	fullDay := func(day time.Time, tmin, tmax float64) {
+		for hour := 0; hour < 24; hour++ {
+			temp := (tmin + tmax) / 2
+			switch hour {
+			case 5:
+				temp = tmin
+			case 14:
+				temp = tmax
+			}
+			reading(day, hour, temp)
+		}
+	}
+	// Day 1 was compacted already; days 2 and 3 are raw; day 4 is today.
+	db.Create(&models.DailyRollup{SensorRollup: models.SensorRollup{
+		DeviceID: "sensor-001", Start: day1, Count: 24,
+		Temperature: models.RollupStats{Min: 18, Max: 30, Avg: 24},
+	}})
+	for hour := 0; hour < 24; hour++ {
+		db.Create(&models.HourlyRollup{SensorRollup: models.SensorRollup{
+			DeviceID: "sensor-001", Start: day1.Add(time.Duration(hour) * time.Hour), Count: 1,
+		}})
+	}
+	fullDay(day1.AddDate(0, 0, 1), 20, 34)
+	fullDay(day1.AddDate(0, 0, 2), 8, 10)
+	reading(day1.AddDate(0, 0, 3), 5, 40)
+	db.Create(&models.Planting{DeviceID: "sensor-001", PlantedOn: day1.AddDate(0, 0, 1)})
+
+	job := NewJob(db, s.Readings)
+	now := day1.AddDate(0, 0, 3).Add(time.Hour)
+	if err := job.RunOnce(now); err != nil {
+		t.Fatal(err)
+	}
+	check := func(wantGDD []float64, wantCumulative []float64) {
+		t.Helper()
+		var days []models.DailyClimate
+		db.Order("day").Find(&days)
+		if len(days) != len(wantGDD) {
+			t.Fatalf("got %d days, want %d: %+v", len(days), len(wantGDD), days)
+		}
+		for i, d := range days {
+			gdd, cumulative := -1.0, -1.0
+			if d.GDD != nil {
+				gdd = *d.GDD
+			}
+			if d.CumulativeGDD != nil {
+				cumulative = *d.CumulativeGDD
+			}
+			if gdd != wantGDD[i] || cumulative != wantCumulative[i] {
+				t.Errorf("day %d: gdd %g cumulative %g, want %g and %g", i+1, gdd, cumulative, wantGDD[i], wantCumulative[i])
+			}
+		}
+	}
+	// Base 10: day 1 (18+30)/2-10, day 2 (20+34)/2-10, day 3 below base.
+	check([]float64{14, 17, 0}, []float64{-1, 17, 17})
+
+	// A late reading for day 3 is picked up by the lookback.
+	reading(day1.AddDate(0, 0, 2), 15, 30)
+	if err := job.RunOnce(now); err != nil {
+		t.Fatal(err)
+	}
+	check([]float64{14, 17, 9}, []float64{-1, 17, 26})
+
+	base := 12.0
+	db.Model(&models.Planting{}).Where("device_id = ?", "sensor-001").Update("base_temperature", base)
+	if err := job.Recalculate("sensor-001"); err != nil {
+		t.Fatal(err)
+	}
+	check([]float64{12, 15, 7}, []float64{-1, 15, 22})
+
+	// A day with readings in a few hours only is incomplete: its GDD is
+	// unknown and it is reported as a gap, as is the missing day before it.
+	day6 := day1.AddDate(0, 0, 5)
+	reading(day6, 5, 40)
+	reading(day6, 14, 45)
+	if err := job.RunOnce(day6.AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	check([]float64{12, 15, 7, -1, -1}, []float64{-1, 15, 22, 22, 22})
+	var days []models.DailyClimate
+	db.Order("device_id, day").Find(&days)
+	gaps := Gaps(days)
+	if len(gaps) != 3 || gaps[0].Reason != "incomplete" || !gaps[1].Day.Equal(day1.AddDate(0, 0, 4)) ||
+		gaps[1].Reason != "missing" || !gaps[2].Day.Equal(day6) || gaps[2].Reason != "incomplete" {
+		t.Errorf("unexpected gaps %+v", gaps)
+	}
+
+	// Once the rest of the day is uploaded late, it is complete.
+	for hour := 0; hour < 24; hour++ {
+		if hour != 5 && hour != 14 {
+			reading(day6, hour, 20)
+		}
+	}
+	if err := job.RunOnce(day6.AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	check([]float64{12, 15, 7, -1, 20.5}, []float64{-1, 15, 22, 22, 42.5})
+	var backfilled models.DailyClimate
+	db.First(&backfilled, "day = ?", day6)
+	if backfilled.HoursCovered != 24 || !backfilled.Complete {
+		t.Errorf("backfilled day not complete: %+v", backfilled)
+	}
+}
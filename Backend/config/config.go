@@ -0,0 +1,464 @@
+// Package config loads the Backend settings of one greenhouse install.
+//
+// Settings start from built-in defaults and are overridden, in order, by a
+// YAML file, FARM_* environment variables and command-line flags. Every
+// setting has a dotted name such as database.path; the environment variable
+// is FARM_DATABASE_PATH and the flag -database.path.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"my-smart-farm/anomaly"
+	"my-smart-farm/quality"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the effective configuration.
+type Config struct {
+	Server    Server    `yaml:"server"`
+	Database  Database  `yaml:"database"`
+	CORS      CORS      `yaml:"cors"`
+	Devices   Devices   `yaml:"devices"`
+	Retention Retention `yaml:"retention"`
+	Relays    Relays    `yaml:"relays"`
+	MQTT      MQTT      `yaml:"mqtt"`
+	Metrics   Metrics   `yaml:"metrics"`
+	Quality   Quality   `yaml:"quality"`
+	Anomaly   Anomaly   `yaml:"anomaly"`
+	Climate   Climate   `yaml:"climate"`
+}
+
+type Server struct {
+	// Listen is the HTTP address of the API, e.g. ":3000".
+	Listen string `yaml:"listen"`
+}
+
+type Database struct {
+	// Driver is sqlite or postgres.
+	Driver string `yaml:"driver"`
+	// Path is the SQLite database file.
+	Path string `yaml:"path"`
+	// DSN is the Postgres connection string, e.g.
+	// "host=db user=farm password=... dbname=farm sslmode=disable".
+	DSN string `yaml:"dsn"`
+}
+
+// Source returns what the database driver should open.
+func (d Database) Source() string {
+	if d.Driver == "postgres" {
+		return d.DSN
+	}
+	return d.Path
+}
+
+type CORS struct {
+	// AllowOrigins lists the dashboard origins allowed to call the API;
+	// "*" allows any origin. Empty allows same-origin requests only. The
+	// default allows the Frontend dashboard served on port 8080.
+	AllowOrigins []string `yaml:"allow_origins"`
+}
+
+type Devices struct {
+	// DefaultIntervalSeconds is the send interval given to new sensors.
+	DefaultIntervalSeconds int `yaml:"default_interval_seconds"`
+	// AllowUnsigned accepts unsigned requests from devices that have no
+	// secret provisioned yet. Devices with a secret must always sign.
+	AllowUnsigned bool `yaml:"allow_unsigned"`
+}
+
+type Retention struct {
+	// Raw is how long raw readings are kept before rollup, unless a device
+	// has its own policy.
+	Raw time.Duration `yaml:"raw"`
+	// Interval between compaction runs.
+	Interval time.Duration `yaml:"interval"`
+}
+
+type Relays struct {
+	// StaleAfter and OfflineAfter are the heartbeat ages at which a relay
+	// is reported stale and offline.
+	StaleAfter   time.Duration `yaml:"stale_after"`
+	OfflineAfter time.Duration `yaml:"offline_after"`
+}
+
+type MQTT struct {
+	Enabled bool `yaml:"enabled"`
+	// Broker is the host:port of an external broker. When empty an
+	// embedded broker listens on Listen.
+	Broker   string `yaml:"broker"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Listen   string `yaml:"listen"`
+	// TLSCert and TLSKey are the PEM files the embedded broker serves TLS
+	// with. Devices send their MQTT password on connect, so the broker
+	// only listens without TLS on a loopback address.
+	TLSCert string `yaml:"tls_cert"`
+	TLSKey  string `yaml:"tls_key"`
+}
+
+type Metrics struct {
+	// Enabled serves Prometheus metrics on /metrics.
+	Enabled bool `yaml:"enabled"`
+	// Token must be sent by scrapers as a bearer token.
+	Token string `yaml:"token"`
+}
+
+type Quality struct {
+	// Enabled grades every reading against the limits below. Rejected
+	// readings are stored but hidden from queries, alerts and automations.
+	Enabled       bool `yaml:"enabled"`
+	quality.Rules `yaml:",inline"`
+}
+
+type Anomaly struct {
+	// Enabled checks every accepted reading for frozen values, steps and
+	// outliers, and alerts on frozen sensors.
+	Enabled        bool `yaml:"enabled"`
+	anomaly.Config `yaml:",inline"`
+}
+
+type Climate struct {
+	// BaseTemperature is the growing degree day base in °C for crops whose
+	// planting does not set one.
+	BaseTemperature float64 `yaml:"base_temperature"`
+}
+
+// Default returns the settings used when nothing is configured.
+func Default() *Config {
+	return &Config{
+		Server:    Server{Listen: ":3000"},
+		Database:  Database{Driver: "sqlite", Path: "farm_data.db"},
+		CORS:      CORS{AllowOrigins: []string{"http://localhost:8080", "http://127.0.0.1:8080"}},
+		Devices:   Devices{DefaultIntervalSeconds: 60},
+		Retention: Retention{Raw: 30 * 24 * time.Hour, Interval: time.Hour},
+		Relays:    Relays{StaleAfter: 2 * time.Minute, OfflineAfter: 5 * time.Minute},
+		MQTT:      MQTT{Listen: ":8883"},
+		Quality:   Quality{Enabled: true, Rules: quality.DefaultRules()},
+		Anomaly:   Anomaly{Enabled: true, Config: anomaly.DefaultConfig()},
+		Climate:   Climate{BaseTemperature: 10},
+	}
+}
+
+// setting binds a dotted name to a field of Config.
+type setting struct {
+	name  string
+	usage string
+	ptr   interface{}
+}
+
+func (c *Config) settings() []setting {
+	settings := []setting{
+		{"server.listen", "HTTP listen address", &c.Server.Listen},
+		{"database.driver", "database driver: sqlite or postgres", &c.Database.Driver},
+		{"database.path", "SQLite database file", &c.Database.Path},
+		{"database.dsn", "Postgres connection string", &c.Database.DSN},
+		{"cors.allow_origins", "comma-separated allowed origins, * for any", &c.CORS.AllowOrigins},
+		{"devices.default_interval_seconds", "send interval for new sensors", &c.Devices.DefaultIntervalSeconds},
+		{"devices.allow_unsigned", "accept unsigned requests from devices without a secret", &c.Devices.AllowUnsigned},
+		{"retention.raw", "how long raw readings are kept", &c.Retention.Raw},
+		{"retention.interval", "time between compaction runs", &c.Retention.Interval},
+		{"relays.stale_after", "heartbeat age at which a relay is stale", &c.Relays.StaleAfter},
+		{"relays.offline_after", "heartbeat age at which a relay is offline", &c.Relays.OfflineAfter},
+		{"mqtt.enabled", "run the MQTT bridge", &c.MQTT.Enabled},
+		{"mqtt.broker", "external MQTT broker host:port; empty runs the embedded broker", &c.MQTT.Broker},
+		{"mqtt.username", "external MQTT broker username", &c.MQTT.Username},
+		{"mqtt.password", "external MQTT broker password", &c.MQTT.Password},
+		{"mqtt.listen", "embedded MQTT broker listen address", &c.MQTT.Listen},
+		{"mqtt.tls_cert", "embedded MQTT broker TLS certificate file", &c.MQTT.TLSCert},
+		{"mqtt.tls_key", "embedded MQTT broker TLS key file", &c.MQTT.TLSKey},
+		{"metrics.enabled", "serve Prometheus metrics on /metrics", &c.Metrics.Enabled},
+		{"metrics.token", "bearer token scrapers must send", &c.Metrics.Token},
+		{"quality.enabled", "grade readings and hide rejected ones", &c.Quality.Enabled},
+		{"anomaly.enabled", "look for frozen sensors, steps and outliers", &c.Anomaly.Enabled},
+		{"anomaly.stuck_samples", "identical readings in a row that make a sensor frozen", &c.Anomaly.StuckSamples},
+		{"anomaly.window", "previous readings the outlier check compares against", &c.Anomaly.Window},
+		{"anomaly.threshold", "robust z-score beyond which a reading is an outlier", &c.Anomaly.Threshold},
+		{"anomaly.steps.temperature", "largest believable temperature change between readings", &c.Anomaly.Steps.Temperature},
+		{"anomaly.steps.humidity", "largest believable humidity change between readings", &c.Anomaly.Steps.Humidity},
+		{"anomaly.steps.soil", "largest believable soil moisture change between readings", &c.Anomaly.Steps.Soil},
+		{"anomaly.saturation.temperature.low", "temperature at or below which a steady sensor is saturated, not frozen", &c.Anomaly.Saturation.Temperature.Low},
+		{"anomaly.saturation.temperature.high", "temperature at or above which a steady sensor is saturated, not frozen", &c.Anomaly.Saturation.Temperature.High},
+		{"anomaly.saturation.humidity.low", "humidity at or below which a steady sensor is saturated, not frozen", &c.Anomaly.Saturation.Humidity.Low},
+		{"anomaly.saturation.humidity.high", "humidity at or above which a steady sensor is saturated, not frozen", &c.Anomaly.Saturation.Humidity.High},
+		{"anomaly.saturation.soil.low", "soil moisture at or below which a steady sensor is saturated, not frozen", &c.Anomaly.Saturation.Soil.Low},
+		{"anomaly.saturation.soil.high", "soil moisture at or above which a steady sensor is saturated, not frozen", &c.Anomaly.Saturation.Soil.High},
+		{"climate.base_temperature", "default growing degree day base temperature in °C", &c.Climate.BaseTemperature},
+	}
+	for _, m := range c.Quality.metrics() {
+		settings = append(settings,
+			setting{m.name + ".min", m.metric + " below which readings are rejected", &m.limits.Min},
+			setting{m.name + ".max", m.metric + " above which readings are rejected", &m.limits.Max},
+			setting{m.name + ".plausible_min", m.metric + " below which readings are suspect", &m.limits.PlausibleMin},
+			setting{m.name + ".plausible_max", m.metric + " above which readings are suspect", &m.limits.PlausibleMax},
+			setting{m.name + ".max_rate_per_minute", m.metric + " change per minute above which readings are suspect", &m.limits.MaxRatePerMinute},
+		)
+	}
+	return settings
+}
+
+type qualityMetric struct {
+	name, metric string
+	limits       *quality.Limits
+}
+
+func (q *Quality) metrics() []qualityMetric {
+	return []qualityMetric{
+		{"quality.temperature", "temperature", &q.Temperature},
+		{"quality.humidity", "humidity", &q.Humidity},
+		{"quality.soil", "soil moisture", &q.Soil},
+	}
+}
+
+func envName(name string) string {
+	return "FARM_" + strings.ToUpper(strings.ReplaceAll(name, ".", "_"))
+}
+
+func set(ptr interface{}, value string) error {
+	switch p := ptr.(type) {
+	case *string:
+		*p = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*p = n
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*p = b
+	case *float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*p = f
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*p = d
+	case *[]string:
+		*p = nil
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				*p = append(*p, s)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported setting type %T", ptr)
+	}
+	return nil
+}
+
+// flagValue records a flag so it can be applied after the file and the
+// environment, whatever order the flags came in.
+type flagValue struct {
+	name   string
+	isBool bool
+	set    map[string]string
+}
+
+func (f *flagValue) String() string { return "" }
+
+func (f *flagValue) Set(s string) error {
+	f.set[f.name] = s
+	return nil
+}
+
+// IsBoolFlag lets boolean settings be given as a bare -name.
+func (f *flagValue) IsBoolFlag() bool { return f.isBool }
+
+// Options are the command-line switches that are not settings.
+type Options struct {
+	// File is the YAML file that was loaded, if any.
+	File string
+	// PrintOnly asks to print the effective config and exit.
+	PrintOnly bool
+}
+
+// Load builds the config from defaults, the YAML file named by -config or
+// FARM_CONFIG, the environment and args, and validates it.
+func Load(args []string, getenv func(string) string) (*Config, Options, error) {
+	cfg := Default()
+	var opts Options
+
+	fs := flag.NewFlagSet("farm", flag.ContinueOnError)
+	fs.StringVar(&opts.File, "config", getenv("FARM_CONFIG"), "YAML config file")
+	fs.BoolVar(&opts.PrintOnly, "print-config", false, "print the effective config and exit")
+	flags := map[string]string{}
+	for _, s := range cfg.settings() {
+		_, isBool := s.ptr.(*bool)
+		fs.Var(&flagValue{name: s.name, isBool: isBool, set: flags}, s.name, s.usage+" ("+envName(s.name)+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, opts, err
+	}
+
+	if opts.File != "" {
+		if err := cfg.loadFile(opts.File); err != nil {
+			return nil, opts, err
+		}
+	}
+	for _, s := range cfg.settings() {
+		if v := getenv(envName(s.name)); v != "" {
+			if err := set(s.ptr, v); err != nil {
+				return nil, opts, fmt.Errorf("%s: %w", envName(s.name), err)
+			}
+		}
+	}
+	for _, s := range cfg.settings() {
+		if v, ok := flags[s.name]; ok {
+			if err := set(s.ptr, v); err != nil {
+				return nil, opts, fmt.Errorf("-%s: %w", s.name, err)
+			}
+		}
+	}
+	return cfg, opts, cfg.Validate()
+}
+
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	// A misspelt key would otherwise be ignored silently.
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(validAddr(c.Server.Listen), "server.listen: %q is not a host:port address", c.Server.Listen)
+	switch c.Database.Driver {
+	case "sqlite":
+		check(c.Database.Path != "", "database.path: must not be empty")
+	case "postgres":
+		check(c.Database.DSN != "", "database.dsn: required for the postgres driver")
+	default:
+		check(false, "database.driver: %q is not sqlite or postgres", c.Database.Driver)
+	}
+	for _, o := range c.CORS.AllowOrigins {
+		check(validOrigin(o), "cors.allow_origins: %q is not * or a scheme://host origin", o)
+	}
+	check(c.Devices.DefaultIntervalSeconds > 0, "devices.default_interval_seconds: must be positive")
+	check(c.Retention.Raw >= time.Hour, "retention.raw: must be at least 1h")
+	check(c.Retention.Interval > 0, "retention.interval: must be positive")
+	check(c.Relays.StaleAfter > 0, "relays.stale_after: must be positive")
+	check(c.Relays.OfflineAfter > c.Relays.StaleAfter, "relays.offline_after: must be longer than relays.stale_after")
+	if c.MQTT.Enabled {
+		if c.MQTT.Broker != "" {
+			check(validAddr(c.MQTT.Broker), "mqtt.broker: %q is not a host:port address", c.MQTT.Broker)
+		} else {
+			check(validAddr(c.MQTT.Listen), "mqtt.listen: %q is not a host:port address", c.MQTT.Listen)
+			check(c.MQTT.Username == "" && c.MQTT.Password == "",
+				"mqtt.username, mqtt.password: only apply to an external mqtt.broker")
+			check((c.MQTT.TLSCert == "") == (c.MQTT.TLSKey == ""),
+				"mqtt.tls_cert, mqtt.tls_key: set both or neither")
+			check(c.MQTT.TLSCert != "" || loopback(c.MQTT.Listen),
+				"mqtt.tls_cert: required unless mqtt.listen is a loopback address")
+		}
+	}
+	check(!c.Metrics.Enabled || c.Metrics.Token != "", "metrics.token: required when metrics are enabled")
+	for _, m := range c.Quality.metrics() {
+		if err := m.limits.Validate(); err != nil {
+			check(false, "%s: %v", m.name, err)
+		}
+	}
+	check(c.Climate.BaseTemperature >= -10 && c.Climate.BaseTemperature <= 40,
+		"climate.base_temperature: %g is not between -10 and 40 °C", c.Climate.BaseTemperature)
+	if err := c.Anomaly.Validate(); err != nil {
+		check(false, "anomaly: %v", err)
+	}
+	return errors.Join(errs...)
+}
+
+func validAddr(addr string) bool {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n >= 0 && n <= 65535
+}
+
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func validOrigin(origin string) bool {
+	if origin == "*" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		(u.Path == "" || u.Path == "/") && u.RawQuery == ""
+}
+
+// Write prints the config as YAML with secrets masked.
+func (c *Config) Write(w io.Writer) error {
+	shown := *c
+	if shown.MQTT.Password != "" {
+		shown.MQTT.Password = "********"
+	}
+	if shown.Metrics.Token != "" {
+		shown.Metrics.Token = "********"
+	}
+	if shown.Database.DSN != "" {
+		shown.Database.DSN = maskDSN(shown.Database.DSN)
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&shown); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// maskDSN hides the password of a key=value or URL Postgres DSN.
+func maskDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		return u.Redacted()
+	}
+	fields := strings.Fields(dsn)
+	for i, f := range fields {
+		if strings.HasPrefix(f, "password=") {
+			fields[i] = "password=********"
+		}
+	}
+	return strings.Join(fields, " ")
+}
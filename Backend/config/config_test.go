@@ -0,0 +1,91 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "farm.yaml")
+	os.WriteFile(file, []byte(`
+server:
+  listen: ":8080"
+database:
+  path: /var/lib/farm/a.db
+cors:
+  allow_origins: [https://greenhouse-a.example]
+relays:
+  stale_after: 1m
+`), 0o600)
+	env := map[string]string{
+		"FARM_CONFIG":        file,
+		"FARM_DATABASE_PATH": "/var/lib/farm/b.db",
+		"FARM_SERVER_LISTEN": ":9090",
+	}
+	cfg, opts, err := Load([]string{"-server.listen=:7070", "-devices.allow_unsigned"}, func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.File != file {
+		t.Errorf("config file %q not picked up from FARM_CONFIG", opts.File)
+	}
+	if cfg.Server.Listen != ":7070" || cfg.Database.Path != "/var/lib/farm/b.db" {
+		t.Errorf("flags should beat env and env the file: %+v %+v", cfg.Server, cfg.Database)
+	}
+	if cfg.CORS.AllowOrigins[0] != "https://greenhouse-a.example" || cfg.Relays.StaleAfter != time.Minute {
+		t.Errorf("file settings lost: %+v %+v", cfg.CORS, cfg.Relays)
+	}
+	if !cfg.Devices.AllowUnsigned || cfg.Devices.DefaultIntervalSeconds != 60 {
+		t.Errorf("unexpected devices: %+v", cfg.Devices)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	noEnv := func(string) string { return "" }
+	_, _, err := Load([]string{
+		"-server.listen=3000",
+		"-cors.allow_origins=https://ok.example, greenhouse.local",
+		"-devices.default_interval_seconds=0",
+		"-relays.offline_after=1m",
+		"-mqtt.enabled",
+		"-metrics.enabled",
+	}, noEnv)
+	if err == nil {
+		t.Fatal("invalid config accepted")
+	}
+	for _, want := range []string{"server.listen", `"greenhouse.local"`, "default_interval_seconds", "offline_after", "mqtt.tls_cert", "metrics.token"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s:\n%v", want, err)
+		}
+	}
+
+	if _, _, err := Load([]string{"-mqtt.enabled", "-mqtt.listen=127.0.0.1:1883"}, noEnv); err != nil {
+		t.Error("embedded broker on loopback rejected:", err)
+	}
+
+	if _, _, err := Load([]string{"-database.driver=postgres"}, noEnv); err == nil || !strings.Contains(err.Error(), "database.dsn") {
+		t.Error("postgres without a dsn accepted:", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "farm.yaml")
+	os.WriteFile(file, []byte("server:\n  port: 3000\n"), 0o600)
+	if _, _, err := Load([]string{"-config", file}, noEnv); err == nil {
+		t.Error("unknown key in config file accepted")
+	}
+}
+
+func TestWriteMasksPassword(t *testing.T) {
+	cfg := Default()
+	cfg.MQTT.Password = "hunter2"
+	cfg.Database.DSN = "host=db user=farm password=hunter2 dbname=farm"
+	var out strings.Builder
+	if err := cfg.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "hunter2") || !strings.Contains(out.String(), "listen: :3000") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
@@ -1,21 +1,42 @@
 package database
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"log"
 
 	"my-smart-farm/models"
 
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
 var DB *gorm.DB
 
-func InitDB() {
+// Supported database drivers.
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// InitDB opens and migrates the database. For SQLite dsn is the file path,
+// for Postgres a connection string.
+func InitDB(driver, dsn string) {
+	dialector := sqlite.Open(dsn)
+	if driver == DriverPostgres {
+		dialector = postgres.Open(dsn)
+	}
+
 	var err error
-	DB, err = gorm.Open(sqlite.Open("farm_data.db"), &gorm.Config{})
+	DB, err = gorm.Open(dialector, &gorm.Config{
+		// Readings may arrive before a device is registered, so device
+		// links are kept at the ORM level without FK constraints.
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
 	if err != nil {
-		log.Fatal("Failed to connect to SQLite database:", err)
+		log.Fatalf("Failed to connect to %s database: %v", driver, err)
 	}
 
 	// AutoMigrate will create/modify the table based on the SensorData struct
@@ -25,5 +46,94 @@ func InitDB() {
 	); err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
-	DB.AutoMigrate(&models.RelayDevice{})
+	DB.AutoMigrate(&models.RelayDevice{}, &models.RelayCommand{})
+	backfillRelayCommands(DB)
+
+	if err := DB.AutoMigrate(
+		&models.HourlyRollup{},
+		&models.DailyRollup{},
+		&models.RetentionPolicy{},
+	); err != nil {
+		log.Fatal("Failed to migrate rollup tables:", err)
+	}
+
+	if err := DB.AutoMigrate(&models.Device{}, &models.SoilCalibration{}); err != nil {
+		log.Fatal("Failed to migrate device registry:", err)
+	}
+	backfillDevices(DB)
+
+	if err := DB.AutoMigrate(&models.User{}, &models.Session{}); err != nil {
+		log.Fatal("Failed to migrate user tables:", err)
+	}
+	seedAdmin(DB)
+
+	if err := DB.AutoMigrate(&models.AlertRule{}, &models.Alert{}, &models.NotificationChannel{}); err != nil {
+		log.Fatal("Failed to migrate alert tables:", err)
+	}
+
+	if err := DB.AutoMigrate(&models.IrrigationRule{}, &models.RelaySchedule{}); err != nil {
+		log.Fatal("Failed to migrate automation tables:", err)
+	}
+
+	if err := DB.AutoMigrate(&models.Anomaly{}); err != nil {
+		log.Fatal("Failed to migrate anomaly table:", err)
+	}
+
+	if err := DB.AutoMigrate(&models.Planting{}, &models.DailyClimate{}); err != nil {
+		log.Fatal("Failed to migrate climate tables:", err)
+	}
+}
+
+// backfillRelayCommands settles audit rows written before commands had a
+// delivery status, so the queue does not mistake them for pending work.
+func backfillRelayCommands(db *gorm.DB) {
+	err := db.Exec(`UPDATE relay_commands
+		SET status = CASE WHEN status_code BETWEEN 200 AND 299 THEN ? ELSE ? END, attempts = 1
+		WHERE attempts = 0 AND expires_at IS NULL`,
+		models.CommandDelivered, models.CommandFailed).Error
+	if err != nil {
+		log.Println("Failed to backfill relay command status:", err)
+	}
+}
+
+// seedAdmin creates an initial admin account with a random password when
+// no users exist yet, so the dashboard is never left unprotected.
+func seedAdmin(db *gorm.DB) {
+	var count int64
+	if err := db.Model(&models.User{}).Count(&count).Error; err != nil || count > 0 {
+		return
+	}
+	var raw [9]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		log.Fatal("Failed to generate admin password:", err)
+	}
+	password := hex.EncodeToString(raw[:])
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Fatal("Failed to hash admin password:", err)
+	}
+	admin := models.User{Username: "admin", PasswordHash: string(hash), Role: models.RoleAdmin}
+	if err := db.Create(&admin).Error; err != nil {
+		log.Fatal("Failed to create admin user:", err)
+	}
+	log.Printf("Created initial user %q with password %q; change it after logging in", admin.Username, password)
+}
+
+// backfillDevices registers devices that so far only existed as DeviceID
+// strings on readings and relay registrations.
+func backfillDevices(db *gorm.DB) {
+	err := db.Exec(`INSERT INTO devices (device_id, kind, created_at, last_seen)
+		SELECT device_id, ?, MIN(timestamp), MAX(timestamp) FROM sensor_data
+		WHERE device_id <> '' AND device_id NOT IN (SELECT device_id FROM devices)
+		GROUP BY device_id`,
+		models.DeviceKindSensor).Error
+	if err == nil {
+		err = db.Exec(`INSERT INTO devices (device_id, kind, created_at, last_seen)
+			SELECT device_id, ?, updated, updated FROM relay_devices
+			WHERE device_id <> '' AND device_id NOT IN (SELECT device_id FROM devices)`,
+			models.DeviceKindRelay).Error
+	}
+	if err != nil {
+		log.Println("Failed to backfill device registry:", err)
+	}
 }
@@ -0,0 +1,111 @@
+// Package derived computes the agronomic metrics growers manage by, such as
+// vapour pressure deficit, from each reading's temperature and relative
+// humidity.
+package derived
+
+import (
+	"math"
+
+	"my-smart-farm/models"
+)
+
+// Metrics lists the derived metrics by the names alert rules use.
+var Metrics = []string{"vpd", "dew_point", "absolute_humidity", "heat_index"}
+
+// Values are the metrics derived from one temperature and humidity pair.
+// They are named in JSON as in Metrics and the aggregate endpoints.
+type Values struct {
+	// VPD is the vapour pressure deficit in kPa.
+	VPD float64 `json:"vpd"`
+	// DewPoint is in °C.
+	DewPoint float64 `json:"dew_point"`
+	// AbsoluteHumidity is in g/m³.
+	AbsoluteHumidity float64 `json:"absolute_humidity"`
+	// HeatIndex is the apparent temperature in °C.
+	HeatIndex float64 `json:"heat_index"`
+}
+
+// Compute derives the metrics from a temperature in °C and a relative
+// humidity in percent. It reports false when humidity is outside (0, 100],
+// where the formulas have no meaning.
+func Compute(temperature, humidity float64) (Values, bool) {
+	if !(humidity > 0 && humidity <= 100) || math.IsNaN(temperature) {
+		return Values{}, false
+	}
+	es := saturation(temperature)
+	ea := es * humidity / 100
+	// Magnus formula inverted for the temperature at which ea saturates.
+	gamma := math.Log(ea / 0.6112)
+	return Values{
+		VPD:              round(es-ea, 3),
+		DewPoint:         round(243.12*gamma/(17.62-gamma), 1),
+		AbsoluteHumidity: round(2167*ea/(temperature+273.15), 1),
+		HeatIndex:        round(heatIndex(temperature, humidity), 1),
+	}, true
+}
+
+// saturation is the saturation vapour pressure over water in kPa (Magnus,
+// WMO coefficients).
+func saturation(t float64) float64 {
+	return 0.6112 * math.Exp(17.62*t/(243.12+t))
+}
+
+// heatIndex follows the US National Weather Service: Steadman's simple
+// formula, switching to the Rothfusz regression with its adjustments once
+// the result reaches 80 °F.
+func heatIndex(t, rh float64) float64 {
+	f := t*9/5 + 32
+	hi := 0.5 * (f + 61 + (f-68)*1.2 + rh*0.094)
+	if (hi+f)/2 >= 80 {
+		hi = -42.379 + 2.04901523*f + 10.14333127*rh - 0.22475541*f*rh -
+			6.83783e-3*f*f - 5.481717e-2*rh*rh + 1.22874e-3*f*f*rh +
+			8.5282e-4*f*rh*rh - 1.99e-6*f*f*rh*rh
+		switch {
+		case rh < 13 && f >= 80 && f <= 112:
+			hi -= (13 - rh) / 4 * math.Sqrt((17-math.Abs(f-95))/17)
+		case rh > 85 && f >= 80 && f <= 87:
+			hi += (rh - 85) / 10 * (87 - f) / 5
+		}
+	}
+	return (hi - 32) * 5 / 9
+}
+
+func round(v float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(v*p) / p
+}
+
+// Value returns the named derived metric of a reading.
+func Value(data *models.SensorData, metric string) (float64, bool) {
+	v, ok := Compute(data.Temperature, data.Humidity)
+	if !ok {
+		return 0, false
+	}
+	switch metric {
+	case "vpd":
+		return v.VPD, true
+	case "dew_point":
+		return v.DewPoint, true
+	case "absolute_humidity":
+		return v.AbsoluteHumidity, true
+	case "heat_index":
+		return v.HeatIndex, true
+	}
+	return 0, false
+}
+
+// Reading is a reading with its derived metrics, as served by the API.
+// Values is nil when humidity is out of range.
+type Reading struct {
+	models.SensorData
+	*Values
+}
+
+// Of adds the derived metrics to a reading.
+func Of(data *models.SensorData) Reading {
+	r := Reading{SensorData: *data}
+	if v, ok := Compute(data.Temperature, data.Humidity); ok {
+		r.Values = &v
+	}
+	return r
+}
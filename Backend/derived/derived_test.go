@@ -0,0 +1,45 @@
+package derived
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"my-smart-farm/models"
+)
+
+func TestCompute(t *testing.T) {
+	for _, tc := range []struct {
+		temperature, humidity float64
+		want                  Values
+	}{
+		// FAO-56 gives 1.27 kPa; the NWS table gives a heat index of 106 °F
+		// for 90 °F and 70 %.
+		{25, 60, Values{VPD: 1.264, DewPoint: 16.7, AbsoluteHumidity: 13.8, HeatIndex: 25.1}},
+		{32.2, 70, Values{VPD: 1.44, DewPoint: 26, AbsoluteHumidity: 23.8, HeatIndex: 41}},
+		{20, 100, Values{VPD: 0, DewPoint: 20, AbsoluteHumidity: 17.2, HeatIndex: 20.7}},
+		{35, 10, Values{VPD: 5.052, DewPoint: -1.2, AbsoluteHumidity: 3.9, HeatIndex: 31.9}},
+	} {
+		got, ok := Compute(tc.temperature, tc.humidity)
+		if !ok || got != tc.want {
+			t.Errorf("Compute(%g, %g) = %+v, want %+v", tc.temperature, tc.humidity, got, tc.want)
+		}
+	}
+	for _, humidity := range []float64{0, -3, 101, math.NaN()} {
+		if _, ok := Compute(25, humidity); ok {
+			t.Errorf("humidity %g accepted", humidity)
+		}
+	}
+}
+
+func TestReadingJSON(t *testing.T) {
+	out, _ := json.Marshal(Of(&models.SensorData{DeviceID: "sensor-001", Temperature: 25, Humidity: 60}))
+	if !strings.Contains(string(out), `"DeviceID":"sensor-001"`) || !strings.Contains(string(out), `"vpd":1.264`) {
+		t.Errorf("unexpected JSON %s", out)
+	}
+	out, _ = json.Marshal(Of(&models.SensorData{DeviceID: "sensor-001", Temperature: -40, Humidity: 0}))
+	if strings.Contains(string(out), "vpd") {
+		t.Errorf("rejected reading should have no derived metrics: %s", out)
+	}
+}
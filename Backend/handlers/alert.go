@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+
+	"my-smart-farm/alerts"
+	"my-smart-farm/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// alertRuleInput uses a pointer for Enabled so an omitted field means true.
+type alertRuleInput struct {
+	models.AlertRule
+	Enabled *bool `json:"enabled"`
+}
+
+func (in alertRuleInput) rule() (models.AlertRule, string) {
+	rule := in.AlertRule
+	rule.Enabled = in.Enabled == nil || *in.Enabled
+	if !slices.Contains(alerts.Metrics, rule.Metric) {
+		return rule, "Unknown metric"
+	}
+	if rule.Operator != "<" && rule.Operator != ">" {
+		return rule, "Operator must be < or >"
+	}
+	if rule.Hysteresis < 0 || rule.ForSeconds < 0 {
+		return rule, "hysteresis and for_seconds must not be negative"
+	}
+	return rule, ""
+}
+
+// GET /api/v1/alerts?state=active|pending|firing|resolved|all&device_id=
+func GetAlerts(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tx := db.Preload("Rule").Order("started_at DESC")
+		switch state := c.Query("state", "active"); state {
+		case "active":
+			tx = tx.Where("state IN ?", []string{models.AlertPending, models.AlertFiring})
+		case models.AlertPending, models.AlertFiring, models.AlertResolved:
+			tx = tx.Where("state = ?", state)
+		case "all":
+			tx = tx.Limit(c.QueryInt("limit", defaultQueryLimit))
+		default:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid state",
+			})
+		}
+		if deviceID := c.Query("device_id"); deviceID != "" {
+			tx = tx.Where("device_id = ?", deviceID)
+		}
+
+		var list []models.Alert
+		if err := tx.Find(&list).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch alerts",
+			})
+		}
+		return c.JSON(list)
+	}
+}
+
+// GET /api/v1/alert-rules
+func GetAlertRules(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var rules []models.AlertRule
+		if err := db.Order("id").Find(&rules).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch alert rules",
+			})
+		}
+		return c.JSON(rules)
+	}
+}
+
+// POST /api/v1/alert-rules
+func CreateAlertRule(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input alertRuleInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid input",
+			})
+		}
+		rule, problem := input.rule()
+		if problem != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": problem,
+			})
+		}
+		rule.ID = 0
+
+		if err := db.Create(&rule).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save alert rule",
+			})
+		}
+		return c.Status(fiber.StatusCreated).JSON(rule)
+	}
+}
+
+// PUT /api/v1/alert-rules/:id
+func UpdateAlertRule(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid alert rule id",
+			})
+		}
+		var existing models.AlertRule
+		if err := db.First(&existing, id).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Alert rule not found",
+			})
+		}
+
+		var input alertRuleInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid input",
+			})
+		}
+		rule, problem := input.rule()
+		if problem != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": problem,
+			})
+		}
+		rule.ID = existing.ID
+		rule.CreatedAt = existing.CreatedAt
+
+		if err := db.Save(&rule).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save alert rule",
+			})
+		}
+		return c.JSON(rule)
+	}
+}
+
+// DELETE /api/v1/alert-rules/:id also removes the rule's alert history.
+func DeleteAlertRule(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid alert rule id",
+			})
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			res := tx.Delete(&models.AlertRule{}, id)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			return tx.Delete(&models.Alert{}, "rule_id = ?", id).Error
+		})
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Alert rule not found",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete alert rule",
+			})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
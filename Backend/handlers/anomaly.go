@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"slices"
+
+	"my-smart-farm/alerts"
+	"my-smart-farm/anomaly"
+	"my-smart-farm/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// GET /api/v1/anomalies?device_id=&metric=&kind=stuck|step|outlier&from=&to=&limit=
+func GetAnomalies(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		q, err := parseReadingQuery(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		tx := db.Model(&models.Anomaly{})
+		if len(q.DeviceIDs) > 0 {
+			tx = tx.Where("device_id IN ?", q.DeviceIDs)
+		}
+		if !q.From.IsZero() {
+			tx = tx.Where("timestamp >= ?", q.From)
+		}
+		if !q.To.IsZero() {
+			tx = tx.Where("timestamp < ?", q.To)
+		}
+		if metric := c.Query("metric"); metric != "" {
+			if !slices.Contains(alerts.Measured, metric) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Unknown metric",
+				})
+			}
+			tx = tx.Where("metric = ?", metric)
+		}
+		if kind := c.Query("kind"); kind != "" {
+			if kind != models.AnomalyStuck && kind != models.AnomalyStep && kind != models.AnomalyOutlier {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "kind must be stuck, step or outlier",
+				})
+			}
+			tx = tx.Where("kind = ?", kind)
+		}
+
+		var list []models.Anomaly
+		if err := tx.Order("timestamp DESC").Order("id DESC").Limit(q.Limit).Find(&list).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch anomalies",
+			})
+		}
+		return c.JSON(list)
+	}
+}
+
+// POST /api/v1/anomalies/scan?device_id=&from=&to=
+// Checks stored history, e.g. after tuning the detector, and records the
+// anomalies not found before.
+func ScanAnomalies(detector *anomaly.Detector) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		q, err := parseReadingQuery(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		created, err := detector.Scan(q.ReadingFilter)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Scan failed: " + err.Error(),
+			})
+		}
+		return c.JSON(fiber.Map{"created": created})
+	}
+}
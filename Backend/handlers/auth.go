@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"my-smart-farm/middleware"
+	"my-smart-farm/models"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const sessionTTL = 7 * 24 * time.Hour
+
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// POST /api/v1/auth/login
+func Login(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req loginRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid input",
+			})
+		}
+
+		var user models.User
+		err := db.First(&user, "username = ?", req.Username).Error
+		if err != nil || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid username or password",
+			})
+		}
+
+		var raw [32]byte
+		if _, err := rand.Read(raw[:]); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create session",
+			})
+		}
+		token := hex.EncodeToString(raw[:])
+		session := models.Session{
+			TokenHash: middleware.HashToken(token),
+			UserID:    user.ID,
+			ExpiresAt: time.Now().Add(sessionTTL),
+		}
+		if err := db.Omit("User").Create(&session).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create session",
+			})
+		}
+		// Drop expired sessions while we are here.
+		db.Where("expires_at < ?", time.Now()).Delete(&models.Session{})
+
+		return c.JSON(fiber.Map{
+			"token":      token,
+			"expires_at": session.ExpiresAt,
+			"user":       user,
+		})
+	}
+}
+
+// POST /api/v1/auth/logout
+func Logout(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := c.Get(fiber.HeaderAuthorization)
+		if len(token) > len("Bearer ") {
+			db.Delete(&models.Session{}, "token_hash = ?", middleware.HashToken(token[len("Bearer "):]))
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// GET /api/v1/auth/me
+func Me() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(middleware.CurrentUser(c))
+	}
+}
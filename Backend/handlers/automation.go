@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"my-smart-farm/automation"
+	"my-smart-farm/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// irrigationRuleInput uses a pointer for Enabled so an omitted field means true.
+type irrigationRuleInput struct {
+	models.IrrigationRule
+	Enabled *bool `json:"enabled"`
+}
+
+func (in irrigationRuleInput) rule() (models.IrrigationRule, string) {
+	rule := in.IrrigationRule
+	rule.Enabled = in.Enabled == nil || *in.Enabled
+	if rule.SensorID == "" || rule.RelayID == "" {
+		return rule, "sensor_id and relay_id are required"
+	}
+	if rule.SoilBelow <= 0 || rule.SoilBelow > 100 {
+		return rule, "soil_below must be between 0 and 100"
+	}
+	if rule.SoilTarget != 0 && rule.SoilTarget <= rule.SoilBelow {
+		return rule, "soil_target must be above soil_below"
+	}
+	if rule.MaxOnSeconds <= 0 || rule.CooldownSeconds < 0 {
+		return rule, "max_on_seconds must be positive and cooldown_seconds not negative"
+	}
+	return rule, ""
+}
+
+// GET /api/v1/automations
+func GetAutomations(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var rules []models.IrrigationRule
+		if err := db.Order("id").Find(&rules).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch automations",
+			})
+		}
+		return c.JSON(rules)
+	}
+}
+
+// POST /api/v1/automations
+func CreateAutomation(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input irrigationRuleInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid input",
+			})
+		}
+		rule, problem := input.rule()
+		if problem != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": problem,
+			})
+		}
+		rule.ID = 0
+		rule.State = models.AutomationIdle
+		rule.OnSince = nil
+		rule.LastOffAt = nil
+		rule.LastError = ""
+
+		if err := db.Create(&rule).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save automation",
+			})
+		}
+		return c.Status(fiber.StatusCreated).JSON(rule)
+	}
+}
+
+// PUT /api/v1/automations/:id updates the rule settings. A running relay is
+// switched off first if the rule is disabled or pointed at another relay.
+func UpdateAutomation(engine *automation.Engine) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseUint(c.Params("id"), 10, 0)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Automation not found",
+			})
+		}
+
+		var input irrigationRuleInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid input",
+			})
+		}
+		rule, problem := input.rule()
+		if problem != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": problem,
+			})
+		}
+
+		updated, err := engine.UpdateRule(c.Context(), uint(id), rule)
+		return automationResult(c, err, updated, "Failed to save automation")
+	}
+}
+
+// DELETE /api/v1/automations/:id switches the relay off if it is running.
+func DeleteAutomation(engine *automation.Engine) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseUint(c.Params("id"), 10, 0)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Automation not found",
+			})
+		}
+		if err := engine.DeleteRule(c.Context(), uint(id)); err != nil {
+			return automationResult(c, err, nil, "Failed to delete automation")
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// automationResult answers with the rule, or maps an engine error to its
+// status.
+func automationResult(c *fiber.Ctx, err error, rule interface{}, failed string) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Automation not found",
+		})
+	case errors.Is(err, automation.ErrSwitchOff):
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": failed,
+		})
+	}
+	return c.JSON(rule)
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"my-smart-farm/calibration"
+	"my-smart-farm/models"
+	"my-smart-farm/quality"
+	"my-smart-farm/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// calibrationInput is either a two-point calibration, the raw values of the
+// probe in dry air and in water, or a list of points for a piecewise curve.
+type calibrationInput struct {
+	Dry    *int                          `json:"dry"`
+	Wet    *int                          `json:"wet"`
+	Points []models.SoilCalibrationPoint `json:"points"`
+}
+
+func (in calibrationInput) points() ([]models.SoilCalibrationPoint, error) {
+	switch {
+	case in.Dry != nil && in.Wet != nil && in.Points == nil:
+		return []models.SoilCalibrationPoint{{Raw: *in.Dry, Percent: 0}, {Raw: *in.Wet, Percent: 100}}, nil
+	case in.Dry == nil && in.Wet == nil && in.Points != nil:
+		return in.Points, nil
+	}
+	return nil, errors.New("give either dry and wet or points")
+}
+
+// GET /api/v1/devices/:deviceID/calibration
+func GetCalibration(cals store.Calibrations) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cal, err := cals.Get(c.Params("deviceID"))
+		if errors.Is(err, store.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Probe is not calibrated",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch calibration",
+			})
+		}
+		return c.JSON(cal)
+	}
+}
+
+// PUT /api/v1/devices/:deviceID/calibration
+// Body {"dry":52000,"wet":21000} or {"points":[{"raw":52000,"percent":0},...]}.
+// New readings use it at once; stored ones only after a recompute.
+func SetCalibration(cals store.Calibrations) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var in calibrationInput
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid input",
+			})
+		}
+		points, err := in.points()
+		if err == nil {
+			err = calibration.Validate(points)
+		}
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		cal := models.SoilCalibration{
+			DeviceID: c.Params("deviceID"),
+			Points:   points,
+			Updated:  time.Now(),
+		}
+		if err := cals.Put(cal); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save calibration",
+			})
+		}
+		return c.JSON(cal)
+	}
+}
+
+// DELETE /api/v1/devices/:deviceID/calibration
+func DeleteCalibration(cals store.Calibrations) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := cals.Delete(c.Params("deviceID"))
+		if errors.Is(err, store.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Probe is not calibrated",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete calibration",
+			})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// POST /api/v1/devices/:deviceID/calibration/recompute?from=&to=
+// Recomputes the soil moisture of stored readings that carry a raw value and
+// grades the range again with rules, when set.
+func RecomputeCalibration(s *store.Store, rules *quality.Rules) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		q, err := parseReadingQuery(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		updated, err := calibration.Recompute(s, rules, c.Params("deviceID"), q.From, q.To)
+		if errors.Is(err, store.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Probe is not calibrated",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Recompute failed: " + err.Error(),
+			})
+		}
+		return c.JSON(fiber.Map{"updated": updated})
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"my-smart-farm/climate"
+	"my-smart-farm/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// climateQuery selects daily climate summaries.
+type climateQuery struct {
+	DeviceIDs []string
+	From, To  time.Time
+	Limit     int
+}
+
+// parseClimateQuery reads device_id, from, to and limit. From and to are
+// local dates like 2025-04-01, RFC3339 timestamps or unix seconds.
+func parseClimateQuery(c *fiber.Ctx) (climateQuery, error) {
+	q := climateQuery{Limit: defaultQueryLimit}
+	for _, id := range strings.Split(c.Query("device_id"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			q.DeviceIDs = append(q.DeviceIDs, id)
+		}
+	}
+	day := func(v string) (time.Time, error) {
+		if t, err := time.ParseInLocation(time.DateOnly, v, time.Local); err == nil {
+			return t, nil
+		}
+		return parseTimeParam(v)
+	}
+	var err error
+	if q.From, err = day(c.Query("from")); err != nil {
+		return q, errors.New("invalid from: use a date like 2025-04-01, RFC3339 or unix seconds")
+	}
+	if q.To, err = day(c.Query("to")); err != nil {
+		return q, errors.New("invalid to: use a date like 2025-04-01, RFC3339 or unix seconds")
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && q.To.Before(q.From) {
+		return q, errors.New("to must not be before from")
+	}
+	if v := c.Query("limit"); v != "" {
+		q.Limit, err = strconv.Atoi(v)
+		if err != nil || q.Limit <= 0 {
+			return q, errors.New("invalid limit")
+		}
+		q.Limit = min(q.Limit, maxQueryLimit)
+	}
+	return q, nil
+}
+
+// GET /api/v1/climate/daily?device_id=&from=&to=&limit=
+// Daily climate summaries with growing degree days, oldest first, and the
+// missing or incomplete days among them:
+// {"days":[...],"gaps":[{"device_id":"sensor-001","day":"...","reason":"missing"}]}.
+func GetDailyClimate(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		q, err := parseClimateQuery(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		tx := db.Model(&models.DailyClimate{})
+		if len(q.DeviceIDs) > 0 {
+			tx = tx.Where("device_id IN ?", q.DeviceIDs)
+		}
+		if !q.From.IsZero() {
+			tx = tx.Where("day >= ?", q.From)
+		}
+		if !q.To.IsZero() {
+			tx = tx.Where("day < ?", q.To)
+		}
+
+		var days []models.DailyClimate
+		if err := tx.Order("device_id, day").Limit(q.Limit).Find(&days).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve climate summaries",
+			})
+		}
+		return c.JSON(fiber.Map{"days": days, "gaps": climate.Gaps(days)})
+	}
+}
+
+// plantingInput takes the planting date as a plain local date.
+type plantingInput struct {
+	Crop            string   `json:"crop"`
+	PlantedOn       string   `json:"planted_on"` // 2006-01-02
+	BaseTemperature *float64 `json:"base_temperature"`
+}
+
+// GET /api/v1/devices/:deviceID/planting
+func GetPlanting(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var planting models.Planting
+		err := db.First(&planting, "device_id = ?", c.Params("deviceID")).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "No planting recorded",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch planting",
+			})
+		}
+		return c.JSON(planting)
+	}
+}
+
+// PUT /api/v1/devices/:deviceID/planting
+// Body {"crop":"tomato","planted_on":"2025-03-01","base_temperature":10}.
+// Growing degree days of the stored summaries are recomputed at once.
+func SetPlanting(db *gorm.DB, job *climate.Job) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var in plantingInput
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid input",
+			})
+		}
+		plantedOn, err := time.ParseInLocation(time.DateOnly, in.PlantedOn, time.Local)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "planted_on must be a date like 2025-03-01",
+			})
+		}
+		if b := in.BaseTemperature; b != nil && (math.IsNaN(*b) || *b < -10 || *b > 40) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "base_temperature must be between -10 and 40",
+			})
+		}
+
+		planting := models.Planting{
+			DeviceID:        c.Params("deviceID"),
+			Crop:            in.Crop,
+			PlantedOn:       plantedOn,
+			BaseTemperature: in.BaseTemperature,
+			Updated:         time.Now(),
+		}
+		if err := db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&planting).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save planting",
+			})
+		}
+		if err := job.Recalculate(planting.DeviceID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Planting saved but GDD not recomputed: " + err.Error(),
+			})
+		}
+		return c.JSON(planting)
+	}
+}
+
+// DELETE /api/v1/devices/:deviceID/planting clears cumulative GDD; daily GDD
+// reverts to the default base.
+func DeletePlanting(db *gorm.DB, job *climate.Job) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		deviceID := c.Params("deviceID")
+		res := db.Delete(&models.Planting{}, "device_id = ?", deviceID)
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete planting",
+			})
+		}
+		if res.RowsAffected == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "No planting recorded",
+			})
+		}
+		if err := job.Recalculate(deviceID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Planting deleted but GDD not recomputed: " + err.Error(),
+			})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// POST /api/v1/admin/climate/run summarises finished days now.
+func RunClimate(job *climate.Job) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := job.RunOnce(time.Now()); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Climate run failed: " + err.Error(),
+			})
+		}
+		return c.JSON(job.Status())
+	}
+}
@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+
+	"my-smart-farm/middleware"
+	"my-smart-farm/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func validDeviceKind(kind string) bool {
+	return kind == models.DeviceKindSensor || kind == models.DeviceKindRelay
+}
+
+// relayProblem says why deviceID cannot name relayID as its relay, or
+// returns "" when it can: the relay must be another registered device of
+// kind relay.
+func relayProblem(db *gorm.DB, deviceID, relayID string) (string, error) {
+	if relayID == "" {
+		return "", nil
+	}
+	if relayID == deviceID {
+		return "relay_id must name another device", nil
+	}
+	var relays []models.Device
+	if err := db.Where("device_id = ?", relayID).Limit(1).Find(&relays).Error; err != nil {
+		return "", err
+	}
+	if len(relays) == 0 || relays[0].Kind != models.DeviceKindRelay {
+		return "relay_id must name a registered relay", nil
+	}
+	return "", nil
+}
+
+// GET /api/v1/devices?kind=&zone=
+func GetAllDevices(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tx := db.Preload("Interval").Preload("Relay")
+		if kind := c.Query("kind"); kind != "" {
+			tx = tx.Where("kind = ?", kind)
+		}
+		if zone := c.Query("zone"); zone != "" {
+			tx = tx.Where("zone = ?", zone)
+		}
+
+		var devices []models.Device
+		if err := tx.Order("device_id").Find(&devices).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch devices",
+			})
+		}
+		return c.JSON(devices)
+	}
+}
+
+// GET /api/v1/devices/:deviceID
+func GetDevice(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var device models.Device
+		err := db.Preload("Interval").Preload("Relay").
+			First(&device, "device_id = ?", c.Params("deviceID")).Error
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Device not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Database error",
+			})
+		}
+		return c.JSON(device)
+	}
+}
+
+// POST /api/v1/devices
+func CreateDevice(db *gorm.DB, defaultInterval int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var device models.Device
+		if err := c.BodyParser(&device); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid input",
+			})
+		}
+		if device.Kind == "" {
+			device.Kind = models.DeviceKindSensor
+		}
+		if device.DeviceID == "" || !validDeviceKind(device.Kind) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Missing device_id or invalid kind (sensor or relay)",
+			})
+		}
+		device.LastSeen = nil
+		device.Interval = nil
+		device.Relay = nil
+		problem, err := relayProblem(db, device.DeviceID, device.RelayID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Database error",
+			})
+		}
+		if problem != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": problem,
+			})
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&device).Error; err != nil {
+				return err
+			}
+			if device.Kind != models.DeviceKindSensor {
+				return nil
+			}
+			// Sensors get the default interval up front instead of on first reading.
+			return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.IntervalSetting{
+				DeviceID:        device.DeviceID,
+				IntervalSeconds: defaultInterval,
+			}).Error
+		})
+		if err != nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Failed to create device; it may already exist",
+			})
+		}
+		return c.Status(fiber.StatusCreated).JSON(device)
+	}
+}
+
+// PUT /api/v1/devices/:deviceID updates the editable metadata of a device.
+func UpdateDevice(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input models.Device
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid input",
+			})
+		}
+		if input.Kind != "" && !validDeviceKind(input.Kind) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid kind (sensor or relay)",
+			})
+		}
+
+		var device models.Device
+		if err := db.First(&device, "device_id = ?", c.Params("deviceID")).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Device not found",
+			})
+		}
+		problem, err := relayProblem(db, device.DeviceID, input.RelayID)
+		if err == nil && problem == "" && device.Kind == models.DeviceKindRelay && input.Kind == models.DeviceKindSensor {
+			var users int64
+			err = db.Model(&models.Device{}).Where("relay_id = ?", device.DeviceID).Count(&users).Error
+			if users > 0 {
+				problem = "Sensors still name this device as their relay"
+			}
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Database error",
+			})
+		}
+		if problem != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": problem,
+			})
+		}
+
+		err = db.Model(&device).Select(
+			"Name", "Kind", "Zone", "Location", "FirmwareVersion", "Notes", "RelayID",
+		).Updates(models.Device{
+			Name:            input.Name,
+			Kind:            defaultString(input.Kind, device.Kind),
+			Zone:            input.Zone,
+			Location:        input.Location,
+			FirmwareVersion: input.FirmwareVersion,
+			Notes:           input.Notes,
+			RelayID:         input.RelayID,
+		}).Error
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update device",
+			})
+		}
+		return c.JSON(device)
+	}
+}
+
+// DELETE /api/v1/devices/:deviceID removes a device with its interval,
+// relay registration, soil calibration and planting, and clears it as the
+// relay of other devices. Stored readings and climate summaries are kept.
+func DeleteDevice(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		deviceID := c.Params("deviceID")
+		err := db.Transaction(func(tx *gorm.DB) error {
+			res := tx.Delete(&models.Device{}, "device_id = ?", deviceID)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			if err := tx.Delete(&models.IntervalSetting{}, "device_id = ?", deviceID).Error; err != nil {
+				return err
+			}
+			if err := tx.Delete(&models.RelayDevice{}, "device_id = ?", deviceID).Error; err != nil {
+				return err
+			}
+			if err := tx.Delete(&models.SoilCalibration{}, "device_id = ?", deviceID).Error; err != nil {
+				return err
+			}
+			if err := tx.Delete(&models.Planting{}, "device_id = ?", deviceID).Error; err != nil {
+				return err
+			}
+			return tx.Model(&models.Device{}).Where("relay_id = ?", deviceID).Update("relay_id", "").Error
+		})
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Device not found",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete device",
+			})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// POST /api/v1/devices/:deviceID/secret generates a new signing secret for
+// the device. The secret is only ever returned by this call.
+func RotateDeviceSecret(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var key [32]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to generate secret",
+			})
+		}
+		secret := hex.EncodeToString(key[:])
+
+		res := db.Model(&models.Device{}).Where("device_id = ?", c.Params("deviceID")).Update("secret", secret)
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to store secret",
+			})
+		}
+		if res.RowsAffected == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Device not found",
+			})
+		}
+		return c.JSON(fiber.Map{
+			"device_id": c.Params("deviceID"),
+			"secret":    secret,
+		})
+	}
+}
+
+// POST /api/v1/devices/:deviceID/mqtt-password generates a new password for
+// the device to log in to the embedded MQTT broker. Only its hash is stored,
+// so the password is only ever returned by this call.
+func RotateDeviceMQTTPassword(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var key [24]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to generate password",
+			})
+		}
+		password := hex.EncodeToString(key[:])
+
+		res := db.Model(&models.Device{}).Where("device_id = ?", c.Params("deviceID")).
+			Update("mqtt_password_hash", middleware.HashToken(password))
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to store password",
+			})
+		}
+		if res.RowsAffected == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Device not found",
+			})
+		}
+		return c.JSON(fiber.Map{
+			"device_id": c.Params("deviceID"),
+			"username":  c.Params("deviceID"),
+			"password":  password,
+		})
+	}
+}
+
+func defaultString(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"my-smart-farm/ingest"
+	"my-smart-farm/models"
+	"my-smart-farm/quality"
+	"my-smart-farm/relay"
+	"my-smart-farm/store"
+	"my-smart-farm/store/storetest"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// do sends a request to app and decodes a JSON answer into out.
+func do(t *testing.T, app *fiber.App, method, path, body string, out interface{}) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != nil {
+		raw, _ := io.ReadAll(resp.Body)
+		if err := json.Unmarshal(raw, out); err != nil {
+			t.Fatalf("%s %s: %v in %s", method, path, err, raw)
+		}
+	}
+	return resp
+}
+
+func TestReadingHandlers(t *testing.T) {
+	s := store.NewMemory()
+	pipe := ingest.NewPipeline(s)
+	app := fiber.New()
+	app.Post("/data", CreateSensorData(pipe))
+	app.Post("/data/batch", CreateSensorDataBatch(s.Readings, pipe))
+	app.Get("/data", GetAllSensorData(s.Readings))
+	app.Get("/data/device/:deviceID", GetSensorDataByDeviceID(s.Readings))
+	app.Post("/interval", SetInterval(s.Intervals))
+	app.Get("/intervals", GetAllIntervals(s.Intervals))
+
+	var created struct{ IntervalSeconds int }
+	resp := do(t, app, "POST", "/data", `{"DeviceID":"sensor-001","Soil":31,"Timestamp":"2025-04-04T06:00:00+07:00"}`, &created)
+	if resp.StatusCode != fiber.StatusCreated || created.IntervalSeconds <= 0 || created.IntervalSeconds > 60 {
+		t.Fatalf("create answered %d with interval %d", resp.StatusCode, created.IntervalSeconds)
+	}
+	var intervals []models.IntervalSetting
+	do(t, app, "GET", "/intervals", "", &intervals)
+	if len(intervals) != 1 || intervals[0].IntervalSeconds != 60 {
+		t.Errorf("first reading should create the default interval, got %+v", intervals)
+	}
+	if resp := do(t, app, "POST", "/interval", `{"DeviceID":"sensor-001","IntervalSeconds":0}`, nil); resp.StatusCode != fiber.StatusBadRequest {
+		t.Error("zero interval accepted")
+	}
+
+	var batch struct {
+		Results []batchItemResult `json:"results"`
+	}
+	do(t, app, "POST", "/data/batch", `{"deviceID":"sensor-001","readings":[
+		{"Soil":31,"Timestamp":"2025-04-03T23:00:00Z"},
+		{"Soil":30,"Timestamp":"2025-04-04T06:01:00+07:00"},
+		{"DeviceID":"sensor-002","Soil":29,"Timestamp":"2025-04-04T06:01:00+07:00"}]}`, &batch)
+	statuses := []string{}
+	for _, r := range batch.Results {
+		statuses = append(statuses, r.Status)
+	}
+	if strings.Join(statuses, ",") != "duplicate,created,created" {
+		t.Errorf("unexpected batch results %+v", batch.Results)
+	}
+
+	var page []models.SensorData
+	resp = do(t, app, "GET", "/data/device/sensor-001?order=desc&limit=1", "", &page)
+	if len(page) != 1 || page[0].Soil != 30 || resp.Header.Get("X-Next-Cursor") == "" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+	do(t, app, "GET", "/data/device/sensor-001?order=desc&limit=1&cursor="+resp.Header.Get("X-Next-Cursor"), "", &page)
+	if len(page) != 1 || page[0].Soil != 31 {
+		t.Errorf("unexpected second page %+v", page)
+	} else if _, off := page[0].Timestamp.Zone(); off != localOffset(page[0].Timestamp) {
+		t.Errorf("single reading stored as %v, not local time", page[0].Timestamp)
+	}
+	do(t, app, "GET", "/data?device_id=sensor-001,sensor-002&from=2025-04-04T06:01:00%2B07:00", "", &page)
+	if len(page) != 2 {
+		t.Errorf("want 2 readings since 06:01, got %+v", page)
+	}
+}
+
+func localOffset(t time.Time) int {
+	_, off := t.Local().Zone()
+	return off
+}
+
+func TestReadingQuality(t *testing.T) {
+	s := store.NewMemory()
+	pipe := ingest.NewPipeline(s)
+	rules := quality.DefaultRules()
+	pipe.Rules = &rules
+	hooked := 0
+	pipe.AddHook(func(*models.SensorData) { hooked++ })
+	app := fiber.New()
+	app.Post("/data", CreateSensorData(pipe))
+	app.Post("/data/batch", CreateSensorDataBatch(s.Readings, pipe))
+	app.Get("/data", GetAllSensorData(s.Readings))
+
+	var created struct{ Quality string }
+	do(t, app, "POST", "/data", `{"DeviceID":"sensor-001","Temperature":-40,"Humidity":0,"Soil":31,"Timestamp":"2025-04-04T06:00:00+07:00"}`, &created)
+	if created.Quality != models.QualityRejected {
+		t.Errorf("DHT22 glitch graded %q", created.Quality)
+	}
+	var batch struct {
+		Results []batchItemResult `json:"results"`
+	}
+	do(t, app, "POST", "/data/batch", `{"deviceID":"sensor-001","readings":[
+		{"Temperature":36,"Humidity":60,"Soil":30,"Timestamp":"2025-04-04T06:02:00+07:00"},
+		{"Temperature":28,"Humidity":60,"Soil":31,"Timestamp":"2025-04-04T06:01:00+07:00"}]}`, &batch)
+	if len(batch.Results) != 2 || batch.Results[0].Quality != models.QualitySuspect || batch.Results[1].Quality != models.QualityOK {
+		t.Errorf("batch should be graded in time order: %+v", batch.Results)
+	}
+	if hooked != 2 {
+		t.Errorf("rejected reading reached the hooks: %d hook calls", hooked)
+	}
+
+	var page []models.SensorData
+	do(t, app, "GET", "/data", "", &page)
+	if len(page) != 2 {
+		t.Errorf("rejected reading should be hidden by default, got %+v", page)
+	}
+	do(t, app, "GET", "/data?quality=all", "", &page)
+	if len(page) != 3 || page[0].Quality != models.QualityRejected || page[0].QualityNote == "" {
+		t.Errorf("quality=all should include the rejected reading, got %+v", page)
+	}
+	do(t, app, "GET", "/data?quality=ok", "", &page)
+	if len(page) != 1 || page[0].Temperature != 28 {
+		t.Errorf("quality=ok should leave the ok reading, got %+v", page)
+	}
+	if resp := do(t, app, "GET", "/data?quality=bad", "", nil); resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("unknown quality answered %d", resp.StatusCode)
+	}
+}
+
+func TestRelayHandlers(t *testing.T) {
+	s := store.NewMemory()
+	monitor := relay.NewMonitor(nil)
+	app := fiber.New()
+	app.Post("/relay/register", RegisterRelayIP(s.Relays, s.Devices))
+	app.Post("/relay/heartbeat", RelayHeartbeat(s.Relays, s.Devices))
+	app.Get("/relay/:deviceID", GetRelayIP(s.Relays))
+	app.Get("/relays", GetAllRelays(s.Relays, monitor))
+
+	if resp := do(t, app, "POST", "/relay/heartbeat", `{"device_id":"relay-001"}`, nil); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("heartbeat before register answered %d", resp.StatusCode)
+	}
+	do(t, app, "POST", "/relay/register", `{"device_id":"relay-001","ip":"192.168.1.50"}`, nil)
+	if resp := do(t, app, "POST", "/relay/heartbeat", `{"device_id":"relay-001","ip":"192.168.1.51"}`, nil); resp.StatusCode != fiber.StatusNoContent {
+		t.Errorf("heartbeat answered %d", resp.StatusCode)
+	}
+
+	var device models.RelayDevice
+	do(t, app, "GET", "/relay/relay-001", "", &device)
+	if device.IP != "192.168.1.51" {
+		t.Errorf("heartbeat did not move the relay: %+v", device)
+	}
+	if resp := do(t, app, "GET", "/relay/relay-404", "", nil); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("unknown relay answered %d", resp.StatusCode)
+	}
+
+	var relays []models.RelayDevice
+	do(t, app, "GET", "/relays", "", &relays)
+	if len(relays) != 1 || relays[0].Status != models.RelayOnline {
+		t.Errorf("fresh relay should be online: %+v", relays)
+	}
+	if relays[0].LastHeartbeat == nil || time.Since(*relays[0].LastHeartbeat) > time.Minute {
+		t.Errorf("register should count as a heartbeat: %+v", relays[0])
+	}
+}
+
+func TestDeviceRelayReference(t *testing.T) {
+	db := storetest.SQLite(t, &models.Device{}, &models.IntervalSetting{}, &models.RelayDevice{}, &models.SoilCalibration{}, &models.Planting{})
+	app := fiber.New()
+	app.Post("/devices", CreateDevice(db, 60))
+	app.Put("/devices/:deviceID", UpdateDevice(db))
+	app.Delete("/devices/:deviceID", DeleteDevice(db))
+
+	if resp := do(t, app, "POST", "/devices", `{"device_id":"sensor-001","relay_id":"relay-001"}`, nil); resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("unknown relay accepted: %d", resp.StatusCode)
+	}
+	do(t, app, "POST", "/devices", `{"device_id":"relay-001","kind":"relay"}`, nil)
+	do(t, app, "POST", "/devices", `{"device_id":"sensor-002"}`, nil)
+	if resp := do(t, app, "POST", "/devices", `{"device_id":"sensor-001","relay_id":"sensor-002"}`, nil); resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("sensor accepted as relay: %d", resp.StatusCode)
+	}
+	if resp := do(t, app, "POST", "/devices", `{"device_id":"sensor-001","relay_id":"relay-001"}`, nil); resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("registered relay refused: %d", resp.StatusCode)
+	}
+	if resp := do(t, app, "PUT", "/devices/sensor-002", `{"relay_id":"relay-404"}`, nil); resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("update to unknown relay accepted: %d", resp.StatusCode)
+	}
+	if resp := do(t, app, "PUT", "/devices/relay-001", `{"kind":"sensor"}`, nil); resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("relay in use turned into a sensor: %d", resp.StatusCode)
+	}
+
+	if resp := do(t, app, "DELETE", "/devices/relay-001", "", nil); resp.StatusCode != fiber.StatusNoContent {
+		t.Errorf("delete answered %d", resp.StatusCode)
+	}
+	if resp := do(t, app, "DELETE", "/devices/relay-001", "", nil); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("deleting an unknown device answered %d", resp.StatusCode)
+	}
+	var sensor models.Device
+	db.First(&sensor, "device_id = ?", "sensor-001")
+	if sensor.RelayID != "" {
+		t.Errorf("deleted relay still referenced: %+v", sensor)
+	}
+}
+
+func TestGetRelayCommand(t *testing.T) {
+	db := storetest.SQLite(t, &models.RelayCommand{}, &models.Device{})
+	app := fiber.New()
+	app.Get("/relay-commands/:id", GetRelayCommand(db))
+	db.Create(&models.Device{DeviceID: "relay-001", Secret: "s3cret"})
+	db.Create(&models.RelayCommand{DeviceID: "relay-001", Action: "on", IssuedAt: time.Now(), IssuedBy: models.IssuerUser})
+
+	var cmd models.RelayCommand
+	if resp := do(t, app, "GET", "/relay-commands/1", "", &cmd); resp.StatusCode != fiber.StatusOK || cmd.Action != "on" {
+		t.Errorf("command 1 answered %d: %+v", resp.StatusCode, cmd)
+	}
+	for _, id := range []string{"2", "id=(select(count(1))from(devices)where(secret='s3cret'))", "1=1"} {
+		want := fiber.StatusBadRequest
+		if id == "2" {
+			want = fiber.StatusNotFound
+		}
+		if resp := do(t, app, "GET", "/relay-commands/"+url.PathEscape(id), "", nil); resp.StatusCode != want {
+			t.Errorf("id %q answered %d, want %d", id, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestDeleteAlertRule(t *testing.T) {
+	db := storetest.SQLite(t, &models.AlertRule{}, &models.Alert{})
+	app := fiber.New()
+	app.Delete("/alert-rules/:id", DeleteAlertRule(db))
+	db.Create(&models.AlertRule{Metric: "soil", Operator: "<", Value: 20, Enabled: true})
+	db.Create(&models.AlertRule{Metric: "temperature", Operator: ">", Value: 35, Enabled: true})
+
+	if resp := do(t, app, "DELETE", "/alert-rules/1=1", "", nil); resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("non-numeric id answered %d", resp.StatusCode)
+	}
+	if resp := do(t, app, "DELETE", "/alert-rules/3", "", nil); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("unknown rule answered %d", resp.StatusCode)
+	}
+	if resp := do(t, app, "DELETE", "/alert-rules/1", "", nil); resp.StatusCode != fiber.StatusNoContent {
+		t.Errorf("delete answered %d", resp.StatusCode)
+	}
+	var left int64
+	db.Model(&models.AlertRule{}).Count(&left)
+	if left != 1 {
+		t.Errorf("%d alert rules left, want 1", left)
+	}
+}
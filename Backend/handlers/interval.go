@@ -2,13 +2,12 @@ package handlers
 
 import (
 	"my-smart-farm/models"
+	"my-smart-farm/store"
 
 	"github.com/gofiber/fiber/v2"
-	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
-func SetInterval(db *gorm.DB) fiber.Handler {
+func SetInterval(intervals store.Intervals) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var setting models.IntervalSetting
 
@@ -24,11 +23,7 @@ func SetInterval(db *gorm.DB) fiber.Handler {
 			})
 		}
 
-		err := db.Clauses(clause.OnConflict{
-			UpdateAll: true,
-		}).Create(&setting).Error
-
-		if err != nil {
+		if err := intervals.Put(setting); err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to save interval setting",
 			})
@@ -38,14 +33,14 @@ func SetInterval(db *gorm.DB) fiber.Handler {
 	}
 }
 
-func GetAllIntervals(db *gorm.DB) fiber.Handler {
+func GetAllIntervals(intervals store.Intervals) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		var intervals []models.IntervalSetting
-		if err := db.Find(&intervals).Error; err != nil {
+		all, err := intervals.List()
+		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to fetch interval settings",
 			})
 		}
-		return c.JSON(intervals)
+		return c.JSON(all)
 	}
 }
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"log"
+	"time"
+
+	"my-smart-farm/metrics"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GET /metrics serves Prometheus metrics. Scrapers must send token as a
+// bearer token; an empty token refuses every request.
+func Metrics(reg *metrics.Registry, token string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		got := c.Get(fiber.HeaderAuthorization)
+		if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte("Bearer "+token)) != 1 {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Missing or invalid metrics token",
+			})
+		}
+		c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			if err := reg.Write(w, time.Now()); err != nil {
+				log.Println("metrics:", err)
+			}
+		})
+		return nil
+	}
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"slices"
+	"strconv"
+
+	"my-smart-farm/models"
+	"my-smart-farm/notify"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// maskedToken replaces stored credentials in API responses.
+const maskedToken = "********"
+
+var channelKinds = []string{models.ChannelWebhook, models.ChannelEmail, models.ChannelLINE}
+
+// channelInput uses a pointer for Enabled so an omitted field means true.
+type channelInput struct {
+	models.NotificationChannel
+	Enabled *bool `json:"enabled"`
+}
+
+func (in channelInput) channel() (models.NotificationChannel, string) {
+	ch := in.NotificationChannel
+	ch.Enabled = in.Enabled == nil || *in.Enabled
+	ch.LastSentAt = nil
+	ch.LastError = ""
+	if !slices.Contains(channelKinds, ch.Kind) {
+		return ch, "kind must be webhook, email or line"
+	}
+	if _, err := notify.Render(ch.Template, notify.Event{}); err != nil {
+		return ch, "Invalid template: " + err.Error()
+	}
+	return ch, ""
+}
+
+func maskChannel(ch *models.NotificationChannel) {
+	if ch.Token != "" {
+		ch.Token = maskedToken
+	}
+}
+
+// GET /api/v1/notification-channels
+func GetNotificationChannels(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var channels []models.NotificationChannel
+		if err := db.Order("id").Find(&channels).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch notification channels",
+			})
+		}
+		for i := range channels {
+			maskChannel(&channels[i])
+		}
+		return c.JSON(channels)
+	}
+}
+
+// POST /api/v1/notification-channels
+func CreateNotificationChannel(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input channelInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid input",
+			})
+		}
+		ch, problem := input.channel()
+		if problem != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": problem,
+			})
+		}
+		ch.ID = 0
+
+		if err := db.Create(&ch).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save notification channel",
+			})
+		}
+		maskChannel(&ch)
+		return c.Status(fiber.StatusCreated).JSON(ch)
+	}
+}
+
+// PUT /api/v1/notification-channels/:id. A token of "********" or "" keeps
+// the stored credential.
+func UpdateNotificationChannel(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid notification channel id",
+			})
+		}
+		var existing models.NotificationChannel
+		if err := db.First(&existing, id).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Notification channel not found",
+			})
+		}
+
+		var input channelInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid input",
+			})
+		}
+		ch, problem := input.channel()
+		if problem != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": problem,
+			})
+		}
+		ch.ID = existing.ID
+		ch.CreatedAt = existing.CreatedAt
+		if ch.Token == "" || ch.Token == maskedToken {
+			ch.Token = existing.Token
+		}
+
+		if err := db.Save(&ch).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save notification channel",
+			})
+		}
+		maskChannel(&ch)
+		return c.JSON(ch)
+	}
+}
+
+// DELETE /api/v1/notification-channels/:id
+func DeleteNotificationChannel(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid notification channel id",
+			})
+		}
+		res := db.Delete(&models.NotificationChannel{}, id)
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete notification channel",
+			})
+		}
+		if res.RowsAffected == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Notification channel not found",
+			})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// POST /api/v1/notification-channels/:id/test sends a sample notification.
+func TestNotificationChannel(db *gorm.DB, d *notify.Dispatcher) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid notification channel id",
+			})
+		}
+		var ch models.NotificationChannel
+		if err := db.First(&ch, id).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Notification channel not found",
+			})
+		}
+		if err := d.SendTest(c.Context(), ch); err != nil {
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+				"error": "Test notification failed: " + err.Error(),
+			})
+		}
+		return c.JSON(fiber.Map{"message": "Sent!"})
+	}
+}
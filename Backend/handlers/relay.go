@@ -1,17 +1,21 @@
 package handlers
 
 import (
+	"errors"
+	"strconv"
 	"time"
 
+	"my-smart-farm/middleware"
 	"my-smart-farm/models"
+	"my-smart-farm/relay"
+	"my-smart-farm/store"
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
 // POST /api/v1/relay/register
-func RegisterRelayIP(db *gorm.DB) fiber.Handler {
+func RegisterRelayIP(relays store.Relays, devices store.Devices) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var device models.RelayDevice
 		if err := c.BodyParser(&device); err != nil {
@@ -20,31 +24,81 @@ func RegisterRelayIP(db *gorm.DB) fiber.Handler {
 			})
 		}
 
-		device.Updated = time.Now()
-
-		err := db.Clauses(clause.OnConflict{
-			UpdateAll: true,
-		}).Create(&device).Error
+		if caller := middleware.CallerDeviceID(c); caller != "" && device.DeviceID != caller {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "device_id does not match the calling device",
+			})
+		}
 
-		if err != nil {
+		// Only the address is refreshed and registering counts as a
+		// heartbeat; the relay's last known state stays.
+		now := time.Now()
+		if err := relays.Register(device.DeviceID, device.IP, now); err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to store IP",
 			})
 		}
+		devices.Touch(device.DeviceID, models.DeviceKindRelay, now)
 
 		return c.JSON(fiber.Map{"message": "Registered!"})
 	}
 }
 
+// POST /api/v1/relay/heartbeat marks the calling relay alive. The body may
+// carry {"ip": "..."} when the relay's address changed.
+func RelayHeartbeat(relays store.Relays, devices store.Devices) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var body struct {
+			DeviceID string `json:"device_id"`
+			IP       string `json:"ip"`
+		}
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&body); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid payload",
+				})
+			}
+		}
+		deviceID := body.DeviceID
+		if caller := middleware.CallerDeviceID(c); caller != "" {
+			if deviceID != "" && deviceID != caller {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"error": "device_id does not match the calling device",
+				})
+			}
+			deviceID = caller
+		}
+		if deviceID == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Missing device_id",
+			})
+		}
+
+		now := time.Now()
+		err := relays.Heartbeat(deviceID, body.IP, now)
+		if errors.Is(err, store.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Relay not registered",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to store heartbeat",
+			})
+		}
+		devices.Touch(deviceID, models.DeviceKindRelay, now)
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
 // GET /api/v1/relay/:deviceID
-func GetRelayIP(db *gorm.DB) fiber.Handler {
+func GetRelayIP(relays store.Relays) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		deviceID := c.Params("deviceID")
 
-		var device models.RelayDevice
-		err := db.First(&device, "device_id = ?", deviceID).Error
+		device, err := relays.Get(deviceID)
 		if err != nil {
-			if err == gorm.ErrRecordNotFound {
+			if err == store.ErrNotFound {
 				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 					"error": "Device not found",
 				})
@@ -58,12 +112,92 @@ func GetRelayIP(db *gorm.DB) fiber.Handler {
 	}
 }
 
-func GetAllRelays(db *gorm.DB) fiber.Handler {
+// GET /api/v1/relays lists relays with their liveness judged as of now.
+func GetAllRelays(relays store.Relays, monitor *relay.Monitor) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		var relays []models.RelayDevice
-		if err := db.Find(&relays).Error; err != nil {
+		all, err := relays.List()
+		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch relays"})
 		}
-		return c.JSON(relays)
+		now := time.Now()
+		for i := range all {
+			all[i].Status = monitor.StatusAt(all[i].LastHeartbeat, now)
+		}
+		return c.JSON(all)
+	}
+}
+
+// GET /api/v1/relay-commands?device_id=&issued_by=&status=&from=&to=&limit= lists
+// the relay command audit log, newest first.
+func GetRelayCommands(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		from, err := parseTimeParam(c.Query("from"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid from",
+			})
+		}
+		to, err := parseTimeParam(c.Query("to"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid to",
+			})
+		}
+		limit := c.QueryInt("limit", defaultQueryLimit)
+		if limit <= 0 || limit > maxQueryLimit {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid limit",
+			})
+		}
+
+		tx := db.Order("issued_at DESC, id DESC").Limit(limit)
+		if deviceID := c.Query("device_id"); deviceID != "" {
+			tx = tx.Where("device_id = ?", deviceID)
+		}
+		if issuedBy := c.Query("issued_by"); issuedBy != "" {
+			tx = tx.Where("issued_by = ?", issuedBy)
+		}
+		if status := c.Query("status"); status != "" {
+			tx = tx.Where("status = ?", status)
+		}
+		if !from.IsZero() {
+			tx = tx.Where("issued_at >= ?", from)
+		}
+		if !to.IsZero() {
+			tx = tx.Where("issued_at <= ?", to)
+		}
+
+		var commands []models.RelayCommand
+		if err := tx.Find(&commands).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch relay commands",
+			})
+		}
+		return c.JSON(commands)
+	}
+}
+
+// GET /api/v1/relay-commands/:id returns one command, so the dashboard can
+// poll a queued command until it is delivered, failed or expired.
+func GetRelayCommand(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid command id",
+			})
+		}
+		var cmd models.RelayCommand
+		if err := db.First(&cmd, id).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Command not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Database error",
+			})
+		}
+		return c.JSON(cmd)
 	}
 }
@@ -1,46 +1,36 @@
 package handlers
 
 import (
-	"fmt"
-	"io"
-	"net/http"
-	"time"
+	"errors"
 
-	"my-smart-farm/models"
+	"my-smart-farm/middleware"
+	"my-smart-farm/relay"
 
 	"github.com/gofiber/fiber/v2"
-	"gorm.io/gorm"
 )
 
-// ProxyRelayCommand sends on/off command to the relay device via IP
-func ProxyRelayCommand(db *gorm.DB) fiber.Handler {
+// ProxyRelayCommand queues an on/off command for the relay device and
+// answers 202 with the command; poll GET /relay-commands/:id for delivery.
+func ProxyRelayCommand(queue *relay.Queue) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		deviceID := c.Params("deviceID")
 		action := c.Params("action") // should be "on" or "off"
 
-		if action != "on" && action != "off" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid action"})
+		var by relay.Issuer
+		if user := middleware.CurrentUser(c); user != nil {
+			by = relay.ByUser(user.Username)
 		}
 
-		var relay models.RelayDevice
-		if err := db.First(&relay, "device_id = ?", deviceID).Error; err != nil {
+		cmd, err := queue.Enqueue(deviceID, action, by)
+		switch {
+		case errors.Is(err, relay.ErrInvalidAction):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid action"})
+		case errors.Is(err, relay.ErrDeviceNotFound):
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Device not found"})
+		case err != nil:
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to queue relay command"})
 		}
 
-		url := fmt.Sprintf("http://%s/relay/%s", relay.IP, action)
-
-		// Forward the request to the actual device
-		client := http.Client{
-			Timeout: 3 * time.Second,
-		}
-		resp, err := client.Get(url)
-		if err != nil {
-			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Failed to reach relay device"})
-		}
-		defer resp.Body.Close()
-
-		body, _ := io.ReadAll(resp.Body)
-
-		return c.Status(resp.StatusCode).Send(body)
+		return c.Status(fiber.StatusAccepted).JSON(cmd)
 	}
 }
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"time"
+
+	"my-smart-farm/models"
+	"my-smart-farm/retention"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GET /api/v1/admin/retention
+func GetRetention(db *gorm.DB, job *retention.Job) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var policies []models.RetentionPolicy
+		if err := db.Find(&policies).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch retention policies",
+			})
+		}
+		return c.JSON(fiber.Map{
+			"default_days": int(job.DefaultRetention / (24 * time.Hour)),
+			"policies":     policies,
+			"status":       job.Status(),
+		})
+	}
+}
+
+// PUT /api/v1/admin/retention/:deviceID
+func SetRetention(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var policy models.RetentionPolicy
+		if err := c.BodyParser(&policy); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid input",
+			})
+		}
+		policy.DeviceID = c.Params("deviceID")
+		if policy.RawDays <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "raw_days must be positive",
+			})
+		}
+		policy.Updated = time.Now()
+
+		err := db.Clauses(clause.OnConflict{
+			UpdateAll: true,
+		}).Create(&policy).Error
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save retention policy",
+			})
+		}
+		return c.JSON(policy)
+	}
+}
+
+// DELETE /api/v1/admin/retention/:deviceID reverts a device to the default.
+func DeleteRetention(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		res := db.Delete(&models.RetentionPolicy{}, "device_id = ?", c.Params("deviceID"))
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete retention policy",
+			})
+		}
+		if res.RowsAffected == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Device has no retention policy",
+			})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// POST /api/v1/admin/retention/run triggers a compaction run immediately.
+func RunRetention(job *retention.Job) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := job.RunOnce(time.Now()); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Retention run failed: " + err.Error(),
+			})
+		}
+		return c.JSON(job.Status())
+	}
+}
+
+// GET /api/v1/data/rollups?resolution=hour|day&device_id=&from=&to=
+func GetRollups(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		q, err := parseReadingQuery(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		var table string
+		switch c.Query("resolution", "hour") {
+		case "hour":
+			table = "hourly_rollups"
+		case "day":
+			table = "daily_rollups"
+		default:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "resolution must be hour or day",
+			})
+		}
+
+		tx := db.Table(table)
+		if len(q.DeviceIDs) > 0 {
+			tx = tx.Where("device_id IN ?", q.DeviceIDs)
+		}
+		if !q.From.IsZero() {
+			tx = tx.Where("start >= ?", q.From)
+		}
+		if !q.To.IsZero() {
+			tx = tx.Where("start < ?", q.To)
+		}
+
+		var rollups []models.SensorRollup
+		if err := tx.Order("device_id, start").Limit(q.Limit).Find(&rollups).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve rollups",
+			})
+		}
+		return c.JSON(rollups)
+	}
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"my-smart-farm/models"
+	"my-smart-farm/schedule"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// relayScheduleInput uses a pointer for Enabled so an omitted field means true.
+type relayScheduleInput struct {
+	models.RelaySchedule
+	Enabled *bool `json:"enabled"`
+}
+
+func (in relayScheduleInput) schedule() (models.RelaySchedule, string) {
+	sched := in.RelaySchedule
+	sched.Enabled = in.Enabled == nil || *in.Enabled
+	if sched.RelayID == "" {
+		return sched, "relay_id is required"
+	}
+	if err := schedule.Validate(sched); err != nil {
+		return sched, err.Error()
+	}
+	return sched, ""
+}
+
+// GET /api/v1/schedules
+func GetSchedules(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var schedules []models.RelaySchedule
+		if err := db.Order("id").Find(&schedules).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch schedules",
+			})
+		}
+		return c.JSON(schedules)
+	}
+}
+
+// GET /api/v1/schedules/upcoming?count=&relay_id=&schedule_id= lists the
+// next planned runs of enabled schedules, soonest first.
+func GetUpcomingRuns(db *gorm.DB, scheduler *schedule.Scheduler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		count := c.QueryInt("count", 10)
+		if count <= 0 || count > 100 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "count must be between 1 and 100",
+			})
+		}
+		tx := db.Where("enabled = ?", true)
+		if relayID := c.Query("relay_id"); relayID != "" {
+			tx = tx.Where("relay_id = ?", relayID)
+		}
+		if id := c.QueryInt("schedule_id"); id > 0 {
+			tx = tx.Where("id = ?", id)
+		}
+
+		var schedules []models.RelaySchedule
+		if err := tx.Find(&schedules).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch schedules",
+			})
+		}
+		return c.JSON(scheduler.Upcoming(schedules, time.Now(), count))
+	}
+}
+
+// POST /api/v1/schedules
+func CreateSchedule(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input relayScheduleInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid input",
+			})
+		}
+		sched, problem := input.schedule()
+		if problem != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": problem,
+			})
+		}
+		sched.ID = 0
+		sched.LastRunAt = nil
+		sched.OffAt = nil
+		sched.LastError = ""
+
+		if err := db.Create(&sched).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save schedule",
+			})
+		}
+		return c.Status(fiber.StatusCreated).JSON(sched)
+	}
+}
+
+// PUT /api/v1/schedules/:id updates the schedule settings. A run in
+// progress is switched off first if the schedule is disabled or pointed at
+// another relay.
+func UpdateSchedule(db *gorm.DB, scheduler *schedule.Scheduler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid schedule id",
+			})
+		}
+		var existing models.RelaySchedule
+		if err := db.First(&existing, id).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Schedule not found",
+			})
+		}
+
+		var input relayScheduleInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid input",
+			})
+		}
+		sched, problem := input.schedule()
+		if problem != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": problem,
+			})
+		}
+
+		if !sched.Enabled || sched.RelayID != existing.RelayID {
+			if err := scheduler.StopSchedule(c.Context(), existing.ID); err != nil {
+				return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+		}
+
+		err = db.Model(&existing).Select(
+			"Name", "RelayID", "Cron", "DurationSeconds", "TimeZone", "Enabled",
+		).Updates(&sched).Error
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save schedule",
+			})
+		}
+		db.First(&existing, existing.ID)
+		return c.JSON(existing)
+	}
+}
+
+// DELETE /api/v1/schedules/:id switches the relay off if a run is in progress.
+func DeleteSchedule(db *gorm.DB, scheduler *schedule.Scheduler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid schedule id",
+			})
+		}
+		var sched models.RelaySchedule
+		if err := db.First(&sched, id).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Schedule not found",
+			})
+		}
+		if err := scheduler.StopSchedule(c.Context(), sched.ID); err != nil {
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if err := db.Delete(&sched).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete schedule",
+			})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
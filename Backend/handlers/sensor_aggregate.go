@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"time"
+
+	"my-smart-farm/aggregate"
+	"my-smart-farm/models"
+	"my-smart-farm/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxAggregateBuckets bounds the series length a single request can ask for.
+const maxAggregateBuckets = 5000
+
+// GET /api/v1/data/aggregate?bucket=5m&device_id=a,b&from=&to=
+//
+// Returns min/max/avg/count of Temperature, Humidity, Soil and the derived
+// VPD, dew point, absolute humidity and heat index per device and time
+// bucket. Without from, the last 24 hours are summarised.
+func GetAggregatedSensorData(readings store.Readings) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		q, err := parseReadingQuery(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		size, err := aggregate.ParseBucket(c.Query("bucket", "1h"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		end := q.To
+		if end.IsZero() {
+			end = time.Now()
+		}
+		if q.From.IsZero() {
+			q.From = end.Add(-24 * time.Hour)
+		}
+		if end.Sub(q.From)/size > maxAggregateBuckets {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Too many buckets; use a larger bucket or a shorter range",
+			})
+		}
+
+		acc := aggregate.NewAccumulator(size)
+		err = readings.Each(q.ReadingFilter, func(data *models.SensorData) error {
+			acc.Add(data)
+			return nil
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to retrieve data",
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"bucket_seconds": int(size / time.Second),
+			"from":           q.From,
+			"to":             end,
+			"series":         acc.Buckets(),
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"my-smart-farm/ingest"
+	"my-smart-farm/middleware"
+	"my-smart-farm/models"
+	"my-smart-farm/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const maxBatchSize = 1000
+
+// batchRequest is the object form of a batch upload. Readings without a
+// deviceID inherit the batch's deviceID.
+type batchRequest struct {
+	DeviceID string              `json:"deviceID"`
+	Readings []models.SensorData `json:"readings"`
+}
+
+// batchItemResult reports what happened to one reading of a batch.
+type batchItemResult struct {
+	Index   int    `json:"index"`
+	Status  string `json:"status"` // created, duplicate or rejected
+	ID      uint   `json:"id,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Quality string `json:"quality,omitempty"`
+}
+
+// POST /api/v1/data/batch
+//
+// Accepts either a JSON array of readings or {"deviceID": ..., "readings": [...]}
+// and stores them in one transaction. Readings that already exist for the
+// same device and timestamp are reported as duplicates, so a device can
+// safely re-send a backlog whose previous upload was interrupted.
+func CreateSensorDataBatch(readings store.Readings, pipe *ingest.Pipeline) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req batchRequest
+		body := bytes.TrimSpace(c.Body())
+		var err error
+		if len(body) > 0 && body[0] == '[' {
+			err = json.Unmarshal(body, &req.Readings)
+		} else {
+			err = json.Unmarshal(body, &req)
+		}
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Cannot parse JSON",
+			})
+		}
+		if len(req.Readings) == 0 || len(req.Readings) > maxBatchSize {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Batch must contain between 1 and 1000 readings",
+			})
+		}
+
+		caller := middleware.CallerDeviceID(c)
+		results := make([]batchItemResult, len(req.Readings))
+		now := time.Now()
+		for i := range req.Readings {
+			data := &req.Readings[i]
+			results[i].Index = i
+			data.ID = 0
+			if data.DeviceID == "" {
+				data.DeviceID = req.DeviceID
+			}
+			if data.DeviceID == "" {
+				results[i].Status = "rejected"
+				results[i].Error = "missing deviceID"
+				continue
+			}
+			if caller != "" && data.DeviceID != caller {
+				results[i].Status = "rejected"
+				results[i].Error = "deviceID does not match the calling device"
+				continue
+			}
+			if data.Timestamp.After(now.Add(time.Minute)) {
+				results[i].Status = "rejected"
+				results[i].Error = "timestamp is in the future"
+				continue
+			}
+		}
+
+		var valid []*models.SensorData
+		var index []int
+		for i := range req.Readings {
+			if results[i].Status == "" {
+				valid = append(valid, &req.Readings[i])
+				index = append(index, i)
+			}
+		}
+		pipe.Prepare(valid...)
+		stored, err := readings.CreateNew(valid)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to save data",
+			})
+		}
+		for j, i := range index {
+			results[i].Status = "duplicate"
+			if stored[j] {
+				results[i].Status = "created"
+				results[i].Quality = req.Readings[i].Quality
+			}
+			results[i].ID = req.Readings[i].ID
+		}
+
+		// Run hooks in time order so alert state follows the readings' history.
+		created := make([]*models.SensorData, 0, len(req.Readings))
+		for i := range req.Readings {
+			if results[i].Status == "created" {
+				created = append(created, &req.Readings[i])
+			}
+		}
+		sort.SliceStable(created, func(i, j int) bool {
+			return created[i].Timestamp.Before(created[j].Timestamp)
+		})
+		for _, data := range created {
+			pipe.Stored(data)
+		}
+
+		deviceID := req.DeviceID
+		if deviceID == "" {
+			deviceID = req.Readings[0].DeviceID
+		}
+		resp := fiber.Map{"results": results}
+		if deviceID != "" {
+			resp["intervalSeconds"] = pipe.NextWait(deviceID)
+		}
+		return c.Status(fiber.StatusCreated).JSON(resp)
+	}
+}
@@ -1,15 +1,15 @@
 package handlers
 
 import (
-	"time"
-
+	"my-smart-farm/ingest"
+	"my-smart-farm/middleware"
 	"my-smart-farm/models"
+	"my-smart-farm/store"
 
 	"github.com/gofiber/fiber/v2"
-	"gorm.io/gorm"
 )
 
-func CreateSensorData(db *gorm.DB) fiber.Handler {
+func CreateSensorData(pipe *ingest.Pipeline) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var data models.SensorData
 
@@ -19,47 +19,38 @@ func CreateSensorData(db *gorm.DB) fiber.Handler {
 			})
 		}
 
-		if data.Timestamp.IsZero() {
-			data.Timestamp = time.Now()
+		if caller := middleware.CallerDeviceID(c); caller != "" && data.DeviceID != caller {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "deviceID does not match the calling device",
+			})
 		}
 
-		if err := db.Create(&data).Error; err != nil {
+		if err := pipe.Save(&data); err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to save data",
 			})
 		}
 
-		// Default fallback interval
-		interval := 60
-
-		var setting models.IntervalSetting
-		err := db.First(&setting, "device_id = ?", data.DeviceID).Error
-		if err == nil {
-			interval = setting.IntervalSeconds
-		} else if err == gorm.ErrRecordNotFound {
-			setting = models.IntervalSetting{
-				DeviceID:        data.DeviceID,
-				IntervalSeconds: interval,
-			}
-			db.Create(&setting)
-		}
-
-		// Align interval: compute time until next aligned slot
-		now := time.Now()
-		elapsed := now.Unix() % int64(interval)
-		wait := interval - int(elapsed) // seconds until next aligned time
-
 		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-			"intervalSeconds": wait,
+			"intervalSeconds": pipe.NextWait(data.DeviceID),
+			"quality":         data.Quality,
 		})
 	}
 }
 
-// Handler to list sensor data
-func GetAllSensorData(db *gorm.DB) fiber.Handler {
+// Handler to list sensor data. Supports from, to, limit, order, cursor and
+// device_id query params; see parseReadingQuery.
+func GetAllSensorData(readings store.Readings) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		var allData []models.SensorData
-		if err := db.Find(&allData).Error; err != nil {
+		q, err := parseReadingQuery(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		allData, err := findReadings(c, readings, q)
+		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve data",
 			})
@@ -68,13 +59,21 @@ func GetAllSensorData(db *gorm.DB) fiber.Handler {
 	}
 }
 
-// Handler to fetch data by device ID (optional extra)
-func GetSensorDataByDeviceID(db *gorm.DB) fiber.Handler {
+// Handler to fetch data by device ID (optional extra). Accepts the same
+// query params as GetAllSensorData.
+func GetSensorDataByDeviceID(readings store.Readings) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		deviceID := c.Params("deviceID") // e.g., /api/v1/data/device/<deviceID>
 
-		var deviceData []models.SensorData
-		if err := db.Where("device_id = ?", deviceID).Find(&deviceData).Error; err != nil {
+		q, err := parseReadingQuery(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		deviceData, err := findReadings(c, readings, q)
+		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to retrieve data for device " + deviceID,
 			})
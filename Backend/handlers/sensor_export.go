@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"my-smart-farm/derived"
+	"my-smart-farm/models"
+	"my-smart-farm/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// excelTimeLayout is a timestamp format spreadsheets recognise as a date.
+const excelTimeLayout = "2006-01-02 15:04:05"
+
+// readingIter calls fn for each exported reading in order.
+type readingIter func(fn func(*models.SensorData) error) error
+
+var exportHeader = []string{"id", "device_id", "timestamp", "temperature", "humidity", "soil",
+	"vpd_kpa", "dew_point_c", "absolute_humidity_gm3", "heat_index_c"}
+
+// GET /api/v1/data/export?format=csv|ndjson|excel&device_id=&from=&to=
+//
+// Streams matching readings with their derived metrics in timestamp order
+// straight from the store's cursor, so exports of any size use constant
+// memory. The excel format is CSV with a UTF-8 BOM, CRLF line endings and
+// plain local timestamps.
+func ExportSensorData(readings store.Readings) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		q, err := parseReadingQuery(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		format := c.Query("format", "csv")
+		var write func(w *bufio.Writer, rows readingIter) error
+		switch format {
+		case "csv":
+			c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+			write = func(w *bufio.Writer, rows readingIter) error {
+				return writeCSV(w, rows, false)
+			}
+		case "excel":
+			c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+			write = func(w *bufio.Writer, rows readingIter) error {
+				return writeCSV(w, rows, true)
+			}
+		case "ndjson":
+			c.Set(fiber.HeaderContentType, "application/x-ndjson")
+			write = writeNDJSON
+		default:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "format must be csv, ndjson or excel",
+			})
+		}
+
+		ext := "csv"
+		if format == "ndjson" {
+			ext = "ndjson"
+		}
+		c.Attachment("sensor-data-" + time.Now().Format("20060102-150405") + "." + ext)
+
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			var rows readingIter = func(fn func(*models.SensorData) error) error {
+				return readings.Each(q.ReadingFilter, fn)
+			}
+			// Headers are already sent, so a failure can only cut the body short.
+			if err := write(w, rows); err != nil {
+				log.Println("export aborted:", err)
+			}
+			w.Flush()
+		})
+		return nil
+	}
+}
+
+func writeCSV(w *bufio.Writer, rows readingIter, excel bool) error {
+	if excel {
+		w.WriteString("\ufeff") // UTF-8 BOM
+	}
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = excel
+	if err := cw.Write(exportHeader); err != nil {
+		return err
+	}
+	record := make([]string, len(exportHeader))
+	err := rows(func(d *models.SensorData) error {
+		ts := d.Timestamp.Format(time.RFC3339)
+		if excel {
+			ts = d.Timestamp.Local().Format(excelTimeLayout)
+		}
+		record[0] = strconv.FormatUint(uint64(d.ID), 10)
+		record[1] = d.DeviceID
+		record[2] = ts
+		record[3] = strconv.FormatFloat(d.Temperature, 'f', -1, 64)
+		record[4] = strconv.FormatFloat(d.Humidity, 'f', -1, 64)
+		record[5] = strconv.FormatFloat(d.Soil, 'f', -1, 64)
+		clear(record[6:])
+		if v, ok := derived.Compute(d.Temperature, d.Humidity); ok {
+			record[6] = strconv.FormatFloat(v.VPD, 'f', -1, 64)
+			record[7] = strconv.FormatFloat(v.DewPoint, 'f', -1, 64)
+			record[8] = strconv.FormatFloat(v.AbsoluteHumidity, 'f', -1, 64)
+			record[9] = strconv.FormatFloat(v.HeatIndex, 'f', -1, 64)
+		}
+		return cw.Write(record)
+	})
+	cw.Flush()
+	if err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+func writeNDJSON(w *bufio.Writer, rows readingIter) error {
+	enc := json.NewEncoder(w)
+	return rows(func(d *models.SensorData) error {
+		return enc.Encode(derived.Of(d))
+	})
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"my-smart-farm/derived"
+	"my-smart-farm/models"
+	"my-smart-farm/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultQueryLimit = 1000
+	maxQueryLimit     = 10000
+)
+
+// encodeCursor turns the last row of a page into an opaque cursor.
+func encodeCursor(rc store.Cursor) string {
+	raw := strconv.FormatInt(rc.Timestamp.UnixNano(), 10) + "." + strconv.FormatUint(uint64(rc.ID), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (*store.Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	ts, id, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return nil, errors.New("invalid cursor")
+	}
+	nanos, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	rowID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &store.Cursor{Timestamp: time.Unix(0, nanos), ID: uint(rowID)}, nil
+}
+
+// parseTimeParam accepts RFC3339 timestamps or unix seconds. The result is
+// converted to local time because SQLite compares stored timestamps as text.
+func parseTimeParam(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t.Local(), err
+}
+
+// parseReadingQuery reads from, to, limit, order, quality and cursor from
+// the query string. Device filtering comes from the deviceID route param or
+// the device_id query param, which may list several comma-separated IDs.
+func parseReadingQuery(c *fiber.Ctx) (store.ReadingQuery, error) {
+	q := store.ReadingQuery{
+		Limit: defaultQueryLimit,
+	}
+	if id := c.Params("deviceID"); id != "" {
+		q.DeviceIDs = []string{id}
+	} else if ids := c.Query("device_id"); ids != "" {
+		for _, id := range strings.Split(ids, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				q.DeviceIDs = append(q.DeviceIDs, id)
+			}
+		}
+	}
+
+	var err error
+	if q.From, err = parseTimeParam(c.Query("from")); err != nil {
+		return q, errors.New("invalid from: use RFC3339 or unix seconds")
+	}
+	if q.To, err = parseTimeParam(c.Query("to")); err != nil {
+		return q, errors.New("invalid to: use RFC3339 or unix seconds")
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && q.To.Before(q.From) {
+		return q, errors.New("to must not be before from")
+	}
+
+	if v := c.Query("limit"); v != "" {
+		q.Limit, err = strconv.Atoi(v)
+		if err != nil || q.Limit <= 0 {
+			return q, errors.New("invalid limit")
+		}
+		if q.Limit > maxQueryLimit {
+			q.Limit = maxQueryLimit
+		}
+	}
+
+	switch c.Query("order", "asc") {
+	case "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return q, errors.New("order must be asc or desc")
+	}
+
+	// Rejected readings are hidden unless asked for.
+	if v := c.Query("quality", models.QualityOK+","+models.QualitySuspect); v != "all" {
+		for _, grade := range strings.Split(v, ",") {
+			grade = strings.TrimSpace(grade)
+			if grade != models.QualityOK && grade != models.QualitySuspect && grade != models.QualityRejected {
+				return q, errors.New("quality must list ok, suspect or rejected, or be all")
+			}
+			q.Quality = append(q.Quality, grade)
+		}
+	}
+
+	if v := c.Query("cursor"); v != "" {
+		if q.After, err = decodeCursor(v); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+// findReadings runs a paged query, adds the derived metrics and sets
+// X-Next-Cursor when more rows may follow the returned page.
+func findReadings(c *fiber.Ctx, readings store.Readings, q store.ReadingQuery) ([]derived.Reading, error) {
+	rows, err := readings.Find(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == q.Limit {
+		last := rows[len(rows)-1]
+		c.Set("X-Next-Cursor", encodeCursor(store.Cursor{Timestamp: last.Timestamp, ID: last.ID}))
+	}
+	out := make([]derived.Reading, len(rows))
+	for i := range rows {
+		out[i] = derived.Of(&rows[i])
+	}
+	return out, nil
+}
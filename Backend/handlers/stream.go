@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"my-smart-farm/middleware"
+	"my-smart-farm/stream"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// streamKeepAlive is how often an idle stream sends a keep-alive so proxies
+// and browsers do not drop the connection.
+const streamKeepAlive = 20 * time.Second
+
+// parseStreamFilter reads the comma-separated device_id and types query
+// parameters.
+func parseStreamFilter(query func(key string, def ...string) string) (stream.Filter, error) {
+	f := stream.Filter{
+		DeviceIDs: splitSet(query("device_id")),
+		Types:     splitSet(query("types")),
+	}
+	for t := range f.Types {
+		switch t {
+		case stream.TypeReading, stream.TypeRelayState, stream.TypeRelayStatus, stream.TypeAlert:
+		default:
+			return f, fmt.Errorf("unknown event type %q", t)
+		}
+	}
+	return f, nil
+}
+
+func splitSet(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			set[v] = true
+		}
+	}
+	return set
+}
+
+// POST /api/v1/stream/ticket returns a single-use ticket, valid for a few
+// seconds, to open a stream with ?ticket= where headers cannot be set.
+func StreamTicket(tickets *middleware.StreamTickets) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ticket, expiresAt, err := tickets.Issue(middleware.CurrentUser(c).ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to issue ticket",
+			})
+		}
+		return c.JSON(fiber.Map{"ticket": ticket, "expires_at": expiresAt})
+	}
+}
+
+// GET /api/v1/stream?device_id=&types= pushes live events as Server-Sent
+// Events. Each event's name is its type and its data the JSON event.
+func StreamEvents(broker *stream.Broker) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		filter, err := parseStreamFilter(c.Query)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		c.Set(fiber.HeaderContentType, "text/event-stream")
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		c.Set(fiber.HeaderConnection, "keep-alive")
+		c.Set("X-Accel-Buffering", "no")
+
+		c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+			sub := broker.Subscribe(filter)
+			defer sub.Close()
+
+			// An initial comment flushes the headers right away.
+			w.WriteString(": connected\n\n")
+			if w.Flush() != nil {
+				return
+			}
+			keepAlive := time.NewTicker(streamKeepAlive)
+			defer keepAlive.Stop()
+			for {
+				select {
+				case ev := <-sub.C:
+					body, err := json.Marshal(ev)
+					if err != nil {
+						continue
+					}
+					fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, body)
+				case <-keepAlive.C:
+					w.WriteString(": keep-alive\n\n")
+				}
+				// A failed flush means the client went away.
+				if w.Flush() != nil {
+					return
+				}
+			}
+		}))
+		return nil
+	}
+}
+
+// RequireWebSocket rejects plain HTTP requests to WebSocket routes.
+func RequireWebSocket(c *fiber.Ctx) error {
+	if websocket.IsWebSocketUpgrade(c) {
+		return c.Next()
+	}
+	return fiber.ErrUpgradeRequired
+}
+
+// GET /api/v1/stream/ws?device_id=&types= pushes the same events as
+// StreamEvents as JSON text messages over a WebSocket.
+func StreamEventsWS(broker *stream.Broker) fiber.Handler {
+	return websocket.New(func(conn *websocket.Conn) {
+		filter, err := parseStreamFilter(conn.Query)
+		if err != nil {
+			conn.WriteMessage(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseUnsupportedData, err.Error()))
+			return
+		}
+		sub := broker.Subscribe(filter)
+		defer sub.Close()
+
+		// The client sends nothing; reading only notices when it leaves.
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		keepAlive := time.NewTicker(streamKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			var err error
+			select {
+			case <-closed:
+				return
+			case ev := <-sub.C:
+				err = conn.WriteJSON(ev)
+			case <-keepAlive.C:
+				err = conn.WriteMessage(websocket.PingMessage, nil)
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+}
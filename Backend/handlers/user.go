@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"my-smart-farm/middleware"
+	"my-smart-farm/models"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+type userInput struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// GET /api/v1/users
+func GetAllUsers(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var users []models.User
+		if err := db.Order("username").Find(&users).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch users",
+			})
+		}
+		return c.JSON(users)
+	}
+}
+
+// POST /api/v1/users
+func CreateUser(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input userInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid input",
+			})
+		}
+		if input.Username == "" || len(input.Password) < 8 || models.RoleRank(input.Role) == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Username, password (8+ chars) and role (viewer, operator or admin) are required",
+			})
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to hash password",
+			})
+		}
+		user := models.User{
+			Username:     input.Username,
+			PasswordHash: string(hash),
+			Role:         input.Role,
+		}
+		if err := db.Create(&user).Error; err != nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Failed to create user; the username may be taken",
+			})
+		}
+		return c.Status(fiber.StatusCreated).JSON(user)
+	}
+}
+
+// PUT /api/v1/users/:id changes the role and/or password of a user.
+func UpdateUser(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid user id",
+			})
+		}
+		var input userInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid input",
+			})
+		}
+
+		var user models.User
+		if err := db.First(&user, id).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+
+		if input.Role != "" {
+			if models.RoleRank(input.Role) == 0 {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid role",
+				})
+			}
+			user.Role = input.Role
+		}
+		if input.Password != "" {
+			if len(input.Password) < 8 {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Password must be at least 8 characters",
+				})
+			}
+			hash, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to hash password",
+				})
+			}
+			user.PasswordHash = string(hash)
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Save(&user).Error; err != nil {
+				return err
+			}
+			// Force a new login so the changed role or password takes effect.
+			return tx.Delete(&models.Session{}, "user_id = ?", user.ID).Error
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update user",
+			})
+		}
+		return c.JSON(user)
+	}
+}
+
+// DELETE /api/v1/users/:id
+func DeleteUser(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid user id",
+			})
+		}
+		if me := middleware.CurrentUser(c); me != nil && uint64(me.ID) == id {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Cannot delete your own account",
+			})
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			res := tx.Delete(&models.User{}, id)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			return tx.Delete(&models.Session{}, "user_id = ?", id).Error
+		})
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete user",
+			})
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
@@ -0,0 +1,153 @@
+// Package ingest stores incoming sensor readings and notifies the
+// subsystems that react to them, such as alert evaluation. Readings are
+// graded first; rejected ones are kept for the record but not acted on.
+package ingest
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"my-smart-farm/calibration"
+	"my-smart-farm/models"
+	"my-smart-farm/quality"
+	"my-smart-farm/store"
+)
+
+// Hook is called after a reading has been stored.
+type Hook func(data *models.SensorData)
+
+// Pipeline is the single path by which readings enter the database.
+type Pipeline struct {
+	store *store.Store
+	// Rules, when set, grade readings before they are stored. Without
+	// rules every reading is ok.
+	Rules *quality.Rules
+	// DefaultInterval is the send interval in seconds given to sensors
+	// that have no interval setting yet.
+	DefaultInterval int
+
+	mu    sync.RWMutex
+	hooks []Hook
+}
+
+func NewPipeline(s *store.Store) *Pipeline {
+	return &Pipeline{store: s, DefaultInterval: 60}
+}
+
+// AddHook registers h to run after every stored reading.
+func (p *Pipeline) AddHook(h Hook) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hooks = append(p.hooks, h)
+}
+
+// Save prepares and stores a single reading and runs the hooks.
+func (p *Pipeline) Save(data *models.SensorData) error {
+	p.Prepare(data)
+	if err := p.store.Readings.Create(data); err != nil {
+		return err
+	}
+	p.Stored(data)
+	return nil
+}
+
+// Stored marks the sensor as seen and runs the hooks for a reading that the
+// caller already prepared and inserted, e.g. as part of a batch transaction.
+// Rejected readings do not reach the hooks.
+func (p *Pipeline) Stored(data *models.SensorData) {
+	if err := p.store.Devices.Touch(data.DeviceID, models.DeviceKindSensor, time.Now()); err != nil {
+		log.Println("ingest: touch device:", err)
+	}
+	if data.Quality == models.QualityRejected {
+		return
+	}
+	p.mu.RLock()
+	hooks := p.hooks
+	p.mu.RUnlock()
+	for _, h := range hooks {
+		h(data)
+	}
+}
+
+// Prepare normalises the timestamps of readings about to be stored, then
+// computes calibrated soil moisture and grades them. A calibration lookup
+// failure keeps the firmware's moisture.
+func (p *Pipeline) Prepare(readings ...*models.SensorData) {
+	now := time.Now()
+	for _, data := range readings {
+		if data.Timestamp.IsZero() {
+			data.Timestamp = now
+		}
+		// Every path stores local time: SQLite compares timestamps as
+		// text, and queries and daily buckets use local time too.
+		data.Timestamp = data.Timestamp.Local()
+	}
+	if err := calibration.Apply(p.store.Calibrations, readings...); err != nil {
+		log.Println("ingest: calibrate:", err)
+	}
+	p.Grade(readings...)
+}
+
+// Grade sets the quality of readings about to be stored. Each reading is
+// compared with the device's previous accepted one, taking the readings in
+// time order so a batch is judged like the same readings sent one by one.
+func (p *Pipeline) Grade(readings ...*models.SensorData) {
+	sorted := append([]*models.SensorData(nil), readings...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+	prev := map[string]*models.SensorData{}
+	for _, data := range sorted {
+		data.Quality, data.QualityNote = models.QualityOK, ""
+		if p.Rules == nil {
+			continue
+		}
+		last, ok := prev[data.DeviceID]
+		if !ok {
+			last = p.previous(data)
+		}
+		data.Quality, data.QualityNote = p.Rules.Grade(data, last)
+		if data.Quality != models.QualityRejected {
+			prev[data.DeviceID] = data
+		}
+	}
+}
+
+// previous returns the device's last accepted reading before data, or nil.
+func (p *Pipeline) previous(data *models.SensorData) *models.SensorData {
+	rows, err := p.store.Readings.Find(store.ReadingQuery{
+		ReadingFilter: store.ReadingFilter{
+			DeviceIDs: []string{data.DeviceID},
+			To:        data.Timestamp,
+			Quality:   []string{models.QualityOK, models.QualitySuspect},
+		},
+		Limit: 1,
+		Desc:  true,
+	})
+	if err != nil {
+		log.Println("ingest: previous reading:", err)
+		return nil
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return &rows[0]
+}
+
+// NextWait returns the seconds until the device's next aligned send slot,
+// giving devices seen for the first time the default interval.
+func (p *Pipeline) NextWait(deviceID string) int {
+	interval := p.DefaultInterval
+	setting, err := p.store.Intervals.GetOrCreate(deviceID, interval)
+	if err != nil {
+		log.Println("ingest: interval setting:", err)
+	} else {
+		interval = setting.IntervalSeconds
+	}
+
+	// Align interval: compute time until next aligned slot
+	elapsed := time.Now().Unix() % int64(interval)
+	return interval - int(elapsed) // seconds until next aligned time
+}
@@ -1,49 +1,350 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"log"
+	"net"
+	"os"
+	"strings"
+	_ "time/tzdata" // schedule time zones must resolve without system zoneinfo
 
+	"my-smart-farm/alerts"
+	"my-smart-farm/anomaly"
+	"my-smart-farm/automation"
+	"my-smart-farm/climate"
+	"my-smart-farm/config"
 	"my-smart-farm/database"
 	"my-smart-farm/handlers"
+	"my-smart-farm/ingest"
+	"my-smart-farm/metrics"
+	"my-smart-farm/middleware"
+	"my-smart-farm/models"
+	"my-smart-farm/mqttbridge"
+	"my-smart-farm/notify"
+	"my-smart-farm/relay"
+	"my-smart-farm/retention"
+	"my-smart-farm/schedule"
+	"my-smart-farm/store"
+	"my-smart-farm/stream"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"gorm.io/gorm"
 )
 
-func setupRoutes(app *fiber.App, db *gorm.DB) {
+// services bundles the long-lived subsystems the routes depend on.
+type services struct {
+	store     *store.Store
+	retention *retention.Job
+	ingest    *ingest.Pipeline
+	alerts    *alerts.Evaluator
+	anomalies *anomaly.Detector
+	climate   *climate.Job
+	notify    *notify.Dispatcher
+	relays    *relay.Client
+	commands  *relay.Queue
+	liveness  *relay.Monitor
+	stream    *stream.Broker
+	automate  *automation.Engine
+	schedules *schedule.Scheduler
+	mqtt      *mqttbridge.Bridge
+	metrics   *metrics.Registry
+}
+
+func setupRoutes(app *fiber.App, db *gorm.DB, cfg *config.Config, svc *services) {
+	// GET /metrics -> Prometheus metrics for farm dashboards
+	if cfg.Metrics.Enabled {
+		app.Get("/metrics", handlers.Metrics(svc.metrics, cfg.Metrics.Token))
+	}
+
 	api := app.Group("/api/v1")
 
+	// Device-originated requests must be HMAC-signed with the device secret
+	signed := middleware.DeviceSignature(db, middleware.SignatureConfig{
+		AllowUnsigned: cfg.Devices.AllowUnsigned,
+	})
+
+	// Dashboard requests need a login session with a sufficient role:
+	// viewers read, operators switch relays and intervals, admins manage.
+	viewer := middleware.RequireRole(db, models.RoleViewer)
+	operator := middleware.RequireRole(db, models.RoleOperator)
+	adminOnly := middleware.RequireRole(db, models.RoleAdmin)
+
+	api.Post("/auth/login", handlers.Login(db))
+	api.Post("/auth/logout", handlers.Logout(db))
+	api.Get("/auth/me", viewer, handlers.Me())
+
 	// POST /api/v1/data -> Create new sensor record
-	api.Post("/data", handlers.CreateSensorData(db))
+	api.Post("/data", svc.metrics.Requests(svc.metrics.IngestRequests, "/data"),
+		signed, handlers.CreateSensorData(svc.ingest))
+
+	// POST /api/v1/data/batch -> Create buffered records in one transaction
+	api.Post("/data/batch", svc.metrics.Requests(svc.metrics.IngestRequests, "/data/batch"),
+		signed, handlers.CreateSensorDataBatch(svc.store.Readings, svc.ingest))
 
-	// GET /api/v1/data -> Retrieve all sensor records
-	api.Get("/data", handlers.GetAllSensorData(db))
+	// GET /api/v1/data -> Retrieve sensor records (from, to, limit, order, cursor)
+	api.Get("/data", viewer, handlers.GetAllSensorData(svc.store.Readings))
 
 	// GET /api/v1/data/device/:deviceID -> Retrieve data by device
-	api.Get("/data/device/:deviceID", handlers.GetSensorDataByDeviceID(db))
+	api.Get("/data/device/:deviceID", viewer, handlers.GetSensorDataByDeviceID(svc.store.Readings))
+
+	// GET /api/v1/data/aggregate -> min/max/avg/count per time bucket
+	api.Get("/data/aggregate", viewer, handlers.GetAggregatedSensorData(svc.store.Readings))
+
+	// GET /api/v1/data/export -> Stream readings as CSV, NDJSON or Excel-friendly CSV
+	api.Get("/data/export", viewer, handlers.ExportSensorData(svc.store.Readings))
+
+	// GET /api/v1/stream -> live readings, relay changes and alerts (SSE or WebSocket).
+	// Browsers open them with a ticket from POST /api/v1/stream/ticket.
+	tickets := middleware.NewStreamTickets()
+	streamViewer := tickets.RequireRole(db, models.RoleViewer)
+	api.Post("/stream/ticket", viewer, handlers.StreamTicket(tickets))
+	api.Get("/stream", streamViewer, handlers.StreamEvents(svc.stream))
+	api.Get("/stream/ws", streamViewer, handlers.RequireWebSocket, handlers.StreamEventsWS(svc.stream))
+
+	// GET /api/v1/data/rollups -> hourly or daily rollups of compacted readings
+	api.Get("/data/rollups", viewer, handlers.GetRollups(db))
+
+	// GET /api/v1/climate/daily -> daily min/max/mean climate and growing degree days
+	api.Get("/climate/daily", viewer, handlers.GetDailyClimate(db))
+
+	api.Post("/interval", operator, handlers.SetInterval(svc.store.Intervals))
+	api.Get("/intervals", viewer, handlers.GetAllIntervals(svc.store.Intervals))
+	api.Post("/relay/register", signed, handlers.RegisterRelayIP(svc.store.Relays, svc.store.Devices))
+	api.Post("/relay/heartbeat", signed, handlers.RelayHeartbeat(svc.store.Relays, svc.store.Devices))
+	api.Get("/relay/:deviceID", viewer, handlers.GetRelayIP(svc.store.Relays))
+	api.Get("/relays", viewer, handlers.GetAllRelays(svc.store.Relays, svc.liveness))
+	api.Get("/relay-commands", viewer, handlers.GetRelayCommands(db))
+	api.Get("/relay-commands/:id", viewer, handlers.GetRelayCommand(db))
+	api.Post("/relay/:deviceID/:action", svc.metrics.Requests(svc.metrics.RelayProxy),
+		operator, handlers.ProxyRelayCommand(svc.commands))
+
+	api.Get("/devices", viewer, handlers.GetAllDevices(db))
+	api.Post("/devices", adminOnly, handlers.CreateDevice(db, cfg.Devices.DefaultIntervalSeconds))
+	api.Get("/devices/:deviceID", viewer, handlers.GetDevice(db))
+	api.Put("/devices/:deviceID", adminOnly, handlers.UpdateDevice(db))
+	api.Delete("/devices/:deviceID", adminOnly, handlers.DeleteDevice(db))
+	api.Post("/devices/:deviceID/secret", adminOnly, handlers.RotateDeviceSecret(db))
+	api.Post("/devices/:deviceID/mqtt-password", adminOnly, handlers.RotateDeviceMQTTPassword(db))
+	api.Get("/devices/:deviceID/calibration", viewer, handlers.GetCalibration(svc.store.Calibrations))
+	api.Put("/devices/:deviceID/calibration", operator, handlers.SetCalibration(svc.store.Calibrations))
+	api.Delete("/devices/:deviceID/calibration", operator, handlers.DeleteCalibration(svc.store.Calibrations))
+	api.Post("/devices/:deviceID/calibration/recompute", operator, handlers.RecomputeCalibration(svc.store, svc.ingest.Rules))
+	api.Get("/devices/:deviceID/planting", viewer, handlers.GetPlanting(db))
+	api.Put("/devices/:deviceID/planting", operator, handlers.SetPlanting(db, svc.climate))
+	api.Delete("/devices/:deviceID/planting", operator, handlers.DeletePlanting(db, svc.climate))
+
+	api.Get("/automations", viewer, handlers.GetAutomations(db))
+	api.Post("/automations", operator, handlers.CreateAutomation(db))
+	api.Put("/automations/:id", operator, handlers.UpdateAutomation(svc.automate))
+	api.Delete("/automations/:id", operator, handlers.DeleteAutomation(svc.automate))
+
+	api.Get("/schedules", viewer, handlers.GetSchedules(db))
+	api.Get("/schedules/upcoming", viewer, handlers.GetUpcomingRuns(db, svc.schedules))
+	api.Post("/schedules", operator, handlers.CreateSchedule(db))
+	api.Put("/schedules/:id", operator, handlers.UpdateSchedule(db, svc.schedules))
+	api.Delete("/schedules/:id", operator, handlers.DeleteSchedule(db, svc.schedules))
+
+	api.Get("/alerts", viewer, handlers.GetAlerts(db))
+	api.Get("/anomalies", viewer, handlers.GetAnomalies(db))
+	api.Post("/anomalies/scan", operator, handlers.ScanAnomalies(svc.anomalies))
+	api.Get("/alert-rules", viewer, handlers.GetAlertRules(db))
+	api.Post("/alert-rules", operator, handlers.CreateAlertRule(db))
+	api.Put("/alert-rules/:id", operator, handlers.UpdateAlertRule(db))
+	api.Delete("/alert-rules/:id", operator, handlers.DeleteAlertRule(db))
+
+	api.Get("/notification-channels", adminOnly, handlers.GetNotificationChannels(db))
+	api.Post("/notification-channels", adminOnly, handlers.CreateNotificationChannel(db))
+	api.Put("/notification-channels/:id", adminOnly, handlers.UpdateNotificationChannel(db))
+	api.Delete("/notification-channels/:id", adminOnly, handlers.DeleteNotificationChannel(db))
+	api.Post("/notification-channels/:id/test", adminOnly, handlers.TestNotificationChannel(db, svc.notify))
+
+	api.Get("/users", adminOnly, handlers.GetAllUsers(db))
+	api.Post("/users", adminOnly, handlers.CreateUser(db))
+	api.Put("/users/:id", adminOnly, handlers.UpdateUser(db))
+	api.Delete("/users/:id", adminOnly, handlers.DeleteUser(db))
 
-	api.Post("/interval", handlers.SetInterval(db))
-	api.Get("/intervals", handlers.GetAllIntervals(db))
-	api.Post("/relay/register", handlers.RegisterRelayIP(db))
-	api.Get("/relay/:deviceID", handlers.GetRelayIP(db))
-	api.Get("/relays", handlers.GetAllRelays(db))
-	api.Post("/relay/:deviceID/:action", handlers.ProxyRelayCommand(db))
+	admin := api.Group("/admin", adminOnly)
+	admin.Get("/retention", handlers.GetRetention(db, svc.retention))
+	admin.Post("/retention/run", handlers.RunRetention(svc.retention))
+	admin.Put("/retention/:deviceID", handlers.SetRetention(db))
+	admin.Delete("/retention/:deviceID", handlers.DeleteRetention(db))
+	admin.Post("/climate/run", handlers.RunClimate(svc.climate))
 
 }
 
 func main() {
+	cfg, opts, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal("Invalid configuration:\n", err)
+	}
+	if opts.PrintOnly {
+		cfg.Write(os.Stdout)
+		return
+	}
+	var effective strings.Builder
+	cfg.Write(&effective)
+	log.Printf("Effective configuration (file %q):\n%s", opts.File, effective.String())
+
 	// Initialize the DB
-	database.InitDB()
+	database.InitDB(cfg.Database.Driver, cfg.Database.Source())
 	db := database.DB
 
 	// Initialize Fiber
 	app := fiber.New()
-	app.Use(cors.New())
+	// Without listed origins only same-origin requests are allowed
+	if len(cfg.CORS.AllowOrigins) > 0 {
+		app.Use(cors.New(cors.Config{
+			AllowOrigins:  strings.Join(cfg.CORS.AllowOrigins, ","),
+			ExposeHeaders: "X-Next-Cursor",
+		}))
+	}
+
+	sqlStore := store.NewSQL(db)
+	svc := &services{
+		store:     sqlStore,
+		metrics:   metrics.NewRegistry(db, sqlStore.Readings),
+		retention: retention.NewJob(db, cfg.Retention.Raw),
+		alerts:    alerts.NewEvaluator(db),
+		notify:    notify.NewDispatcher(db),
+		relays:    relay.NewClient(db),
+		stream:    stream.NewBroker(),
+	}
+	svc.ingest = ingest.NewPipeline(svc.store)
+	svc.ingest.DefaultInterval = cfg.Devices.DefaultIntervalSeconds
+	if cfg.Quality.Enabled {
+		svc.ingest.Rules = &cfg.Quality.Rules
+	}
+	svc.anomalies = anomaly.NewDetector(db, svc.store.Readings)
+	svc.anomalies.Config = cfg.Anomaly.Config
+	svc.anomalies.Alerts = svc.alerts
+	svc.commands = relay.NewQueue(db, svc.relays)
+	svc.retention.Interval = cfg.Retention.Interval
+	svc.climate = climate.NewJob(db, svc.store.Readings)
+	svc.climate.BaseTemperature = cfg.Climate.BaseTemperature
+	svc.liveness = relay.NewMonitor(db)
+	svc.liveness.StaleAfter = cfg.Relays.StaleAfter
+	svc.liveness.OfflineAfter = cfg.Relays.OfflineAfter
+	svc.metrics.Monitor = svc.liveness
+	svc.liveness.Alerts = svc.alerts
+	svc.automate = automation.NewEngine(db, svc.commands)
+	svc.automate.Monitor = svc.liveness
+	svc.schedules = schedule.NewScheduler(db, svc.relays)
+	svc.schedules.Monitor = svc.liveness
+
+	// Count failed queries and relay delivery outcomes for /metrics and
+	// keep the latest readings so scrapes do not query them
+	if err := svc.metrics.InstrumentDB(db); err != nil {
+		log.Fatal("Failed to instrument database:", err)
+	}
+	svc.relays.OnAttempt(svc.metrics.RelayHook)
+	svc.ingest.AddHook(svc.metrics.Hook)
+
+	// Compact old readings into rollups in the background
+	svc.retention.Start(context.Background())
+
+	// Summarise each finished day's climate and growing degree days
+	svc.climate.Start(context.Background())
+
+	// Evaluate alert rules on every stored reading and notify on changes
+	svc.ingest.AddHook(svc.alerts.Hook)
+	svc.alerts.OnTransition(svc.notify.AlertHook)
+	svc.notify.Start(context.Background())
+
+	// Annotate frozen, jumping and outlying readings; alert on frozen probes
+	if cfg.Anomaly.Enabled {
+		svc.ingest.AddHook(svc.anomalies.Hook)
+	}
+
+	// Deliver queued dashboard and automation relay commands with retries
+	svc.commands.Start(context.Background())
+
+	// Track relay heartbeats; automations skip relays that went offline
+	svc.liveness.Start(context.Background())
+
+	// Drive irrigation relays from soil readings
+	svc.ingest.AddHook(svc.automate.Hook)
+	svc.automate.Start(context.Background())
+
+	// Run relay schedules, catching up on runs still open after a restart
+	svc.schedules.Start(context.Background())
+
+	// Push readings, relay changes and alert transitions to live dashboards
+	svc.ingest.AddHook(svc.stream.ReadingHook)
+	svc.alerts.OnTransition(svc.stream.AlertHook)
+	svc.relays.OnStateChange(svc.stream.RelayStateHook)
+	svc.liveness.OnChange(svc.stream.RelayStatusHook)
+
+	// Ingest telemetry and reach IP-less relays over MQTT
+	if cfg.MQTT.Enabled {
+		svc.mqtt = startMQTT(db, cfg, svc)
+	}
 
 	// Set up API routes
-	setupRoutes(app, db)
+	setupRoutes(app, db, cfg, svc)
+
+	log.Fatal(app.Listen(cfg.Server.Listen))
+}
+
+// startMQTT connects the MQTT bridge to the configured external broker, or
+// runs an embedded broker that devices log in to with their device ID and
+// MQTT password, over TLS unless it only listens on loopback.
+func startMQTT(db *gorm.DB, cfg *config.Config, svc *services) *mqttbridge.Bridge {
+	bridgeCfg := mqttbridge.Config{
+		Broker:   cfg.MQTT.Broker,
+		Username: cfg.MQTT.Username,
+		Password: cfg.MQTT.Password,
+	}
+	if bridgeCfg.Broker == "" {
+		// The bridge logs in to the embedded broker with a throwaway account
+		// on a loopback listener of its own.
+		var raw [16]byte
+		if _, err := rand.Read(raw[:]); err != nil {
+			log.Fatal("Failed to generate MQTT credentials:", err)
+		}
+		bridgeCfg.Username = "bridge-" + hex.EncodeToString(raw[:4])
+		bridgeCfg.Password = hex.EncodeToString(raw[4:])
+
+		devices, err := net.Listen("tcp", cfg.MQTT.Listen)
+		if err != nil {
+			log.Fatal("Failed to start MQTT broker:", err)
+		}
+		if cfg.MQTT.TLSCert != "" {
+			cert, err := tls.LoadX509KeyPair(cfg.MQTT.TLSCert, cfg.MQTT.TLSKey)
+			if err != nil {
+				log.Fatal("Failed to load MQTT TLS certificate:", err)
+			}
+			devices = tls.NewListener(devices, &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				MinVersion:   tls.VersionTLS12,
+			})
+		}
+		internal, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			log.Fatal("Failed to start MQTT broker:", err)
+		}
+		bridgeCfg.Broker = internal.Addr().String()
+
+		broker := mqttbridge.NewBroker(mqttbridge.DeviceAuth(db, cfg.Devices.AllowUnsigned, bridgeCfg))
+		for _, ln := range []net.Listener{devices, internal} {
+			go func(ln net.Listener) {
+				if err := broker.Serve(ln); err != nil {
+					log.Println("mqtt broker:", err)
+				}
+			}(ln)
+		}
+	}
 
-	// Start server on localhost:3000
-	log.Fatal(app.Listen(":3000"))
+	bridge := mqttbridge.NewBridge(svc.ingest, svc.relays, bridgeCfg)
+	svc.relays.Publisher = bridge
+	bridge.Start(context.Background())
+	return bridge
 }
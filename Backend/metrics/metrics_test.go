@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"my-smart-farm/models"
+	"my-smart-farm/relay"
+	"my-smart-farm/store"
+	"my-smart-farm/store/storetest"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRegistry(t *testing.T) {
+	db := storetest.SQLite(t, &models.SensorData{}, &models.Device{}, &models.RelayDevice{})
+	now := time.Date(2025, 4, 4, 6, 0, 0, 0, time.Local)
+	seen := now.Add(-90 * time.Second)
+	db.Create(&models.SensorData{DeviceID: "sensor-001", Temperature: 29, Humidity: 70, Soil: 35, Timestamp: now.Add(-time.Hour)})
+	db.Create(&models.SensorData{DeviceID: "sensor-001", Temperature: 31.5, Humidity: 62, Soil: 33, Timestamp: seen})
+	db.Create(&models.Device{DeviceID: "sensor-001", Kind: models.DeviceKindSensor, LastSeen: &seen})
+	db.Create(&models.RelayDevice{DeviceID: "relay-001", State: relay.ActionOn, LastHeartbeat: &seen})
+
+	reg := NewRegistry(db, store.NewSQL(db).Readings)
+	reg.Monitor = relay.NewMonitor(db)
+	if err := reg.InstrumentDB(db); err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("SELECT * FROM missing_table")
+	reg.RelayHook(models.RelayCommand{StatusCode: 200})
+	reg.RelayHook(models.RelayCommand{Error: "dial tcp: timeout"})
+
+	app := fiber.New()
+	app.Post("/data", reg.Requests(reg.IngestRequests, "/data"), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusCreated)
+	})
+	app.Test(httptest.NewRequest("POST", "/data", nil))
+
+	var out strings.Builder
+	if err := reg.Write(&out, now); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# TYPE farm_temperature_celsius gauge\n",
+		`farm_temperature_celsius{device_id="sensor-001"} 31.5` + "\n",
+		`farm_soil_moisture_percent{device_id="sensor-001"} 33` + "\n",
+		`farm_device_last_seen_seconds{device_id="sensor-001",kind="sensor"} 90` + "\n",
+		`farm_relay_on{device_id="relay-001"} 1` + "\n",
+		`farm_relay_status{device_id="relay-001",status="online"} 1` + "\n",
+		`farm_ingest_requests_total{endpoint="/data",code="201"} 1` + "\n",
+		`farm_relay_attempts_total{code="200"} 1` + "\n",
+		`farm_relay_attempts_total{code="error"} 1` + "\n",
+		`farm_db_errors_total{operation="raw"} 1` + "\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %q in:\n%s", want, out.String())
+		}
+	}
+
+	// Later readings come from the ingest hook, not the database.
+	reg.Hook(&models.SensorData{DeviceID: "sensor-001", Temperature: 33, Quality: models.QualityOK, Timestamp: now})
+	reg.Hook(&models.SensorData{DeviceID: "sensor-001", Temperature: 99, Quality: models.QualityRejected, Timestamp: now.Add(time.Minute)})
+	reg.Hook(&models.SensorData{DeviceID: "sensor-001", Temperature: 20, Quality: models.QualityOK, Timestamp: now.Add(-time.Hour)})
+	out.Reset()
+	if err := reg.Write(&out, now); err != nil {
+		t.Fatal(err)
+	}
+	if want := `farm_temperature_celsius{device_id="sensor-001"} 33` + "\n"; !strings.Contains(out.String(), want) {
+		t.Errorf("missing %q in:\n%s", want, out.String())
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	c := NewCounterVec("x_total", "Help with \\ and\nnewline.", "id")
+	c.Inc(`a"b\c`)
+	var out strings.Builder
+	w := NewWriter(&out)
+	c.write(w)
+	w.Flush()
+	want := "# HELP x_total Help with \\\\ and\\nnewline.\n# TYPE x_total counter\nx_total{id=\"a\\\"b\\\\c\"} 1\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
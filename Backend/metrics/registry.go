@@ -0,0 +1,220 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"my-smart-farm/models"
+	"my-smart-farm/relay"
+	"my-smart-farm/store"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Registry holds the service counters and reads the farm gauges.
+type Registry struct {
+	db       *gorm.DB
+	readings store.Readings
+	// Monitor, when set, adds the liveness status of each relay.
+	Monitor *relay.Monitor
+
+	IngestRequests *CounterVec
+	RelayProxy     *CounterVec
+	RelayAttempts  *CounterVec
+	DBErrors       *CounterVec
+
+	mu     sync.Mutex
+	loaded bool
+	latest map[string]models.SensorData // by device ID
+}
+
+func NewRegistry(db *gorm.DB, readings store.Readings) *Registry {
+	return &Registry{
+		db:       db,
+		readings: readings,
+		IngestRequests: NewCounterVec("farm_ingest_requests_total",
+			"Sensor data ingestion requests by endpoint and HTTP status code.", "endpoint", "code"),
+		RelayProxy: NewCounterVec("farm_relay_proxy_requests_total",
+			"Dashboard relay command requests by HTTP status code.", "code"),
+		RelayAttempts: NewCounterVec("farm_relay_attempts_total",
+			"Relay command delivery attempts by the status code the relay answered, or error when unreachable.", "code"),
+		DBErrors: NewCounterVec("farm_db_errors_total",
+			"Failed database operations by kind.", "operation"),
+		latest: make(map[string]models.SensorData),
+	}
+}
+
+// Requests counts every request through the handler in vec, labelled with
+// labels followed by the response status code.
+func (r *Registry) Requests(vec *CounterVec, labels ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+		code := c.Response().StatusCode()
+		// An error returned to Fiber becomes the response later on.
+		var fe *fiber.Error
+		if errors.As(err, &fe) {
+			code = fe.Code
+		} else if err != nil {
+			code = fiber.StatusInternalServerError
+		}
+		vec.Inc(append(labels[:len(labels):len(labels)], strconv.Itoa(code))...)
+		return err
+	}
+}
+
+// RelayHook counts a relay delivery attempt.
+func (r *Registry) RelayHook(cmd models.RelayCommand) {
+	code := "error"
+	if cmd.StatusCode != 0 {
+		code = strconv.Itoa(cmd.StatusCode)
+	}
+	r.RelayAttempts.Inc(code)
+}
+
+// InstrumentDB counts failed operations on db. A missing record is an
+// answer, not a failure, so it is not counted.
+func (r *Registry) InstrumentDB(db *gorm.DB) error {
+	count := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+				r.DBErrors.Inc(operation)
+			}
+		}
+	}
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().After("gorm:create").Register("metrics:create", count("create")),
+		cb.Query().After("gorm:query").Register("metrics:query", count("query")),
+		cb.Update().After("gorm:update").Register("metrics:update", count("update")),
+		cb.Delete().After("gorm:delete").Register("metrics:delete", count("delete")),
+		cb.Row().After("gorm:row").Register("metrics:row", count("row")),
+		cb.Raw().After("gorm:raw").Register("metrics:raw", count("raw")),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Hook keeps the latest accepted reading of each device for the sensor
+// gauges, so scrapes do not query the readings table.
+func (r *Registry) Hook(data *models.SensorData) {
+	if data.Quality == models.QualityRejected {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keep(*data)
+}
+
+// keep stores d unless a newer reading of the device is kept; r.mu is held.
+func (r *Registry) keep(d models.SensorData) {
+	if cur, ok := r.latest[d.DeviceID]; ok && !d.Timestamp.After(cur.Timestamp) {
+		return
+	}
+	r.latest[d.DeviceID] = d
+}
+
+// latestReadings returns the kept readings by device ID. The first call
+// loads the latest reading of every device stored before startup.
+func (r *Registry) latestReadings() ([]models.SensorData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.loaded {
+		rows, err := r.readings.Latest()
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range rows {
+			r.keep(d)
+		}
+		r.loaded = true
+	}
+	out := make([]models.SensorData, 0, len(r.latest))
+	for _, d := range r.latest {
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DeviceID < out[j].DeviceID })
+	return out, nil
+}
+
+// Write writes all metrics. Device and relay gauges are read from the
+// database now; if that fails the counters are still written and the error
+// returned.
+func (r *Registry) Write(out io.Writer, now time.Time) error {
+	w := NewWriter(out)
+	gaugeErr := r.writeGauges(w, now)
+	for _, c := range []*CounterVec{r.IngestRequests, r.RelayProxy, r.RelayAttempts, r.DBErrors} {
+		c.write(w)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return gaugeErr
+}
+
+func (r *Registry) writeGauges(w *Writer, now time.Time) error {
+	latest, err := r.latestReadings()
+	if err != nil {
+		return err
+	}
+	device := []string{"device_id"}
+	for _, m := range []struct {
+		name, help string
+		value      func(*models.SensorData) float64
+	}{
+		{"farm_temperature_celsius", "Latest temperature reported by the sensor.", func(d *models.SensorData) float64 { return d.Temperature }},
+		{"farm_humidity_percent", "Latest relative humidity reported by the sensor.", func(d *models.SensorData) float64 { return d.Humidity }},
+		{"farm_soil_moisture_percent", "Latest soil moisture reported by the sensor.", func(d *models.SensorData) float64 { return d.Soil }},
+	} {
+		w.Header(m.name, "gauge", m.help)
+		for i := range latest {
+			w.Sample(m.name, device, []string{latest[i].DeviceID}, m.value(&latest[i]))
+		}
+	}
+
+	var devices []models.Device
+	if err := r.db.Where("last_seen IS NOT NULL").Order("device_id").Find(&devices).Error; err != nil {
+		return err
+	}
+	w.Header("farm_device_last_seen_seconds", "gauge", "Seconds since the device last reported.")
+	for _, d := range devices {
+		w.Sample("farm_device_last_seen_seconds", []string{"device_id", "kind"},
+			[]string{d.DeviceID, d.Kind}, now.Sub(*d.LastSeen).Seconds())
+	}
+
+	var relays []models.RelayDevice
+	if err := r.db.Order("device_id").Find(&relays).Error; err != nil {
+		return err
+	}
+	w.Header("farm_relay_on", "gauge", "1 if the relay last acknowledged on, 0 if off; absent while unknown.")
+	for _, d := range relays {
+		switch d.State {
+		case relay.ActionOn:
+			w.Sample("farm_relay_on", device, []string{d.DeviceID}, 1)
+		case relay.ActionOff:
+			w.Sample("farm_relay_on", device, []string{d.DeviceID}, 0)
+		}
+	}
+	if r.Monitor != nil {
+		statuses := []string{models.RelayUnknown, models.RelayOnline, models.RelayStale, models.RelayOffline}
+		w.Header("farm_relay_status", "gauge", "1 for the relay's current liveness status.")
+		for _, d := range relays {
+			current := r.Monitor.StatusAt(d.LastHeartbeat, now)
+			for _, s := range statuses {
+				v := 0.0
+				if s == current {
+					v = 1
+				}
+				w.Sample("farm_relay_status", []string{"device_id", "status"}, []string{d.DeviceID, s}, v)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"my-smart-farm/models"
+	"my-smart-farm/store/storetest"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestDeviceSignature(t *testing.T) {
+	db := storetest.SQLite(t, &models.Device{})
+	db.Create(&models.Device{DeviceID: "sensor-001", Kind: models.DeviceKindSensor, Secret: "s3cret"})
+
+	app := fiber.New()
+	app.Post("/data", DeviceSignature(db, SignatureConfig{}), func(c *fiber.Ctx) error {
+		return c.SendString(CallerDeviceID(c))
+	})
+
+	body := `{"deviceID":"sensor-001"}`
+	send := func(ts time.Time, secret string) int {
+		stamp := strconv.FormatInt(ts.Unix(), 10)
+		req := httptest.NewRequest("POST", "/data", strings.NewReader(body))
+		req.Header.Set(HeaderDeviceID, "sensor-001")
+		req.Header.Set(HeaderTimestamp, stamp)
+		req.Header.Set(HeaderSignature, Sign(secret, stamp, "POST", "/data", []byte(body)))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	now := time.Now()
+	if code := send(now, "s3cret"); code != fiber.StatusOK {
+		t.Fatal("valid signature rejected:", code)
+	}
+	if code := send(now, "s3cret"); code != fiber.StatusUnauthorized {
+		t.Error("replayed request accepted:", code)
+	}
+	if code := send(now.Add(time.Second), "wrong"); code != fiber.StatusUnauthorized {
+		t.Error("bad signature accepted:", code)
+	}
+	if code := send(now.Add(-time.Hour), "s3cret"); code != fiber.StatusUnauthorized {
+		t.Error("stale timestamp accepted:", code)
+	}
+}
+
+func TestDeviceSignatureUnsigned(t *testing.T) {
+	db := storetest.SQLite(t, &models.Device{})
+	db.Create(&models.Device{DeviceID: "sensor-001", Kind: models.DeviceKindSensor, Secret: "s3cret"})
+	db.Create(&models.Device{DeviceID: "sensor-002", Kind: models.DeviceKindSensor})
+
+	app := fiber.New()
+	app.Post("/data", DeviceSignature(db, SignatureConfig{AllowUnsigned: true}), func(c *fiber.Ctx) error {
+		return c.SendString(CallerDeviceID(c))
+	})
+
+	send := func(deviceID string) (int, string) {
+		req := httptest.NewRequest("POST", "/data", strings.NewReader(`{}`))
+		if deviceID != "" {
+			req.Header.Set(HeaderDeviceID, deviceID)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, caller := send("sensor-002"); code != fiber.StatusOK || caller != "sensor-002" {
+		t.Errorf("unsigned device answered %d as %q", code, caller)
+	}
+	if code, caller := send("sensor-new"); code != fiber.StatusOK || caller != "sensor-new" {
+		t.Errorf("new device answered %d as %q", code, caller)
+	}
+	if code, _ := send("sensor-001"); code != fiber.StatusUnauthorized {
+		t.Error("unsigned request for a device with a secret accepted:", code)
+	}
+	if code, _ := send(""); code != fiber.StatusUnauthorized {
+		t.Error("unsigned request without a device ID accepted:", code)
+	}
+}
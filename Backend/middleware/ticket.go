@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"my-smart-farm/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// StreamTickets hands out short-lived single-use tickets for the stream
+// routes. Browsers cannot set headers on EventSource or WebSocket
+// connections, so the ticket travels in the URL instead of the session
+// token, which would otherwise end up in proxy logs and browser history.
+type StreamTickets struct {
+	// TTL is how long a ticket may wait before it is used.
+	TTL time.Duration
+
+	mu      sync.Mutex
+	tickets map[string]streamTicket
+}
+
+type streamTicket struct {
+	userID    uint
+	expiresAt time.Time
+}
+
+func NewStreamTickets() *StreamTickets {
+	return &StreamTickets{
+		TTL:     30 * time.Second,
+		tickets: make(map[string]streamTicket),
+	}
+}
+
+// Issue returns a new ticket for the user and when it expires.
+func (t *StreamTickets) Issue(userID uint) (string, time.Time, error) {
+	var raw [32]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return "", time.Time{}, err
+	}
+	ticket := hex.EncodeToString(raw[:])
+	now := time.Now()
+	expiresAt := now.Add(t.TTL)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k, v := range t.tickets {
+		if !now.Before(v.expiresAt) {
+			delete(t.tickets, k)
+		}
+	}
+	t.tickets[HashToken(ticket)] = streamTicket{userID: userID, expiresAt: expiresAt}
+	return ticket, expiresAt, nil
+}
+
+// redeem consumes a ticket and returns the user it was issued to.
+func (t *StreamTickets) redeem(ticket string) (uint, bool) {
+	key := HashToken(ticket)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v, ok := t.tickets[key]
+	delete(t.tickets, key)
+	if !ok || !time.Now().Before(v.expiresAt) {
+		return 0, false
+	}
+	return v.userID, true
+}
+
+// RequireRole is RequireRole for the stream routes: it also accepts a
+// ticket from Issue as ?ticket= on GET requests.
+func (t *StreamTickets) RequireRole(db *gorm.DB, role string) fiber.Handler {
+	bearer := RequireRole(db, role)
+	return func(c *fiber.Ctx) error {
+		ticket := c.Query("ticket")
+		if ticket == "" || c.Method() != fiber.MethodGet {
+			return bearer(c)
+		}
+		userID, ok := t.redeem(ticket)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid or expired ticket",
+			})
+		}
+		var user models.User
+		if err := db.First(&user, userID).Error; err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid or expired ticket",
+			})
+		}
+		return authorize(c, &user, role)
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"my-smart-farm/models"
+	"my-smart-farm/store/storetest"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestStreamTickets(t *testing.T) {
+	db := storetest.SQLite(t, &models.User{}, &models.Session{})
+	user := models.User{Username: "alice", PasswordHash: "-", Role: models.RoleViewer}
+	db.Create(&user)
+	db.Create(&models.Session{TokenHash: HashToken("tok"), UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)})
+
+	tickets := NewStreamTickets()
+	ok := func(c *fiber.Ctx) error { return c.SendString(CurrentUser(c).Username) }
+	app := fiber.New()
+	app.Get("/data", RequireRole(db, models.RoleViewer), ok)
+	app.Get("/stream", tickets.RequireRole(db, models.RoleViewer), ok)
+	app.Get("/admin", tickets.RequireRole(db, models.RoleAdmin), ok)
+	get := func(url string) int {
+		resp, err := app.Test(httptest.NewRequest("GET", url, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	if code := get("/data?access_token=tok"); code != fiber.StatusUnauthorized {
+		t.Error("session token accepted from the URL:", code)
+	}
+	ticket, _, _ := tickets.Issue(user.ID)
+	if code := get("/data?ticket=" + ticket); code != fiber.StatusUnauthorized {
+		t.Error("ticket accepted outside the stream routes:", code)
+	}
+	if code := get("/stream?ticket=" + ticket); code != fiber.StatusOK {
+		t.Fatal("valid ticket rejected:", code)
+	}
+	if code := get("/stream?ticket=" + ticket); code != fiber.StatusUnauthorized {
+		t.Error("ticket used twice:", code)
+	}
+	ticket, _, _ = tickets.Issue(user.ID)
+	if code := get("/admin?ticket=" + ticket); code != fiber.StatusForbidden {
+		t.Error("ticket should carry the user's role:", code)
+	}
+	tickets.TTL = -time.Second
+	ticket, _, _ = tickets.Issue(user.ID)
+	if code := get("/stream?ticket=" + ticket); code != fiber.StatusUnauthorized {
+		t.Error("expired ticket accepted:", code)
+	}
+}
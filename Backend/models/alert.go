@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+const (
+	AlertPending  = "pending"
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// AlertRule fires when a metric crosses a threshold, e.g. soil < 25 for 15
+// minutes. An empty DeviceID applies the rule to every sensor.
+type AlertRule struct {
+	ID       uint    `gorm:"primaryKey" json:"id"`
+	Name     string  `gorm:"size:100" json:"name"`
+	DeviceID string  `gorm:"size:50;index" json:"device_id"`
+	Metric   string  `gorm:"size:20;not null" json:"metric"`
+	Operator string  `gorm:"size:2;not null" json:"operator"` // "<" or ">"
+	Value    float64 `gorm:"not null" json:"value"`
+	// Hysteresis is how far past the threshold the metric must recover
+	// before a firing alert resolves.
+	Hysteresis float64 `json:"hysteresis"`
+	// ForSeconds is how long the condition must hold before firing.
+	ForSeconds int       `json:"for_seconds"`
+	Enabled    bool      `gorm:"not null" json:"enabled"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Alert tracks one rule on one device from the first breaching reading
+// until it resolves. System alerts raised outside the rules, such as an
+// automation refusing an offline relay, have RuleID zero and a Message.
+type Alert struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	RuleID     uint       `gorm:"not null;index" json:"rule_id"`
+	Message    string     `gorm:"size:200" json:"message,omitempty"`
+	DeviceID   string     `gorm:"size:50;not null;index" json:"device_id"`
+	State      string     `gorm:"size:10;not null;index" json:"state"`
+	Value      float64    `json:"value"` // latest evaluated value
+	StartedAt  time.Time  `gorm:"not null" json:"started_at"`
+	FiredAt    *time.Time `json:"fired_at"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	Rule       AlertRule  `json:"rule"`
+}
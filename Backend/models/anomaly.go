@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+const (
+	AnomalyStuck   = "stuck"
+	AnomalyStep    = "step"
+	AnomalyOutlier = "outlier"
+)
+
+// Anomaly annotates a reading whose metric looks wrong against the device's
+// recent history: frozen, jumping or far outside its usual spread.
+type Anomaly struct {
+	ID        uint    `gorm:"primaryKey" json:"id"`
+	ReadingID uint    `gorm:"not null;uniqueIndex:idx_anomaly_reading" json:"reading_id"`
+	DeviceID  string  `gorm:"size:50;not null;index" json:"device_id"`
+	Metric    string  `gorm:"size:20;not null;uniqueIndex:idx_anomaly_reading" json:"metric"`
+	Kind      string  `gorm:"size:10;not null;uniqueIndex:idx_anomaly_reading" json:"kind"`
+	Value     float64 `json:"value"`
+	// Score is the number of frozen samples, the size of the step or the
+	// robust z-score, depending on Kind.
+	Score     float64   `json:"score"`
+	Message   string    `gorm:"size:200" json:"message"`
+	Timestamp time.Time `gorm:"not null;index" json:"timestamp"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+const (
+	AutomationIdle     = "idle"
+	AutomationWatering = "watering"
+	AutomationCooldown = "cooldown"
+)
+
+// IrrigationRule switches RelayID on when SensorID's soil moisture drops
+// below SoilBelow, keeps it on for at most MaxOnSeconds and then waits
+// CooldownSeconds before it may trigger again.
+type IrrigationRule struct {
+	ID        uint    `gorm:"primaryKey" json:"id"`
+	Name      string  `gorm:"size:100" json:"name"`
+	SensorID  string  `gorm:"size:50;not null;index" json:"sensor_id"`
+	RelayID   string  `gorm:"size:50;not null" json:"relay_id"`
+	SoilBelow float64 `gorm:"not null" json:"soil_below"`
+	// SoilTarget optionally stops watering early once soil reaches it.
+	SoilTarget      float64 `json:"soil_target"`
+	MaxOnSeconds    int     `gorm:"not null" json:"max_on_seconds"`
+	CooldownSeconds int     `gorm:"not null" json:"cooldown_seconds"`
+	Enabled         bool    `gorm:"not null" json:"enabled"`
+
+	State     string     `gorm:"size:10;not null;default:idle" json:"state"`
+	OnSince   *time.Time `json:"on_since"`
+	LastOffAt *time.Time `json:"last_off_at"`
+	LastError string     `json:"last_error"`
+	CreatedAt time.Time  `json:"created_at"`
+}
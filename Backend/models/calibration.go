@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// SoilCalibrationPoint maps a raw soil probe ADC value to moisture percent.
+type SoilCalibrationPoint struct {
+	Raw     int     `json:"raw"`
+	Percent float64 `json:"percent"`
+}
+
+// SoilCalibration turns a probe's raw ADC values into soil moisture. Two
+// points, usually dry air and water, give a straight line; more points give
+// a piecewise linear curve.
+type SoilCalibration struct {
+	DeviceID string                 `gorm:"primaryKey;size:50" json:"device_id"`
+	Points   []SoilCalibrationPoint `gorm:"serializer:json;not null" json:"points"`
+	Updated  time.Time              `gorm:"not null" json:"updated"`
+}
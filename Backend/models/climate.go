@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Planting records when the crop watched by a sensor was planted, so growing
+// degree days can be summed from that day. BaseTemperature overrides the
+// configured default for the crop.
+type Planting struct {
+	DeviceID        string    `gorm:"primaryKey;size:50" json:"device_id"`
+	Crop            string    `gorm:"size:100" json:"crop"`
+	PlantedOn       time.Time `gorm:"not null" json:"planted_on"` // local midnight
+	BaseTemperature *float64  `json:"base_temperature"`
+	Updated         time.Time `gorm:"not null" json:"updated"`
+}
+
+// DailyClimate summarises one local day of a sensor's accepted readings.
+type DailyClimate struct {
+	DeviceID        string    `gorm:"primaryKey;size:50" json:"device_id"`
+	Day             time.Time `gorm:"primaryKey" json:"day"` // local midnight
+	Count           int       `gorm:"not null" json:"count"`
+	TemperatureMin  float64   `json:"temperature_min"`
+	TemperatureMax  float64   `json:"temperature_max"`
+	TemperatureMean float64   `json:"temperature_mean"`
+	HumidityMin     float64   `json:"humidity_min"`
+	HumidityMax     float64   `json:"humidity_max"`
+	HumidityMean    float64   `json:"humidity_mean"`
+	// HoursCovered counts the hours of the day with at least one reading.
+	HoursCovered int `gorm:"not null;default:0" json:"hours_covered"`
+	// Complete is set when enough hours are covered for min and max to be
+	// trusted. GDD is nil for incomplete days, which add nothing to
+	// CumulativeGDD.
+	Complete bool `gorm:"not null;default:false" json:"complete"`
+	// BaseTemperature is the base GDD was computed against.
+	BaseTemperature float64  `json:"base_temperature"`
+	GDD             *float64 `json:"gdd"`
+	// CumulativeGDD sums GDD from the planting day through Day. It is nil
+	// for days before planting and for devices without a planting.
+	CumulativeGDD *float64 `json:"cumulative_gdd"`
+}
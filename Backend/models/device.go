@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+const (
+	DeviceKindSensor = "sensor"
+	DeviceKindRelay  = "relay"
+)
+
+// Device is the registry entry for a sensor or relay. Readings, interval
+// settings and relay registrations refer to it by DeviceID.
+type Device struct {
+	DeviceID        string `gorm:"primaryKey;size:50" json:"device_id"`
+	Name            string `gorm:"size:100" json:"name"`
+	Kind            string `gorm:"size:10;not null;default:sensor" json:"kind"`
+	Zone            string `gorm:"size:50;index" json:"zone"`
+	Location        string `gorm:"size:100" json:"location"`
+	FirmwareVersion string `gorm:"size:50" json:"firmware_version"`
+	Notes           string `json:"notes"`
+	// RelayID is the relay that waters the same bed as this sensor. It must
+	// name a registered device of kind relay.
+	RelayID string `gorm:"size:50" json:"relay_id,omitempty"`
+	// Secret is the HMAC key the device signs its requests with.
+	Secret string `gorm:"size:64" json:"-"`
+	// MQTTPasswordHash is the SHA-256 of the password the device logs in
+	// to the embedded MQTT broker with. It is separate from Secret, which
+	// never leaves the device.
+	MQTTPasswordHash string     `gorm:"size:64" json:"-"`
+	CreatedAt        time.Time  `json:"created_at"`
+	LastSeen         *time.Time `json:"last_seen"`
+
+	Interval *IntervalSetting `gorm:"foreignKey:DeviceID;references:DeviceID" json:"interval,omitempty"`
+	Relay    *RelayDevice     `gorm:"foreignKey:DeviceID;references:DeviceID" json:"relay,omitempty"`
+}
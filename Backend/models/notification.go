@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+const (
+	ChannelWebhook = "webhook"
+	ChannelEmail   = "email"
+	ChannelLINE    = "line"
+)
+
+// NotificationChannel is a destination for alert notifications.
+//
+// Field use depends on Kind:
+//   - webhook: URL is the endpoint that receives a JSON POST.
+//   - email: URL is the SMTP server "host:port", Username/Token the SMTP
+//     credentials, From the sender and To a comma-separated recipient list.
+//   - line: Token is the access token. With To set the message is pushed to
+//     that user or group via the Messaging API, otherwise LINE Notify is
+//     used. URL optionally overrides the API endpoint.
+type NotificationChannel struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	Name     string `gorm:"size:100" json:"name"`
+	Kind     string `gorm:"size:10;not null" json:"kind"`
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Token    string `json:"token,omitempty"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	// Template is a text/template for the message body; empty uses the default.
+	Template string `json:"template"`
+	// MinIntervalSeconds rate limits the channel to one message per alert
+	// and state per interval.
+	MinIntervalSeconds int        `json:"min_interval_seconds"`
+	Enabled            bool       `gorm:"not null" json:"enabled"`
+	LastSentAt         *time.Time `json:"last_sent_at"`
+	LastError          string     `json:"last_error"`
+	CreatedAt          time.Time  `json:"created_at"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// Who issued a relay command.
+const (
+	IssuerUser     = "user"
+	IssuerSchedule = "schedule"
+	IssuerRule     = "rule"
+)
+
+// Delivery status of a relay command.
+const (
+	CommandPending   = "pending"
+	CommandDelivered = "delivered"
+	CommandFailed    = "failed"
+	CommandExpired   = "expired"
+)
+
+// RelayCommand is one command sent to a relay device: the audit record of
+// who issued it and, for queued commands, its delivery state. The response
+// fields describe the latest attempt; StatusCode is zero and Error is set
+// when the device could not be reached.
+type RelayCommand struct {
+	ID       uint      `gorm:"primaryKey" json:"id"`
+	DeviceID string    `gorm:"size:50;not null;index:idx_relay_cmd_device_time,priority:1" json:"device_id"`
+	Action   string    `gorm:"size:10;not null" json:"action"`
+	IssuedAt time.Time `gorm:"not null;index:idx_relay_cmd_device_time,priority:2" json:"issued_at"`
+	// IssuedBy is IssuerUser, IssuerSchedule or IssuerRule; IssuerID is the
+	// username, schedule ID or irrigation rule ID.
+	IssuedBy string `gorm:"size:10;not null" json:"issued_by"`
+	IssuerID string `gorm:"size:100" json:"issuer_id"`
+
+	Status        string     `gorm:"size:10;not null;default:pending;index" json:"status"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+
+	StatusCode int    `json:"status_code"`
+	Response   string `json:"response"`
+	Error      string `json:"error"`
+	LatencyMs  int64  `json:"latency_ms"`
+}
@@ -2,8 +2,31 @@ package models
 
 import "time"
 
+// Relay liveness, derived from how long ago the relay was last heard from.
+// A relay never heard from is unknown.
+const (
+	RelayUnknown = "unknown"
+	RelayOnline  = "online"
+	RelayStale   = "stale"
+	RelayOffline = "offline"
+)
+
 type RelayDevice struct {
 	DeviceID string    `gorm:"primaryKey" json:"device_id"`
 	IP       string    `gorm:"not null" json:"ip"`
 	Updated  time.Time `gorm:"not null" json:"updated"`
+
+	// State is the last state the relay acknowledged ("on" or "off"), empty
+	// until it has accepted a command.
+	State          string     `gorm:"size:10" json:"state"`
+	StateChangedAt *time.Time `json:"state_changed_at"`
+	StateChangedBy string     `gorm:"size:100" json:"state_changed_by"`
+
+	// LastHeartbeat is the last time the relay registered, sent a heartbeat,
+	// answered a probe or accepted a command. Status is RelayUnknown,
+	// RelayOnline, RelayStale or RelayOffline as last judged by the relay
+	// monitor.
+	LastHeartbeat   *time.Time `json:"last_heartbeat"`
+	Status          string     `gorm:"size:10" json:"status"`
+	StatusChangedAt *time.Time `json:"status_changed_at"`
 }
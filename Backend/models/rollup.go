@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// RollupStats is the min/max/avg of one metric within a rollup bucket.
+type RollupStats struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	Avg float64 `json:"avg"`
+}
+
+// SensorRollup is a compacted summary of raw SensorData rows for one device
+// over one bucket starting at Start.
+type SensorRollup struct {
+	DeviceID    string      `gorm:"primaryKey;size:50" json:"device_id"`
+	Start       time.Time   `gorm:"primaryKey" json:"start"`
+	Count       int         `gorm:"not null" json:"count"`
+	Temperature RollupStats `gorm:"embedded;embeddedPrefix:temperature_" json:"temperature"`
+	Humidity    RollupStats `gorm:"embedded;embeddedPrefix:humidity_" json:"humidity"`
+	Soil        RollupStats `gorm:"embedded;embeddedPrefix:soil_" json:"soil"`
+}
+
+type HourlyRollup struct {
+	SensorRollup
+}
+
+type DailyRollup struct {
+	SensorRollup
+}
+
+// RetentionPolicy overrides how long raw readings of a device are kept
+// before they are rolled up and deleted.
+type RetentionPolicy struct {
+	DeviceID string    `gorm:"primaryKey;size:50" json:"device_id"`
+	RawDays  int       `gorm:"not null" json:"raw_days"`
+	Updated  time.Time `gorm:"not null" json:"updated"`
+}
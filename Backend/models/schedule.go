@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// RelaySchedule switches RelayID on at every time matched by Cron and off
+// again DurationSeconds later. Cron is a five-field expression
+// (minute hour day-of-month month weekday) evaluated in TimeZone, or the
+// server's zone when TimeZone is empty; "0 6 * * 1-6" with a duration of
+// 600 waters at 06:00 for ten minutes Monday to Saturday.
+type RelaySchedule struct {
+	ID              uint   `gorm:"primaryKey" json:"id"`
+	Name            string `gorm:"size:100" json:"name"`
+	RelayID         string `gorm:"size:50;not null;index" json:"relay_id"`
+	Cron            string `gorm:"size:100;not null" json:"cron"`
+	DurationSeconds int    `gorm:"not null" json:"duration_seconds"`
+	TimeZone        string `gorm:"size:50" json:"time_zone"`
+	Enabled         bool   `gorm:"not null" json:"enabled"`
+
+	// LastRunAt is the start of the most recent run that was handled, so
+	// runs missed while the server was down can be found on restart.
+	LastRunAt *time.Time `json:"last_run_at"`
+	// OffAt is set while the relay is on and holds when to switch it off.
+	OffAt     *time.Time `json:"off_at"`
+	LastError string     `json:"last_error"`
+	CreatedAt time.Time  `json:"created_at"`
+}
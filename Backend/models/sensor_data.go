@@ -2,11 +2,27 @@ package models
 
 import "time"
 
+// Quality grades of a reading.
+const (
+	QualityOK       = "ok"
+	QualitySuspect  = "suspect"
+	QualityRejected = "rejected"
+)
+
+// SensorData is one reading. DeviceID refers to the Device registry; the
+// ingest pipeline registers a sensor on its first reading.
 type SensorData struct {
 	ID          uint      `gorm:"primaryKey"`
-	DeviceID    string    `gorm:"size:50;not null"`
+	DeviceID    string    `gorm:"size:50;not null;index:idx_sensor_device_time,priority:1"`
 	Temperature float64   `gorm:"not null"`
 	Humidity    float64   `gorm:"not null"`
 	Soil        float64   `gorm:"not null"`
-	Timestamp   time.Time `gorm:"not null"`
+	Timestamp   time.Time `gorm:"not null;index:idx_sensor_device_time,priority:2;index:idx_sensor_time"`
+	// SoilRaw is the soil probe's ADC value, 0-65535, when the firmware
+	// sends it. Soil of calibrated probes is computed from it.
+	SoilRaw *int
+	// Quality is set by the Backend on ingestion; QualityNote says which
+	// check a suspect or rejected reading failed.
+	Quality     string `gorm:"size:10;not null;default:ok;index"`
+	QualityNote string
 }
@@ -0,0 +1,283 @@
+// Package mqttbridge connects the Backend to MQTT: it stores telemetry that
+// devices publish like CreateSensorData does, carries relay commands to
+// relays and can run a small embedded broker.
+//
+// Topics:
+//
+//	farm/<device>/telemetry    device -> Backend, a SensorData JSON object
+//	farm/<device>/interval     Backend -> device, {"intervalSeconds": n}
+//	farm/<device>/relay/set    Backend -> relay, "on" or "off"
+//	farm/<device>/relay/state  relay -> Backend, "on" or "off"
+package mqttbridge
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"my-smart-farm/ingest"
+	"my-smart-farm/middleware"
+	"my-smart-farm/models"
+	"my-smart-farm/relay"
+
+	mqtt "github.com/soypat/natiu-mqtt"
+	"gorm.io/gorm"
+)
+
+func topic(deviceID, suffix string) string { return "farm/" + deviceID + "/" + suffix }
+
+// deviceFromTopic splits "farm/<device>/<suffix>".
+func deviceFromTopic(t string) (deviceID, suffix string, ok bool) {
+	rest, ok := strings.CutPrefix(t, "farm/")
+	if !ok {
+		return "", "", false
+	}
+	deviceID, suffix, ok = strings.Cut(rest, "/")
+	return deviceID, suffix, ok && deviceID != ""
+}
+
+// Config says which broker the bridge connects to.
+type Config struct {
+	// Broker is the host:port of the MQTT broker.
+	Broker   string
+	Username string
+	Password string
+	ClientID string
+}
+
+type message struct {
+	topic   string
+	payload []byte
+}
+
+// Bridge subscribes to device topics on a broker and publishes relay
+// commands. It reconnects on its own when the broker goes away.
+type Bridge struct {
+	pipe   *ingest.Pipeline
+	relays *relay.Client
+	cfg    Config
+
+	// ReconnectDelay is the wait between connection attempts.
+	ReconnectDelay time.Duration
+	// KeepAlive is announced to the broker and drives pings.
+	KeepAlive time.Duration
+
+	client *mqtt.Client
+	inbox  chan message
+	ready  chan struct{}
+}
+
+func NewBridge(pipe *ingest.Pipeline, relays *relay.Client, cfg Config) *Bridge {
+	if cfg.ClientID == "" {
+		cfg.ClientID = "my-smart-farm"
+	}
+	b := &Bridge{
+		pipe:           pipe,
+		relays:         relays,
+		cfg:            cfg,
+		ReconnectDelay: 5 * time.Second,
+		KeepAlive:      60 * time.Second,
+		inbox:          make(chan message, 256),
+		ready:          make(chan struct{}, 1),
+	}
+	// OnPub runs inside the read loop, which must not be blocked by
+	// database work or publish from within, so messages are queued.
+	b.client = mqtt.NewClient(mqtt.ClientConfig{
+		OnPub: func(_ mqtt.Header, vp mqtt.VariablesPublish, r io.Reader) error {
+			payload, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			select {
+			case b.inbox <- message{topic: string(vp.TopicName), payload: payload}:
+			default:
+				log.Println("mqtt: inbox full, dropping message on", string(vp.TopicName))
+			}
+			return nil
+		},
+	})
+	return b
+}
+
+// Start connects and handles messages until ctx is done.
+func (b *Bridge) Start(ctx context.Context) {
+	go func() {
+		for ctx.Err() == nil {
+			if err := b.session(ctx); err != nil && ctx.Err() == nil {
+				log.Println("mqtt:", err)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(b.ReconnectDelay):
+			}
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-b.inbox:
+				b.handle(msg)
+			}
+		}
+	}()
+}
+
+// Ready is signalled each time the bridge has connected and subscribed.
+func (b *Bridge) Ready() <-chan struct{} { return b.ready }
+
+// session runs one broker connection until it fails.
+func (b *Bridge) session(ctx context.Context) error {
+	conn, err := net.DialTimeout("tcp", b.cfg.Broker, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	// The client only gives up its read lock once a read fails, so closing
+	// the connection is the way to stop it.
+	defer conn.Close()
+
+	vc := mqtt.VariablesConnect{
+		Username:  []byte(b.cfg.Username),
+		Password:  []byte(b.cfg.Password),
+		KeepAlive: uint16(b.KeepAlive / time.Second),
+	}
+	vc.SetDefaultMQTT([]byte(b.cfg.ClientID))
+	connectCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := b.client.Connect(connectCtx, conn, &vc); err != nil {
+		return fmt.Errorf("connect %s: %w", b.cfg.Broker, err)
+	}
+	defer b.client.Disconnect(errors.New("session ended"))
+
+	err = b.client.Subscribe(connectCtx, mqtt.VariablesSubscribe{
+		PacketIdentifier: 1,
+		TopicFilters: []mqtt.SubscribeRequest{
+			{TopicFilter: []byte("farm/+/telemetry"), QoS: mqtt.QoS0},
+			{TopicFilter: []byte("farm/+/relay/state"), QoS: mqtt.QoS0},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("subscribe: %w", err)
+	}
+	log.Println("mqtt: connected to", b.cfg.Broker)
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ping := time.NewTicker(b.KeepAlive / 2)
+		defer ping.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				conn.Close()
+				return
+			case <-ping.C:
+				// An unanswered ping from the last round means the link is dead.
+				if b.client.AwaitingPingresp() || b.client.StartPing() != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for b.client.IsConnected() {
+		if err := b.client.HandleNext(); err != nil {
+			return err
+		}
+	}
+	return b.client.Err()
+}
+
+func (b *Bridge) handle(msg message) {
+	deviceID, suffix, ok := deviceFromTopic(msg.topic)
+	if !ok {
+		return
+	}
+	var err error
+	switch suffix {
+	case "telemetry":
+		err = b.storeTelemetry(deviceID, msg.payload)
+	case "relay/state":
+		state := strings.ToLower(strings.TrimSpace(string(msg.payload)))
+		err = b.relays.ReportState(deviceID, state, time.Now())
+	}
+	if err != nil {
+		log.Printf("mqtt: %s: %v", msg.topic, err)
+	}
+}
+
+// storeTelemetry saves a reading and answers with the device's next send
+// slot, as the HTTP ingestion endpoint does.
+func (b *Bridge) storeTelemetry(deviceID string, payload []byte) error {
+	var data models.SensorData
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return err
+	}
+	// The topic names the device; the broker only let it publish there.
+	if data.DeviceID != "" && data.DeviceID != deviceID {
+		return fmt.Errorf("payload device %q does not match topic", data.DeviceID)
+	}
+	data.DeviceID = deviceID
+	data.ID = 0
+	if err := b.pipe.Save(&data); err != nil {
+		return err
+	}
+
+	reply, _ := json.Marshal(map[string]int{"intervalSeconds": b.pipe.NextWait(deviceID)})
+	return b.publish(topic(deviceID, "interval"), reply)
+}
+
+// PublishRelay sends "on" or "off" to the relay's set topic.
+func (b *Bridge) PublishRelay(deviceID, action string) error {
+	return b.publish(topic(deviceID, "relay/set"), []byte(action))
+}
+
+func (b *Bridge) publish(t string, payload []byte) error {
+	flags, _ := mqtt.NewPublishFlags(mqtt.QoS0, false, false)
+	// natiu-mqtt validates a packet identifier even for QoS 0.
+	return b.client.PublishPayload(flags, mqtt.VariablesPublish{TopicName: []byte(t), PacketIdentifier: 1}, payload)
+}
+
+// DeviceAuth authenticates devices on the embedded broker: the username is
+// the device ID, the password the one from POST /devices/<id>/mqtt-password,
+// and the device is limited to its own farm/<device>/ topics. The signing
+// secret is never accepted, since it must not travel. Devices with neither
+// an MQTT password nor a secret are accepted with any password only when
+// allowUnsigned is set, matching HTTP ingestion. The bridge itself logs in
+// as internal with full access.
+func DeviceAuth(db *gorm.DB, allowUnsigned bool, internal Config) Authenticator {
+	return func(username, password string) (string, bool) {
+		if internal.Username != "" &&
+			subtle.ConstantTimeCompare([]byte(username), []byte(internal.Username)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(password), []byte(internal.Password)) == 1 {
+			return "", true
+		}
+		if username == "" || strings.ContainsAny(username, "/+#") {
+			return "", false
+		}
+		var device models.Device
+		if err := db.Limit(1).Find(&device, "device_id = ?", username).Error; err != nil || device.DeviceID == "" {
+			return "", false
+		}
+		if device.MQTTPasswordHash == "" {
+			return topic(username, ""), allowUnsigned && device.Secret == ""
+		}
+		ok := subtle.ConstantTimeCompare([]byte(middleware.HashToken(password)), []byte(device.MQTTPasswordHash)) == 1
+		return topic(username, ""), ok
+	}
+}
@@ -0,0 +1,233 @@
+package mqttbridge
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"my-smart-farm/ingest"
+	"my-smart-farm/middleware"
+	"my-smart-farm/models"
+	"my-smart-farm/relay"
+	"my-smart-farm/store"
+	"my-smart-farm/store/storetest"
+
+	mqtt "github.com/soypat/natiu-mqtt"
+)
+
+// device connects to addr as a farm device and forwards what it receives.
+func device(t *testing.T, addr, id, secret string, got chan<- string) *mqtt.Client {
+	t.Helper()
+	client := mqtt.NewClient(mqtt.ClientConfig{
+		OnPub: func(_ mqtt.Header, vp mqtt.VariablesPublish, r io.Reader) error {
+			payload, _ := io.ReadAll(r)
+			got <- string(vp.TopicName) + " " + string(payload)
+			return nil
+		},
+	})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	vc := mqtt.VariablesConnect{Username: []byte(id), Password: []byte(secret)}
+	vc.SetDefaultMQTT([]byte(id))
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := client.Connect(ctx, conn, &vc); err != nil {
+		t.Fatal(err)
+	}
+	err = client.Subscribe(ctx, mqtt.VariablesSubscribe{
+		PacketIdentifier: 1,
+		TopicFilters: []mqtt.SubscribeRequest{
+			{TopicFilter: []byte(topic(id, "interval")), QoS: mqtt.QoS0},
+			{TopicFilter: []byte(topic(id, "relay/set")), QoS: mqtt.QoS0},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for client.HandleNext() == nil {
+		}
+	}()
+	return client
+}
+
+func publish(t *testing.T, client *mqtt.Client, topic, payload string) {
+	t.Helper()
+	flags, _ := mqtt.NewPublishFlags(mqtt.QoS0, false, false)
+	err := client.PublishPayload(flags, mqtt.VariablesPublish{TopicName: []byte(topic), PacketIdentifier: 1}, []byte(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receive(t *testing.T, got <-chan string) string {
+	t.Helper()
+	select {
+	case msg := <-got:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message received")
+		return ""
+	}
+}
+
+// eventually polls cond until it holds or a deadline passes.
+func eventually(t *testing.T, cond func() bool) bool {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestBridge(t *testing.T) {
+	db := storetest.SQLite(t, &models.SensorData{}, &models.IntervalSetting{}, &models.Device{},
+		&models.RelayDevice{}, &models.RelayCommand{})
+	db.Create(&models.Device{DeviceID: "sensor-001", Kind: models.DeviceKindSensor, Secret: "s3cret",
+		MQTTPasswordHash: middleware.HashToken("sensor-pw")})
+	db.Create(&models.Device{DeviceID: "relay-001", Kind: models.DeviceKindRelay, Secret: "r3lay",
+		MQTTPasswordHash: middleware.HashToken("relay-pw")})
+	db.Create(&models.IntervalSetting{DeviceID: "sensor-001", IntervalSeconds: 120})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{Broker: ln.Addr().String(), Username: "bridge", Password: "internal"}
+	broker := NewBroker(DeviceAuth(db, false, cfg))
+	go broker.Serve(ln)
+	defer broker.Close()
+
+	relays := relay.NewClient(db)
+	bridge := NewBridge(ingest.NewPipeline(store.NewSQL(db)), relays, cfg)
+	relays.Publisher = bridge
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bridge.Start(ctx)
+	select {
+	case <-bridge.Ready():
+	case <-time.After(2 * time.Second):
+		t.Fatal("bridge did not connect")
+	}
+
+	// Telemetry is stored and answered with the wait until the next slot.
+	sensorGot := make(chan string, 4)
+	sensor := device(t, cfg.Broker, "sensor-001", "sensor-pw", sensorGot)
+	publish(t, sensor, "farm/sensor-001/telemetry", `{"Temperature":31.5,"Humidity":60,"Soil":22}`)
+	if msg := receive(t, sensorGot); !strings.HasPrefix(msg, `farm/sensor-001/interval {"intervalSeconds":`) {
+		t.Errorf("unexpected reply %q", msg)
+	}
+	var reading models.SensorData
+	if err := db.First(&reading, "device_id = ?", "sensor-001").Error; err != nil || reading.Soil != 22 {
+		t.Errorf("reading not stored: %+v %v", reading, err)
+	}
+
+	// A relay registered without an IP gets its command on the set topic.
+	relayGot := make(chan string, 4)
+	relayClient := device(t, cfg.Broker, "relay-001", "relay-pw", relayGot)
+	publish(t, relayClient, "farm/relay-001/relay/state", "off")
+	if !eventually(t, func() bool {
+		var n int64
+		db.Model(&models.RelayDevice{}).Where("device_id = ?", "relay-001").Count(&n)
+		return n == 1
+	}) {
+		t.Fatal("registered relay not recorded on its first report")
+	}
+	resp, err := relays.Send(ctx, "relay-001", relay.ActionOn, relay.ByUser("alice"))
+	if err != nil || resp.StatusCode != 202 {
+		t.Fatalf("send over MQTT: %v %v", resp, err)
+	}
+	if msg := receive(t, relayGot); msg != "farm/relay-001/relay/set on" {
+		t.Errorf("unexpected command %q", msg)
+	}
+
+	// The relay's own report sets the state, credited to the command.
+	publish(t, relayClient, "farm/relay-001/relay/state", "on")
+	var rd models.RelayDevice
+	eventually(t, func() bool {
+		db.First(&rd, "device_id = ?", "relay-001")
+		return rd.State == relay.ActionOn
+	})
+	if rd.State != relay.ActionOn || rd.StateChangedBy != "user:alice" {
+		t.Errorf("relay state not reported: %q by %q", rd.State, rd.StateChangedBy)
+	}
+
+	// Devices may only use their own topics, and only registered relays
+	// report a state. Messages from one client are handled in order, so
+	// once the reply to the last telemetry arrives the others were seen.
+	publish(t, sensor, "farm/sensor-002/telemetry", `{"Soil":1}`)
+	publish(t, sensor, "farm/sensor-001/relay/state", "on")
+	publish(t, sensor, "farm/sensor-001/telemetry", `{"Temperature":31.5,"Humidity":60,"Soil":23}`)
+	receive(t, sensorGot)
+	var count int64
+	db.Model(&models.SensorData{}).Where("device_id = ?", "sensor-002").Count(&count)
+	if count != 0 {
+		t.Error("publish outside the device's prefix was accepted")
+	}
+	db.Model(&models.RelayDevice{}).Where("device_id = ?", "sensor-001").Count(&count)
+	if count != 0 {
+		t.Error("unregistered relay created by its state report")
+	}
+}
+
+func TestDeviceAuth(t *testing.T) {
+	db := storetest.SQLite(t, &models.Device{})
+	db.Create(&models.Device{DeviceID: "sensor-001", Secret: "s3cret", MQTTPasswordHash: middleware.HashToken("mqtt-pw")})
+	db.Create(&models.Device{DeviceID: "sensor-signed", Secret: "s3cret"})
+	db.Create(&models.Device{DeviceID: "sensor-new"})
+
+	auth := DeviceAuth(db, false, Config{Username: "bridge", Password: "internal"})
+	cases := []struct {
+		user, pass, prefix string
+		ok                 bool
+	}{
+		{"bridge", "internal", "", true},
+		{"bridge", "wrong", "", false},
+		{"sensor-001", "mqtt-pw", "farm/sensor-001/", true},
+		{"sensor-001", "s3cret", "", false},
+		{"sensor-001", "nope", "", false},
+		{"sensor-signed", "s3cret", "", false},
+		{"sensor-new", "", "", false},
+		{"unknown", "s3cret", "", false},
+		{"farm/#", "", "", false},
+	}
+	for _, c := range cases {
+		prefix, ok := auth(c.user, c.pass)
+		if ok != c.ok || (ok && prefix != c.prefix) {
+			t.Errorf("auth(%q, %q) = %q, %v", c.user, c.pass, prefix, ok)
+		}
+	}
+	if _, ok := DeviceAuth(db, true, Config{})("sensor-new", ""); !ok {
+		t.Error("unsigned device should be accepted when allowed")
+	}
+	if _, ok := DeviceAuth(db, true, Config{})("sensor-signed", "s3cret"); ok {
+		t.Error("signing device without an MQTT password accepted")
+	}
+}
+
+func TestTopicMatch(t *testing.T) {
+	cases := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"farm/+/telemetry", "farm/sensor-001/telemetry", true},
+		{"farm/+/telemetry", "farm/sensor-001/relay/state", false},
+		{"farm/#", "farm/relay-001/relay/state", true},
+		{"farm/relay-001/#", "farm/relay-001", true},
+		{"farm/+", "farm/a/b", false},
+	}
+	for _, c := range cases {
+		if got := TopicMatch(c.filter, c.topic); got != c.want {
+			t.Errorf("TopicMatch(%q, %q) = %v", c.filter, c.topic, got)
+		}
+	}
+}
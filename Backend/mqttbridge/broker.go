@@ -0,0 +1,276 @@
+package mqttbridge
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/soypat/natiu-mqtt"
+)
+
+// Authenticator checks CONNECT credentials. It returns the topic prefix the
+// client may publish and subscribe under, "" for unrestricted access.
+type Authenticator func(username, password string) (prefix string, ok bool)
+
+// Broker is a minimal MQTT 3.1.1 broker for farms without one of their own.
+// It supports QoS 0 delivery, QoS 1 publishes acknowledged on receipt and
+// the + and # wildcards; retained messages and sessions are not kept.
+type Broker struct {
+	// Auth, when set, must accept every connection.
+	Auth Authenticator
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	clients   map[*brokerConn]struct{}
+}
+
+func NewBroker(auth Authenticator) *Broker {
+	return &Broker{Auth: auth, clients: make(map[*brokerConn]struct{})}
+}
+
+// ListenAndServe accepts clients on addr until Close is called.
+func (b *Broker) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return b.Serve(ln)
+}
+
+// Serve accepts clients on ln until Close is called. It may be called for
+// several listeners, e.g. a TLS one for devices and a loopback one for the
+// bridge.
+func (b *Broker) Serve(ln net.Listener) error {
+	b.mu.Lock()
+	b.listeners = append(b.listeners, ln)
+	b.mu.Unlock()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go b.serveConn(conn)
+	}
+}
+
+// Close stops accepting clients and disconnects the connected ones.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.clients {
+		c.conn.Close()
+	}
+	var errs []error
+	for _, ln := range b.listeners {
+		errs = append(errs, ln.Close())
+	}
+	return errors.Join(errs...)
+}
+
+type brokerConn struct {
+	conn   net.Conn
+	prefix string
+
+	mu     sync.Mutex // guards tx and filters
+	tx     mqtt.Tx
+	filter []string
+}
+
+// publishHeader is the fixed header of an outgoing QoS 0 PUBLISH; natiu-mqtt
+// only builds headers by decoding them.
+var publishHeader, _, _ = mqtt.DecodeHeader(bytes.NewReader([]byte{byte(mqtt.PacketPublish) << 4, 0}))
+
+func (b *Broker) serveConn(conn net.Conn) {
+	c := &brokerConn{conn: conn}
+	c.tx.SetTxTransport(conn)
+	defer conn.Close()
+
+	decoder := mqtt.DecoderNoAlloc{UserBuffer: make([]byte, 4*1024)}
+	keepAlive := time.Duration(0)
+	connected := false
+	for {
+		if keepAlive > 0 {
+			// [MQTT-3.1.2-24] allows one and a half keep-alive periods.
+			conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		}
+		hdr, _, err := mqtt.DecodeHeader(conn)
+		if err != nil {
+			break
+		}
+		body := &io.LimitedReader{R: conn, N: int64(hdr.RemainingLength)}
+
+		if !connected && hdr.Type() != mqtt.PacketConnect {
+			return
+		}
+		switch hdr.Type() {
+		case mqtt.PacketConnect:
+			if connected {
+				return // a second CONNECT is a protocol violation
+			}
+			vc, _, err := decoder.DecodeConnect(body)
+			if err != nil {
+				return
+			}
+			code := mqtt.ReturnCodeConnAccepted
+			if b.Auth != nil {
+				prefix, ok := b.Auth(string(vc.Username), string(vc.Password))
+				if !ok {
+					code = mqtt.ReturnCodeBadUserCredentials
+				}
+				c.prefix = prefix
+			}
+			c.send(func(tx *mqtt.Tx) error {
+				return tx.WriteConnack(mqtt.VariablesConnack{ReturnCode: code})
+			})
+			if code != mqtt.ReturnCodeConnAccepted {
+				return
+			}
+			keepAlive = time.Duration(vc.KeepAlive) * time.Second
+			connected = true
+			b.mu.Lock()
+			b.clients[c] = struct{}{}
+			b.mu.Unlock()
+			defer func() {
+				b.mu.Lock()
+				delete(b.clients, c)
+				b.mu.Unlock()
+			}()
+
+		case mqtt.PacketPublish:
+			qos := hdr.Flags().QoS()
+			vp, _, err := decoder.DecodePublish(body, qos)
+			if err != nil {
+				return
+			}
+			topic := string(vp.TopicName)
+			payload, err := io.ReadAll(body)
+			if err != nil {
+				return
+			}
+			if qos == mqtt.QoS1 {
+				c.send(func(tx *mqtt.Tx) error { return tx.WriteIdentified(mqtt.PacketPuback, vp.PacketIdentifier) })
+			}
+			if !strings.HasPrefix(topic, c.prefix) {
+				log.Printf("mqtt broker: %s may not publish to %s", conn.RemoteAddr(), topic)
+				continue
+			}
+			b.route(topic, payload)
+
+		case mqtt.PacketSubscribe:
+			vs, _, err := decoder.DecodeSubscribe(body, hdr.RemainingLength)
+			if err != nil {
+				return
+			}
+			codes := make([]mqtt.QoSLevel, len(vs.TopicFilters))
+			c.mu.Lock()
+			for i, f := range vs.TopicFilters {
+				filter := string(f.TopicFilter)
+				if !strings.HasPrefix(filter, c.prefix) || validFilter(filter) != nil {
+					codes[i] = mqtt.QoSSubfail
+					continue
+				}
+				c.filter = append(c.filter, filter)
+			}
+			c.mu.Unlock()
+			c.send(func(tx *mqtt.Tx) error {
+				return tx.WriteSuback(mqtt.VariablesSuback{PacketIdentifier: vs.PacketIdentifier, ReturnCodes: codes})
+			})
+
+		case mqtt.PacketUnsubscribe:
+			vu, _, err := decoder.DecodeUnsubscribe(body, hdr.RemainingLength)
+			if err != nil {
+				return
+			}
+			c.mu.Lock()
+			for _, t := range vu.Topics {
+				for i, f := range c.filter {
+					if f == string(t) {
+						c.filter = append(c.filter[:i], c.filter[i+1:]...)
+						break
+					}
+				}
+			}
+			c.mu.Unlock()
+			c.send(func(tx *mqtt.Tx) error { return tx.WriteIdentified(mqtt.PacketUnsuback, vu.PacketIdentifier) })
+
+		case mqtt.PacketPingreq:
+			c.send(func(tx *mqtt.Tx) error { return tx.WriteSimple(mqtt.PacketPingresp) })
+
+		case mqtt.PacketDisconnect:
+			return
+		}
+
+		// Skip whatever the packet carried that was not consumed above.
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return
+		}
+	}
+}
+
+func (c *brokerConn) send(write func(tx *mqtt.Tx) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if err := write(&c.tx); err != nil {
+		c.conn.Close()
+	}
+}
+
+// route delivers a message to every client with a matching subscription.
+func (b *Broker) route(topic string, payload []byte) {
+	b.mu.Lock()
+	var targets []*brokerConn
+	for c := range b.clients {
+		c.mu.Lock()
+		for _, f := range c.filter {
+			if TopicMatch(f, topic) {
+				targets = append(targets, c)
+				break
+			}
+		}
+		c.mu.Unlock()
+	}
+	b.mu.Unlock()
+
+	vp := mqtt.VariablesPublish{TopicName: []byte(topic)}
+	for _, c := range targets {
+		c.send(func(tx *mqtt.Tx) error { return tx.WritePublishPayload(publishHeader, vp, payload) })
+	}
+}
+
+func validFilter(filter string) error {
+	levels := strings.Split(filter, "/")
+	for i, l := range levels {
+		if l == "#" && i != len(levels)-1 {
+			return fmt.Errorf("# must be the last level of %q", filter)
+		}
+		if l != "+" && l != "#" && strings.ContainsAny(l, "+#") {
+			return fmt.Errorf("wildcards must fill a whole level of %q", filter)
+		}
+	}
+	return nil
+}
+
+// TopicMatch reports whether topic matches the subscription filter.
+func TopicMatch(filter, topic string) bool {
+	fl := strings.Split(filter, "/")
+	tl := strings.Split(topic, "/")
+	for i, f := range fl {
+		if f == "#" {
+			return true
+		}
+		if i >= len(tl) || (f != "+" && f != tl[i]) {
+			return false
+		}
+	}
+	return len(fl) == len(tl)
+}
@@ -0,0 +1,218 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"my-smart-farm/alerts"
+	"my-smart-farm/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrRateLimited is returned when a channel sent the same alert in the same
+// state too recently.
+var ErrRateLimited = errors.New("channel rate limited")
+
+// Dispatcher fans alert transitions out to every enabled channel. Delivery
+// runs in the background so slow channels never hold up ingestion.
+type Dispatcher struct {
+	db     *gorm.DB
+	client *http.Client
+
+	// MaxAttempts and Backoff control retries; the wait doubles after each
+	// failed attempt.
+	MaxAttempts int
+	Backoff     time.Duration
+
+	queue chan Event
+
+	mu       sync.Mutex
+	lastSent map[sentKey]sent
+}
+
+// sentKey identifies one alert on one channel for rate limiting.
+type sentKey struct {
+	channelID uint
+	deviceID  string
+	ruleID    uint
+	message   string
+}
+
+type sent struct {
+	at    time.Time
+	state string
+}
+
+func NewDispatcher(db *gorm.DB) *Dispatcher {
+	return &Dispatcher{
+		db:          db,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 4,
+		Backoff:     2 * time.Second,
+		queue:       make(chan Event, 100),
+		lastSent:    make(map[sentKey]sent),
+	}
+}
+
+// Start delivers queued events until ctx is done.
+func (d *Dispatcher) Start(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-d.queue:
+				d.Broadcast(ctx, ev)
+			}
+		}
+	}()
+}
+
+// AlertHook queues firing and resolved transitions for delivery. Pending
+// alerts are not announced.
+func (d *Dispatcher) AlertHook(t alerts.Transition) {
+	if t.To != models.AlertFiring && t.To != models.AlertResolved {
+		return
+	}
+	ev := Event{
+		State:     t.To,
+		DeviceID:  t.Alert.DeviceID,
+		RuleID:    t.Alert.RuleID,
+		RuleName:  t.Alert.Rule.Name,
+		Metric:    t.Alert.Rule.Metric,
+		Operator:  t.Alert.Rule.Operator,
+		Threshold: t.Alert.Rule.Value,
+		Value:     t.Alert.Value,
+		Message:   t.Alert.Message,
+		Time:      time.Now(),
+	}
+	if t.Alert.ResolvedAt != nil {
+		ev.Time = *t.Alert.ResolvedAt
+	} else if t.Alert.FiredAt != nil {
+		ev.Time = *t.Alert.FiredAt
+	}
+	d.Enqueue(ev)
+}
+
+// Enqueue queues an event without blocking; it is dropped if the queue is full.
+func (d *Dispatcher) Enqueue(ev Event) {
+	select {
+	case d.queue <- ev:
+	default:
+		log.Println("notify: queue full, dropping event for", ev.DeviceID)
+	}
+}
+
+// Broadcast delivers ev to every enabled channel.
+func (d *Dispatcher) Broadcast(ctx context.Context, ev Event) {
+	var channels []models.NotificationChannel
+	if err := d.db.Where("enabled = ?", true).Find(&channels).Error; err != nil {
+		log.Println("notify: load channels:", err)
+		return
+	}
+	var wg sync.WaitGroup
+	for _, ch := range channels {
+		wg.Add(1)
+		go func(ch models.NotificationChannel) {
+			defer wg.Done()
+			if err := d.Deliver(ctx, ch, ev); err != nil && !errors.Is(err, ErrRateLimited) {
+				log.Printf("notify: channel %d (%s): %v", ch.ID, ch.Kind, err)
+			}
+		}(ch)
+	}
+	wg.Wait()
+}
+
+// Deliver renders ev for ch and sends it, retrying with backoff. The
+// outcome is recorded on the channel row.
+func (d *Dispatcher) Deliver(ctx context.Context, ch models.NotificationChannel, ev Event) error {
+	if !d.allow(ch, ev) {
+		return ErrRateLimited
+	}
+	err := d.send(ctx, ch, ev)
+	if err == nil {
+		d.record(ch, ev)
+	}
+
+	updates := map[string]interface{}{"last_error": ""}
+	if err != nil {
+		updates["last_error"] = err.Error()
+	} else {
+		updates["last_sent_at"] = time.Now()
+	}
+	d.db.Model(&models.NotificationChannel{}).Where("id = ?", ch.ID).Updates(updates)
+	return err
+}
+
+func (d *Dispatcher) send(ctx context.Context, ch models.NotificationChannel, ev Event) error {
+	n, err := New(ch, d.client)
+	if err != nil {
+		return err
+	}
+	msg, err := Render(ch.Template, ev)
+	if err != nil {
+		return err
+	}
+
+	wait := d.Backoff
+	for attempt := 1; ; attempt++ {
+		err = n.Send(ctx, msg)
+		var status *StatusError
+		if err == nil || attempt >= d.MaxAttempts || errors.As(err, &status) && !status.Temporary() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// allow applies the channel's minimum interval between messages about the
+// same alert. A change of state, such as firing to resolved, always goes
+// through.
+func (d *Dispatcher) allow(ch models.NotificationChannel, ev Event) bool {
+	if ch.MinIntervalSeconds <= 0 {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	last, ok := d.lastSent[keyOf(ch, ev)]
+	return !ok || last.state != ev.State ||
+		time.Since(last.at) >= time.Duration(ch.MinIntervalSeconds)*time.Second
+}
+
+// record starts the channel's interval for the alert after a successful send.
+func (d *Dispatcher) record(ch models.NotificationChannel, ev Event) {
+	if ch.MinIntervalSeconds <= 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastSent[keyOf(ch, ev)] = sent{at: time.Now(), state: ev.State}
+}
+
+func keyOf(ch models.NotificationChannel, ev Event) sentKey {
+	return sentKey{channelID: ch.ID, deviceID: ev.DeviceID, ruleID: ev.RuleID, message: ev.Message}
+}
+
+// SendTest sends a sample event to ch immediately, bypassing rate limits.
+func (d *Dispatcher) SendTest(ctx context.Context, ch models.NotificationChannel) error {
+	return d.send(ctx, ch, Event{
+		State:     "test",
+		DeviceID:  "sensor-001",
+		RuleName:  "Test notification",
+		Metric:    "soil",
+		Operator:  "<",
+		Threshold: 25,
+		Value:     21.5,
+		Time:      time.Now(),
+	})
+}
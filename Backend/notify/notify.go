@@ -0,0 +1,258 @@
+// Package notify delivers alert notifications through webhook, SMTP email
+// and LINE channels with per-channel rate limiting and retries.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"my-smart-farm/models"
+)
+
+// Event is the data available to message templates.
+type Event struct {
+	State     string  `json:"state"`
+	DeviceID  string  `json:"device_id"`
+	RuleID    uint    `json:"rule_id"`
+	RuleName  string  `json:"rule_name"`
+	Metric    string  `json:"metric"`
+	Operator  string  `json:"operator"`
+	Threshold float64 `json:"threshold"`
+	Value     float64 `json:"value"`
+	// Message describes system alerts, which have no metric or threshold.
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Message is a rendered notification.
+type Message struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	Event   Event  `json:"event"`
+}
+
+// Notifier sends a message over one channel.
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+const (
+	defaultSubject = `[{{.State}}] {{if .RuleName}}{{.RuleName}}{{else if .Message}}{{.Message}}{{else}}{{.Metric}} {{.Operator}} {{.Threshold}}{{end}} on {{.DeviceID}}`
+	defaultText    = `{{.DeviceID}}: {{if .Message}}{{.Message}}{{else}}{{.Metric}} is {{printf "%.1f" .Value}} (rule {{.Operator}} {{.Threshold}}){{end}}, {{.State}} at {{.Time.Format "2006-01-02 15:04"}}`
+)
+
+var subjectTemplate = template.Must(template.New("subject").Parse(defaultSubject))
+
+// oneLine keeps rule names and messages from breaking the subject header.
+var oneLine = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// Render builds the message for an event using the channel's template, or
+// the default template when it is empty.
+func Render(tmpl string, ev Event) (Message, error) {
+	if tmpl == "" {
+		tmpl = defaultText
+	}
+	t, err := template.New("text").Parse(tmpl)
+	if err != nil {
+		return Message{}, err
+	}
+	var subject, text strings.Builder
+	if err := subjectTemplate.Execute(&subject, ev); err != nil {
+		return Message{}, err
+	}
+	if err := t.Execute(&text, ev); err != nil {
+		return Message{}, err
+	}
+	return Message{Subject: oneLine.Replace(subject.String()), Text: text.String(), Event: ev}, nil
+}
+
+// New builds the notifier for a channel.
+func New(ch models.NotificationChannel, client *http.Client) (Notifier, error) {
+	switch ch.Kind {
+	case models.ChannelWebhook:
+		if ch.URL == "" {
+			return nil, errors.New("webhook channel needs a url")
+		}
+		return &Webhook{URL: ch.URL, Client: client}, nil
+	case models.ChannelEmail:
+		if ch.URL == "" || ch.From == "" || ch.To == "" {
+			return nil, errors.New("email channel needs url (smtp host:port), from and to")
+		}
+		return &Email{
+			Addr:     ch.URL,
+			Username: ch.Username,
+			Password: ch.Token,
+			From:     ch.From,
+			To:       splitList(ch.To),
+		}, nil
+	case models.ChannelLINE:
+		if ch.Token == "" {
+			return nil, errors.New("line channel needs a token")
+		}
+		return &LINE{Token: ch.Token, To: ch.To, Endpoint: ch.URL, Client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown channel kind %q", ch.Kind)
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Webhook POSTs the message as JSON.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+func (w *Webhook) Send(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doRequest(w.Client, req)
+}
+
+// Email sends the message through an SMTP server.
+type Email struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (e *Email) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if e.Username != "" {
+		host, _, _ := strings.Cut(e.Addr, ":")
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", e.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(e.To, ", "))
+	// Rule names are often Thai, which headers cannot carry raw.
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", oneLine.Replace(msg.Subject)))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	buf.WriteString(msg.Text)
+	buf.WriteString("\r\n")
+
+	// net/smtp has no context support; run it so ctx can abandon the wait.
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(e.Addr, auth, e.From, e.To, buf.Bytes()) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+const (
+	lineNotifyEndpoint = "https://notify-api.line.me/api/notify"
+	linePushEndpoint   = "https://api.line.me/v2/bot/message/push"
+)
+
+// LINE sends the message with LINE Notify, or with a Messaging API push
+// when To names a user or group.
+type LINE struct {
+	Token    string
+	To       string
+	Endpoint string
+	Client   *http.Client
+}
+
+func (l *LINE) Send(ctx context.Context, msg Message) error {
+	text := msg.Subject + "\n" + msg.Text
+	var req *http.Request
+	var err error
+	if l.To == "" {
+		endpoint := l.Endpoint
+		if endpoint == "" {
+			endpoint = lineNotifyEndpoint
+		}
+		form := url.Values{"message": {text}}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		endpoint := l.Endpoint
+		if endpoint == "" {
+			endpoint = linePushEndpoint
+		}
+		body, _ := json.Marshal(map[string]any{
+			"to":       l.To,
+			"messages": []map[string]string{{"type": "text", "text": text}},
+		})
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "Bearer "+l.Token)
+	return doRequest(l.Client, req)
+}
+
+// StatusError is a non-2xx response from a webhook or LINE endpoint.
+type StatusError struct {
+	Host   string
+	Code   int
+	Status string
+	Body   string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %s %s", e.Host, e.Status, e.Body)
+}
+
+// Temporary reports whether the request may succeed if retried: other
+// client errors mean the channel itself is misconfigured.
+func (e *StatusError) Temporary() bool {
+	return e.Code/100 != 4 || e.Code == http.StatusTooManyRequests
+}
+
+func doRequest(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &StatusError{
+			Host:   req.URL.Host,
+			Code:   resp.StatusCode,
+			Status: resp.Status,
+			Body:   strings.TrimSpace(string(body)),
+		}
+	}
+	return nil
+}
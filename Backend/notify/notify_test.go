@@ -0,0 +1,237 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"my-smart-farm/models"
+	"my-smart-farm/store/storetest"
+)
+
+var testEvent = Event{
+	State:     models.AlertFiring,
+	DeviceID:  "sensor-001",
+	RuleName:  "Dry bed",
+	Metric:    "soil",
+	Operator:  "<",
+	Threshold: 25,
+	Value:     21.5,
+	Time:      time.Date(2025, 4, 4, 6, 0, 0, 0, time.UTC),
+}
+
+func TestWebhookAndLINE(t *testing.T) {
+	var got []*http.Request
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		got = append(got, r)
+		if r.Header.Get("Content-Type") == "application/json" {
+			var m map[string]any
+			json.NewDecoder(r.Body).Decode(&m)
+			b, _ := json.Marshal(m)
+			bodies = append(bodies, string(b))
+		} else {
+			bodies = append(bodies, r.PostForm.Get("message"))
+		}
+	}))
+	defer srv.Close()
+
+	msg, err := Render("{{.DeviceID}} soil {{.Value}}", testEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "[firing] Dry bed on sensor-001" || msg.Text != "sensor-001 soil 21.5" {
+		t.Fatalf("bad render: %+v", msg)
+	}
+
+	ctx := context.Background()
+	notifiers := []Notifier{
+		&Webhook{URL: srv.URL},
+		&LINE{Token: "tok", Endpoint: srv.URL},
+		&LINE{Token: "tok", To: "U123", Endpoint: srv.URL},
+	}
+	for _, n := range notifiers {
+		if err := n.Send(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !strings.Contains(bodies[0], `"device_id":"sensor-001"`) {
+		t.Error("webhook body lacks event:", bodies[0])
+	}
+	if got[1].Header.Get("Authorization") != "Bearer tok" || !strings.Contains(bodies[1], "sensor-001 soil 21.5") {
+		t.Error("bad LINE Notify request:", bodies[1])
+	}
+	if !strings.Contains(bodies[2], `"to":"U123"`) {
+		t.Error("bad LINE push request:", bodies[2])
+	}
+}
+
+// fakeSMTP accepts one message and returns its DATA section.
+func fakeSMTP(t *testing.T) (addr string, data <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(chan string, 1)
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		var body strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					out <- body.String()
+					reply("250 OK")
+					continue
+				}
+				body.WriteString(line)
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case cmd == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return ln.Addr().String(), out
+}
+
+func TestEmail(t *testing.T) {
+	addr, data := fakeSMTP(t)
+	n, err := New(models.NotificationChannel{
+		Kind: models.ChannelEmail,
+		URL:  addr,
+		From: "farm@example.com",
+		To:   "agronomist@example.com, ops@example.com",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, _ := Render("", testEvent)
+	if err := n.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	mail := <-data
+	if !strings.Contains(mail, "Subject: [firing] Dry bed on sensor-001") || !strings.Contains(mail, "soil is 21.5") {
+		t.Error("unexpected mail:\n", mail)
+	}
+
+	addr, data = fakeSMTP(t)
+	n, _ = New(models.NotificationChannel{Kind: models.ChannelEmail, URL: addr, From: "farm@example.com", To: "ops@example.com"}, nil)
+	ev := testEvent
+	ev.RuleName = "ดินแห้ง\r\nBcc: someone@example.com"
+	msg, _ = Render("", ev)
+	if err := n.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	mail = <-data
+	if !strings.Contains(mail, "Subject: =?UTF-8?q?") || strings.Contains(mail, "\r\nBcc:") {
+		t.Error("subject not encoded on one line:\n", mail)
+	}
+}
+
+func TestDispatcherRetryAndRateLimit(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	db := storetest.SQLite(t, &models.NotificationChannel{})
+	ch := models.NotificationChannel{Kind: models.ChannelWebhook, URL: srv.URL, MinIntervalSeconds: 60, Enabled: true}
+	db.Create(&ch)
+
+	d := NewDispatcher(db)
+	d.Backoff = time.Millisecond
+	ctx := context.Background()
+	if err := d.Deliver(ctx, ch, testEvent); err != nil {
+		t.Fatal("delivery should succeed on third attempt:", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("got %d attempts, want 3", calls.Load())
+	}
+	if err := d.Deliver(ctx, ch, testEvent); err != ErrRateLimited {
+		t.Error("second delivery within interval should be rate limited, got", err)
+	}
+
+	other := testEvent
+	other.DeviceID = "sensor-002"
+	if err := d.Deliver(ctx, ch, other); err != nil {
+		t.Error("another alert should not share the interval:", err)
+	}
+	resolved := testEvent
+	resolved.State = models.AlertResolved
+	if err := d.Deliver(ctx, ch, resolved); err != nil {
+		t.Error("resolution should not be rate limited:", err)
+	}
+}
+
+func TestDispatcherFailuresDoNotUseInterval(t *testing.T) {
+	var calls atomic.Int32
+	var status atomic.Int32
+	status.Store(http.StatusBadRequest)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+
+	db := storetest.SQLite(t, &models.NotificationChannel{})
+	ch := models.NotificationChannel{Kind: models.ChannelWebhook, URL: srv.URL, MinIntervalSeconds: 60, Enabled: true}
+	db.Create(&ch)
+
+	d := NewDispatcher(db)
+	d.Backoff = time.Millisecond
+	ctx := context.Background()
+	if err := d.Deliver(ctx, ch, testEvent); err == nil {
+		t.Fatal("400 should fail")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("400 retried: %d attempts", calls.Load())
+	}
+
+	calls.Store(0)
+	status.Store(http.StatusTooManyRequests)
+	if err := d.Deliver(ctx, ch, testEvent); err == nil || err == ErrRateLimited {
+		t.Fatal("429 should fail after retries, got", err)
+	}
+	if calls.Load() != int32(d.MaxAttempts) {
+		t.Errorf("429 got %d attempts, want %d", calls.Load(), d.MaxAttempts)
+	}
+
+	status.Store(http.StatusOK)
+	if err := d.Deliver(ctx, ch, testEvent); err != nil {
+		t.Error("failed sends should not start the interval:", err)
+	}
+}
@@ -0,0 +1,93 @@
+// Package quality grades incoming readings so sensor glitches do not end up
+// in history, charts, alerts or automations.
+package quality
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"my-smart-farm/models"
+)
+
+// Limits are the checks for one metric.
+type Limits struct {
+	// Readings below Min or above Max cannot come from the sensor and are
+	// rejected. Values at the ends of the scale are real: bone-dry soil
+	// reads 0 % and fog reads 100 % humidity.
+	Min float64 `yaml:"min"`
+	Max float64 `yaml:"max"`
+	// Readings outside PlausibleMin..PlausibleMax are possible but unusual
+	// for a greenhouse and marked suspect.
+	PlausibleMin float64 `yaml:"plausible_min"`
+	PlausibleMax float64 `yaml:"plausible_max"`
+	// MaxRatePerMinute is the largest believable change per minute since
+	// the previous reading; faster changes are suspect. Zero disables it.
+	MaxRatePerMinute float64 `yaml:"max_rate_per_minute"`
+}
+
+// Validate checks that the limits are ordered.
+func (l Limits) Validate() error {
+	if !(l.Min <= l.PlausibleMin && l.PlausibleMin <= l.PlausibleMax && l.PlausibleMax <= l.Max) {
+		return fmt.Errorf("want min <= plausible_min <= plausible_max <= max, got %g, %g, %g, %g",
+			l.Min, l.PlausibleMin, l.PlausibleMax, l.Max)
+	}
+	if l.MaxRatePerMinute < 0 {
+		return fmt.Errorf("max_rate_per_minute must not be negative")
+	}
+	return nil
+}
+
+// Rules holds the limits of each metric.
+type Rules struct {
+	Temperature Limits `yaml:"temperature"`
+	Humidity    Limits `yaml:"humidity"`
+	Soil        Limits `yaml:"soil"`
+}
+
+// DefaultRules suit a DHT22 and a capacitive soil probe in a greenhouse. A
+// DHT22 reports -40 °C and 0 % humidity, the bottom of its scales, when it
+// glitches, so those values are rejected; humidity below 5 % is only
+// suspect. Soil at 0 % is kept as suspect: an unplugged probe also reads
+// 0 %, but so does the driest bed, which must still reach irrigation.
+func DefaultRules() Rules {
+	return Rules{
+		Temperature: Limits{Min: -39.9, Max: 80, PlausibleMin: 0, PlausibleMax: 50, MaxRatePerMinute: 3},
+		Humidity:    Limits{Min: 0.1, Max: 100, PlausibleMin: 5, PlausibleMax: 100, MaxRatePerMinute: 20},
+		Soil:        Limits{Min: 0, Max: 100, PlausibleMin: 0.1, PlausibleMax: 100, MaxRatePerMinute: 25},
+	}
+}
+
+// Grade returns the quality of data and, unless it is ok, why. prev is the
+// device's previous accepted reading, or nil when there is none.
+func (r Rules) Grade(data, prev *models.SensorData) (quality, note string) {
+	var rejected, suspect []string
+	check := func(metric string, l Limits, value float64, last func(*models.SensorData) float64) {
+		if value < l.Min || value > l.Max || math.IsNaN(value) {
+			rejected = append(rejected, fmt.Sprintf("%s %g outside sensor range [%g, %g]", metric, value, l.Min, l.Max))
+			return
+		}
+		if value < l.PlausibleMin || value > l.PlausibleMax {
+			suspect = append(suspect, fmt.Sprintf("%s %g outside plausible range [%g, %g]", metric, value, l.PlausibleMin, l.PlausibleMax))
+		}
+		if prev != nil && l.MaxRatePerMinute > 0 {
+			// Readings moments apart would turn sensor noise into huge
+			// rates, so the change is spread over at least a minute.
+			minutes := math.Max(data.Timestamp.Sub(prev.Timestamp).Minutes(), 1)
+			if rate := math.Abs(value-last(prev)) / minutes; rate > l.MaxRatePerMinute {
+				suspect = append(suspect, fmt.Sprintf("%s changed %.1f per minute, limit %g", metric, rate, l.MaxRatePerMinute))
+			}
+		}
+	}
+	check("temperature", r.Temperature, data.Temperature, func(d *models.SensorData) float64 { return d.Temperature })
+	check("humidity", r.Humidity, data.Humidity, func(d *models.SensorData) float64 { return d.Humidity })
+	check("soil", r.Soil, data.Soil, func(d *models.SensorData) float64 { return d.Soil })
+
+	switch {
+	case len(rejected) > 0:
+		return models.QualityRejected, strings.Join(rejected, "; ")
+	case len(suspect) > 0:
+		return models.QualitySuspect, strings.Join(suspect, "; ")
+	}
+	return models.QualityOK, ""
+}
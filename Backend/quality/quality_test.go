@@ -0,0 +1,56 @@
+package quality
+
+import (
+	"testing"
+	"time"
+
+	"my-smart-farm/models"
+)
+
+func TestGrade(t *testing.T) {
+	rules := DefaultRules()
+	at := time.Date(2025, 4, 4, 6, 0, 0, 0, time.Local)
+	prev := &models.SensorData{Temperature: 28, Humidity: 70, Soil: 40, Timestamp: at}
+	reading := func(temp, hum, soil float64, after time.Duration) *models.SensorData {
+		return &models.SensorData{Temperature: temp, Humidity: hum, Soil: soil, Timestamp: at.Add(after)}
+	}
+	for _, tc := range []struct {
+		name string
+		data *models.SensorData
+		prev *models.SensorData
+		want string
+	}{
+		{"normal", reading(28.5, 68, 39, time.Minute), prev, models.QualityOK},
+		{"first reading", reading(28.5, 68, 39, 0), nil, models.QualityOK},
+		{"dht22 glitch", reading(-40, 0, 39, time.Minute), prev, models.QualityRejected},
+		{"over range", reading(28, 101, 39, time.Minute), prev, models.QualityRejected},
+		{"bone-dry soil", reading(28, 70, 0, 5*time.Minute), prev, models.QualitySuspect},
+		{"dry air", reading(28, 3, 40, time.Hour), prev, models.QualitySuspect},
+		{"humidity glitch", reading(28, 0, 40, time.Hour), prev, models.QualityRejected},
+		{"fog", reading(28, 100, 40, time.Hour), prev, models.QualityOK},
+		{"below range", reading(28, 70, -1, time.Minute), prev, models.QualityRejected},
+		{"implausible", reading(55, 70, 39, time.Hour), prev, models.QualitySuspect},
+		{"jump", reading(38, 70, 40, 2*time.Minute), prev, models.QualitySuspect},
+		{"slow drift", reading(38, 70, 40, time.Hour), prev, models.QualityOK},
+		{"noise moments apart", reading(29, 70, 40, time.Second), prev, models.QualityOK},
+	} {
+		got, note := rules.Grade(tc.data, tc.prev)
+		if got != tc.want {
+			t.Errorf("%s: got %s (%s), want %s", tc.name, got, note, tc.want)
+		}
+		if (got == models.QualityOK) != (note == "") {
+			t.Errorf("%s: quality %s with note %q", tc.name, got, note)
+		}
+	}
+}
+
+func TestLimitsValidate(t *testing.T) {
+	for _, l := range []Limits{DefaultRules().Temperature, DefaultRules().Humidity, DefaultRules().Soil} {
+		if err := l.Validate(); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := (Limits{Min: 0, Max: 100, PlausibleMin: 10, PlausibleMax: 120}).Validate(); err == nil {
+		t.Error("plausible range beyond max accepted")
+	}
+}
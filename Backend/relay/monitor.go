@@ -0,0 +1,203 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"my-smart-farm/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrRelayOffline is returned by Monitor.Usable for relays that have not
+// been heard from within the offline timeout and do not answer a probe.
+var ErrRelayOffline = errors.New("relay offline")
+
+// OfflineAlert is the message of the alert raised when an automation
+// refuses an offline relay. It resolves once the relay is back.
+const OfflineAlert = "relay offline, automation skipped"
+
+// Alerter raises and clears system alerts; *alerts.Evaluator implements it.
+type Alerter interface {
+	Raise(deviceID, message string) error
+	Clear(deviceID, message string) error
+}
+
+// StatusChange describes a relay moving between unknown, online, stale and
+// offline. From is empty the first time a relay is judged.
+type StatusChange struct {
+	DeviceID string    `json:"device_id"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	At       time.Time `json:"at"`
+}
+
+// Monitor judges relay liveness from heartbeats. A relay is stale once it
+// has been silent for StaleAfter and offline after OfflineAfter. Relays
+// with an IP are probed once stale, since the relay firmware only answers
+// requests and never calls in by itself.
+type Monitor struct {
+	db   *gorm.DB
+	http *http.Client
+
+	StaleAfter   time.Duration
+	OfflineAfter time.Duration
+	// Tick is how often stored statuses are refreshed.
+	Tick time.Duration
+	// Alerts, when set, receives OfflineAlert for refused relays.
+	Alerts Alerter
+
+	mu        sync.Mutex
+	listeners []func(StatusChange)
+}
+
+func NewMonitor(db *gorm.DB) *Monitor {
+	return &Monitor{
+		db:           db,
+		StaleAfter:   2 * time.Minute,
+		OfflineAfter: 5 * time.Minute,
+		Tick:         15 * time.Second,
+		http: &http.Client{
+			Timeout: 2 * time.Second,
+		},
+	}
+}
+
+// OnChange registers fn to be called for every relay status change.
+func (m *Monitor) OnChange(fn func(StatusChange)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, fn)
+}
+
+// StatusAt judges a relay last heard from at lastSeen. A relay never heard
+// from is unknown rather than offline.
+func (m *Monitor) StatusAt(lastSeen *time.Time, now time.Time) string {
+	switch {
+	case lastSeen == nil:
+		return models.RelayUnknown
+	case now.Sub(*lastSeen) >= m.OfflineAfter:
+		return models.RelayOffline
+	case now.Sub(*lastSeen) >= m.StaleAfter:
+		return models.RelayStale
+	}
+	return models.RelayOnline
+}
+
+// Start refreshes relay statuses until ctx is done.
+func (m *Monitor) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(m.Tick)
+		defer ticker.Stop()
+		for {
+			if err := m.Check(time.Now()); err != nil {
+				log.Println("relay monitor:", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Check probes silent relays, re-judges every relay, stores changed
+// statuses and notifies listeners of the changes.
+func (m *Monitor) Check(now time.Time) error {
+	var relays []models.RelayDevice
+	if err := m.db.Find(&relays).Error; err != nil {
+		return err
+	}
+	for i, r := range relays {
+		if m.StatusAt(r.LastHeartbeat, now) == models.RelayOnline {
+			continue
+		}
+		if seen, ok := m.probe(r); ok {
+			relays[i].LastHeartbeat = &seen
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var changes []StatusChange
+	for _, r := range relays {
+		status := m.StatusAt(r.LastHeartbeat, now)
+		if status == r.Status {
+			continue
+		}
+		err := m.db.Model(&models.RelayDevice{}).Where("device_id = ?", r.DeviceID).Updates(map[string]interface{}{
+			"status":            status,
+			"status_changed_at": now,
+		}).Error
+		if err != nil {
+			return err
+		}
+		changes = append(changes, StatusChange{DeviceID: r.DeviceID, From: r.Status, To: status, At: now})
+	}
+
+	for _, c := range changes {
+		if c.To != models.RelayOffline && m.Alerts != nil {
+			if err := m.Alerts.Clear(c.DeviceID, OfflineAlert); err != nil {
+				log.Println("relay monitor: clear alert:", err)
+			}
+		}
+		for _, fn := range m.listeners {
+			fn(c)
+		}
+	}
+	return nil
+}
+
+// Usable returns ErrRelayOffline if the relay is offline right now and does
+// not answer a probe either, so automations can refuse to rely on it, and
+// raises OfflineAlert. Relays never heard from are given the benefit of the
+// doubt.
+func (m *Monitor) Usable(deviceID string) error {
+	var relay models.RelayDevice
+	if err := m.db.First(&relay, "device_id = ?", deviceID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrDeviceNotFound
+		}
+		return err
+	}
+	if m.StatusAt(relay.LastHeartbeat, time.Now()) != models.RelayOffline {
+		return nil
+	}
+	if _, ok := m.probe(relay); !ok {
+		if m.Alerts != nil {
+			if err := m.Alerts.Raise(deviceID, OfflineAlert); err != nil {
+				log.Println("relay monitor: raise alert:", err)
+			}
+		}
+		return ErrRelayOffline
+	}
+	return nil
+}
+
+// probe asks a relay with an IP for its state and records a heartbeat when
+// it answers. Relays reached over MQTT keep alive through their reports.
+func (m *Monitor) probe(r models.RelayDevice) (time.Time, bool) {
+	if r.IP == "" {
+		return time.Time{}, false
+	}
+	resp, err := m.http.Get(fmt.Sprintf("http://%s/relay/status", r.IP))
+	if err != nil {
+		return time.Time{}, false
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return time.Time{}, false
+	}
+	now := time.Now()
+	err = m.db.Model(&models.RelayDevice{}).Where("device_id = ?", r.DeviceID).Update("last_heartbeat", now).Error
+	if err != nil {
+		log.Println("relay monitor: record probe:", err)
+	}
+	return now, true
+}
@@ -0,0 +1,55 @@
+package relay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"my-smart-farm/models"
+	"my-smart-farm/store/storetest"
+)
+
+func TestMonitorProbesSilentRelays(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/relay/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("off"))
+	}))
+	defer srv.Close()
+
+	db := storetest.SQLite(t, &models.RelayDevice{})
+	stale := time.Now().Add(-time.Hour)
+	db.Create(&models.RelayDevice{DeviceID: "alive", IP: strings.TrimPrefix(srv.URL, "http://"), Updated: stale, LastHeartbeat: &stale})
+	db.Create(&models.RelayDevice{DeviceID: "gone", IP: "127.0.0.1:1", Updated: stale, LastHeartbeat: &stale})
+	db.Create(&models.RelayDevice{DeviceID: "new", Updated: stale})
+
+	m := NewMonitor(db)
+	if got := m.StatusAt(nil, time.Now()); got != models.RelayUnknown {
+		t.Errorf("never heard from = %s, want unknown", got)
+	}
+	if err := m.Usable("alive"); err != nil {
+		t.Errorf("relay answering probes refused: %v", err)
+	}
+	if err := m.Usable("gone"); err != ErrRelayOffline {
+		t.Errorf("silent relay = %v, want offline", err)
+	}
+	if err := m.Usable("new"); err != nil {
+		t.Errorf("unknown relay refused: %v", err)
+	}
+
+	if err := m.Check(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	var relays []models.RelayDevice
+	db.Order("device_id").Find(&relays)
+	want := map[string]string{"alive": models.RelayOnline, "gone": models.RelayOffline, "new": models.RelayUnknown}
+	for _, r := range relays {
+		if r.Status != want[r.DeviceID] {
+			t.Errorf("%s status %s, want %s", r.DeviceID, r.Status, want[r.DeviceID])
+		}
+	}
+}
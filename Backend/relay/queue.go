@@ -0,0 +1,197 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"my-smart-farm/models"
+
+	"gorm.io/gorm"
+)
+
+// Queue delivers relay commands in the background. Commands are persisted
+// as pending RelayCommand rows and retried with backoff until the device
+// accepts them, the attempts run out or they expire, so a relay on a weak
+// link still gets the command without holding up the caller.
+type Queue struct {
+	db     *gorm.DB
+	client *Client
+
+	// TTL is how long a command stays worth delivering.
+	TTL time.Duration
+	// MaxAttempts and Backoff control retries; the wait doubles after each
+	// failed attempt up to MaxBackoff.
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	// Poll is how often the queue looks for due commands when idle.
+	Poll time.Duration
+
+	wake chan struct{}
+}
+
+func NewQueue(db *gorm.DB, client *Client) *Queue {
+	return &Queue{
+		db:          db,
+		client:      client,
+		TTL:         2 * time.Minute,
+		MaxAttempts: 8,
+		Backoff:     time.Second,
+		MaxBackoff:  20 * time.Second,
+		Poll:        time.Second,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Enqueue stores a pending command for the device and returns it. Older
+// commands still pending for the same device are expired, so a late retry
+// can never undo a newer command.
+func (q *Queue) Enqueue(deviceID, action string, by Issuer) (*models.RelayCommand, error) {
+	if action != ActionOn && action != ActionOff {
+		return nil, ErrInvalidAction
+	}
+	if _, err := q.client.device(deviceID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expires := now.Add(q.TTL)
+	cmd := models.RelayCommand{
+		DeviceID:      deviceID,
+		Action:        action,
+		IssuedAt:      now,
+		IssuedBy:      by.Kind,
+		IssuerID:      by.ID,
+		Status:        models.CommandPending,
+		NextAttemptAt: &now,
+		ExpiresAt:     &expires,
+	}
+	err := q.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&cmd).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.RelayCommand{}).
+			Where("device_id = ? AND status = ? AND id < ?", deviceID, models.CommandPending, cmd.ID).
+			Updates(map[string]interface{}{
+				"status":          models.CommandExpired,
+				"error":           fmt.Sprintf("superseded by command %d", cmd.ID),
+				"next_attempt_at": nil,
+			}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return &cmd, nil
+}
+
+// Start delivers due commands until ctx is done. Commands left pending by
+// a previous run are picked up straight away.
+func (q *Queue) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(q.Poll)
+		defer ticker.Stop()
+		for {
+			if err := q.ProcessDue(ctx, time.Now()); err != nil {
+				log.Println("relay queue:", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-q.wake:
+			}
+		}
+	}()
+}
+
+// ProcessDue makes one attempt at every pending command that is due, and
+// expires those past their deadline. Devices are contacted concurrently.
+func (q *Queue) ProcessDue(ctx context.Context, now time.Time) error {
+	var due []models.RelayCommand
+	err := q.db.Where("status = ? AND next_attempt_at <= ?", models.CommandPending, now).
+		Order("id").Find(&due).Error
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for i := range due {
+		cmd := &due[i]
+		if cmd.ExpiresAt != nil && now.After(*cmd.ExpiresAt) {
+			cmd.Status = models.CommandExpired
+			cmd.NextAttemptAt = nil
+			q.save(cmd)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.deliver(ctx, cmd)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (q *Queue) deliver(ctx context.Context, cmd *models.RelayCommand) {
+	device, err := q.client.device(cmd.DeviceID)
+	if err != nil {
+		cmd.Status = models.CommandFailed
+		cmd.Error = err.Error()
+		cmd.NextAttemptAt = nil
+		q.save(cmd)
+		return
+	}
+
+	resp, err := q.client.attempt(ctx, device, cmd)
+	switch {
+	case cmd.Status == models.CommandDelivered:
+		cmd.NextAttemptAt = nil
+	case err == nil && resp.StatusCode/100 == 4:
+		// The device understood and refused; repeating will not help.
+		cmd.Status = models.CommandFailed
+		cmd.NextAttemptAt = nil
+	case cmd.Attempts >= q.MaxAttempts:
+		cmd.Status = models.CommandFailed
+		cmd.NextAttemptAt = nil
+	default:
+		next := time.Now().Add(q.backoff(cmd.Attempts))
+		cmd.NextAttemptAt = &next
+	}
+	q.save(cmd)
+}
+
+func (q *Queue) backoff(attempts int) time.Duration {
+	wait := q.Backoff
+	for i := 1; i < attempts && wait < q.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > q.MaxBackoff {
+		wait = q.MaxBackoff
+	}
+	return wait
+}
+
+// save writes the delivery state back. A failed or retried attempt is not
+// recorded over a command that was superseded while it was in flight.
+func (q *Queue) save(cmd *models.RelayCommand) {
+	tx := q.db.Model(cmd)
+	if cmd.Status != models.CommandDelivered {
+		tx = tx.Where("status = ?", models.CommandPending)
+	}
+	err := tx.Select(
+		"Status", "Attempts", "NextAttemptAt", "DeliveredAt",
+		"StatusCode", "Response", "Error", "LatencyMs",
+	).Updates(cmd).Error
+	if err != nil {
+		log.Println("relay queue: save command:", err)
+	}
+}
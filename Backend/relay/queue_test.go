@@ -0,0 +1,69 @@
+package relay
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"my-smart-farm/models"
+	"my-smart-farm/store/storetest"
+)
+
+func TestQueueRetryAndSupersede(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	db := storetest.SQLite(t, &models.RelayDevice{}, &models.RelayCommand{})
+	db.Create(&models.RelayDevice{DeviceID: "relay-001", IP: strings.TrimPrefix(srv.URL, "http://"), Updated: time.Now()})
+
+	q := NewQueue(db, NewClient(db))
+	ctx := context.Background()
+
+	stale, _ := q.Enqueue("relay-001", ActionOn, ByUser("alice"))
+	cmd, err := q.Enqueue("relay-001", ActionOff, ByUser("bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Enqueue("relay-404", ActionOn, ByUser("bob")); err != ErrDeviceNotFound {
+		t.Error("unknown relay should not be queued, got", err)
+	}
+
+	// Each pass runs after the backoff has elapsed.
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := q.ProcessDue(ctx, now); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(q.MaxBackoff)
+	}
+
+	db.First(stale, stale.ID)
+	if stale.Status != models.CommandExpired || stale.Attempts != 0 {
+		t.Errorf("superseded command should expire unsent, got %s after %d attempts", stale.Status, stale.Attempts)
+	}
+	db.First(cmd, cmd.ID)
+	if cmd.Status != models.CommandDelivered || cmd.Attempts != 3 {
+		t.Errorf("got %s after %d attempts, want delivered after 3", cmd.Status, cmd.Attempts)
+	}
+	var device models.RelayDevice
+	db.First(&device, "device_id = ?", "relay-001")
+	if device.State != ActionOff || device.StateChangedBy != "user:bob" {
+		t.Errorf("relay state %q by %q", device.State, device.StateChangedBy)
+	}
+
+	late, _ := q.Enqueue("relay-001", ActionOn, ByUser("bob"))
+	q.ProcessDue(ctx, time.Now().Add(q.TTL+time.Second))
+	db.First(late, late.ID)
+	if late.Status != models.CommandExpired {
+		t.Error("command past its TTL should expire, got", late.Status)
+	}
+}
@@ -0,0 +1,299 @@
+// Package relay sends on/off commands to registered relay devices over HTTP
+// and keeps an audit log of them.
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"my-smart-farm/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ActionOn  = "on"
+	ActionOff = "off"
+)
+
+var (
+	ErrInvalidAction  = errors.New("invalid action")
+	ErrDeviceNotFound = errors.New("device not found")
+)
+
+// maxLoggedResponse caps how much of a device's reply is kept in the audit log.
+const maxLoggedResponse = 512
+
+// Issuer identifies who or what sent a command.
+type Issuer struct {
+	Kind string // models.IssuerUser, IssuerSchedule or IssuerRule
+	ID   string
+}
+
+func ByUser(username string) Issuer {
+	return Issuer{Kind: models.IssuerUser, ID: username}
+}
+
+func BySchedule(id uint) Issuer {
+	return Issuer{Kind: models.IssuerSchedule, ID: strconv.FormatUint(uint64(id), 10)}
+}
+
+func ByRule(id uint) Issuer {
+	return Issuer{Kind: models.IssuerRule, ID: strconv.FormatUint(uint64(id), 10)}
+}
+
+func (i Issuer) String() string {
+	if i.ID == "" {
+		return i.Kind
+	}
+	return i.Kind + ":" + i.ID
+}
+
+// Response is what the relay device answered.
+type Response struct {
+	StatusCode int
+	Body       []byte
+}
+
+// StateChange describes a relay acknowledging an on or off command or
+// reporting its state.
+type StateChange struct {
+	DeviceID string    `json:"device_id"`
+	State    string    `json:"state"`
+	By       string    `json:"by"`
+	At       time.Time `json:"at"`
+}
+
+// Publisher sends relay commands over MQTT.
+type Publisher interface {
+	PublishRelay(deviceID, action string) error
+}
+
+// Client looks up a relay's registered IP and forwards commands to it.
+// Relays registered without an IP are reached through Publisher instead.
+type Client struct {
+	db   *gorm.DB
+	http *http.Client
+
+	// Publisher, when set, carries commands to relays that have no IP.
+	Publisher Publisher
+
+	mu        sync.RWMutex
+	listeners []func(StateChange)
+	attempts  []func(models.RelayCommand)
+}
+
+func NewClient(db *gorm.DB) *Client {
+	return &Client{
+		db: db,
+		http: &http.Client{
+			Timeout: 3 * time.Second,
+		},
+	}
+}
+
+// OnStateChange registers fn to be called whenever a relay's state changes.
+func (c *Client) OnStateChange(fn func(StateChange)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, fn)
+}
+
+// OnAttempt registers fn to be called with the outcome of every delivery
+// attempt.
+func (c *Client) OnAttempt(fn func(models.RelayCommand)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attempts = append(c.attempts, fn)
+}
+
+// Send asks the relay device to switch on or off once and waits for the
+// answer. A non-nil error without a response means the device could not be
+// reached. Every attempt on a registered device is recorded as a
+// RelayCommand, and an accepted command updates the device's last known
+// state. Commands that should survive a flaky link go through a Queue.
+func (c *Client) Send(ctx context.Context, deviceID, action string, by Issuer) (*Response, error) {
+	if action != ActionOn && action != ActionOff {
+		return nil, ErrInvalidAction
+	}
+	device, err := c.device(deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := models.RelayCommand{
+		DeviceID: device.DeviceID,
+		Action:   action,
+		IssuedAt: time.Now(),
+		IssuedBy: by.Kind,
+		IssuerID: by.ID,
+	}
+	resp, err := c.attempt(ctx, device, &cmd)
+	if err != nil || resp.StatusCode/100 != 2 {
+		cmd.Status = models.CommandFailed
+	}
+	if err := c.db.Create(&cmd).Error; err != nil {
+		log.Println("relay: record command:", err)
+	}
+	return resp, err
+}
+
+func (c *Client) device(deviceID string) (models.RelayDevice, error) {
+	var device models.RelayDevice
+	if err := c.db.First(&device, "device_id = ?", deviceID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return device, ErrDeviceNotFound
+		}
+		return device, err
+	}
+	return device, nil
+}
+
+// attempt sends cmd to the device once and fills in the outcome of the
+// attempt. On success cmd is marked delivered and the device state updated.
+func (c *Client) attempt(ctx context.Context, device models.RelayDevice, cmd *models.RelayCommand) (*Response, error) {
+	defer func() {
+		c.mu.RLock()
+		listeners := c.attempts
+		c.mu.RUnlock()
+		for _, fn := range listeners {
+			fn(*cmd)
+		}
+	}()
+
+	start := time.Now()
+	var resp *Response
+	var err error
+	viaMQTT := device.IP == "" && c.Publisher != nil
+	if viaMQTT {
+		resp, err = c.publish(device.DeviceID, cmd.Action)
+	} else {
+		resp, err = c.do(ctx, device.IP, cmd.Action)
+	}
+
+	cmd.Attempts++
+	cmd.LatencyMs = time.Since(start).Milliseconds()
+	cmd.StatusCode = 0
+	cmd.Response = ""
+	cmd.Error = ""
+	if err != nil {
+		cmd.Error = err.Error()
+		return nil, err
+	}
+	cmd.StatusCode = resp.StatusCode
+	body := resp.Body
+	if len(body) > maxLoggedResponse {
+		body = body[:maxLoggedResponse]
+	}
+	cmd.Response = string(body)
+	if resp.StatusCode/100 != 2 {
+		return resp, nil
+	}
+
+	cmd.Status = models.CommandDelivered
+	cmd.DeliveredAt = &start
+	if !viaMQTT {
+		// MQTT relays confirm their state separately through ReportState.
+		c.setState(device.DeviceID, cmd.Action, Issuer{Kind: cmd.IssuedBy, ID: cmd.IssuerID}.String(), start)
+	}
+	return resp, nil
+}
+
+// publish hands the command to the MQTT broker. The relay has not switched
+// yet, so the answer is 202 Accepted.
+func (c *Client) publish(deviceID, action string) (*Response, error) {
+	if err := c.Publisher.PublishRelay(deviceID, action); err != nil {
+		return nil, err
+	}
+	return &Response{StatusCode: http.StatusAccepted, Body: []byte("published over MQTT")}, nil
+}
+
+// ReportState records a state the relay reported itself, e.g. over MQTT.
+// The change is attributed to the latest delivered command asking for that
+// state within the last minute, or to the device when there is none. The
+// relay must be registered, either through /relay/register or as a relay in
+// the device registry; otherwise ErrDeviceNotFound is returned.
+func (c *Client) ReportState(deviceID, state string, at time.Time) error {
+	if state != ActionOn && state != ActionOff {
+		return ErrInvalidAction
+	}
+	if _, err := c.device(deviceID); errors.Is(err, ErrDeviceNotFound) {
+		// Relays reachable only over MQTT have no address to register;
+		// their registry entry stands in for it.
+		var registered int64
+		err := c.db.Model(&models.Device{}).
+			Where("device_id = ? AND kind = ?", deviceID, models.DeviceKindRelay).Count(&registered).Error
+		if err != nil {
+			return err
+		}
+		if registered == 0 {
+			return ErrDeviceNotFound
+		}
+		err = c.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.RelayDevice{
+			DeviceID: deviceID,
+			Updated:  at,
+		}).Error
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	by := "device"
+	var cmd []models.RelayCommand
+	c.db.Where("device_id = ? AND action = ? AND status = ? AND issued_at >= ?",
+		deviceID, state, models.CommandDelivered, at.Add(-time.Minute)).
+		Order("issued_at DESC").Limit(1).Find(&cmd)
+	if len(cmd) > 0 {
+		by = Issuer{Kind: cmd[0].IssuedBy, ID: cmd[0].IssuerID}.String()
+	}
+	c.setState(deviceID, state, by, at)
+	return nil
+}
+
+// setState stores the relay's acknowledged state and tells the listeners.
+func (c *Client) setState(deviceID, state, by string, at time.Time) {
+	err := c.db.Model(&models.RelayDevice{}).Where("device_id = ?", deviceID).Updates(map[string]interface{}{
+		"state":            state,
+		"state_changed_at": at,
+		"state_changed_by": by,
+		// Acknowledging a state proves the relay is alive.
+		"last_heartbeat": at,
+	}).Error
+	if err != nil {
+		log.Println("relay: update state:", err)
+	}
+
+	change := StateChange{DeviceID: deviceID, State: state, By: by, At: at}
+	c.mu.RLock()
+	listeners := c.listeners
+	c.mu.RUnlock()
+	for _, fn := range listeners {
+		fn(change)
+	}
+}
+
+func (c *Client) do(ctx context.Context, ip, action string) (*Response, error) {
+	url := fmt.Sprintf("http://%s/relay/%s", ip, action)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	return &Response{StatusCode: resp.StatusCode, Body: body}, nil
+}
@@ -0,0 +1,192 @@
+// Package retention compacts old raw sensor readings into hourly and daily
+// rollups and deletes the raw rows once they are summarised.
+package retention
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"my-smart-farm/aggregate"
+	"my-smart-farm/models"
+	"my-smart-farm/store"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const day = 24 * time.Hour
+
+// Status reports the outcome of the most recent compaction run.
+type Status struct {
+	LastRun       time.Time `json:"last_run"`
+	LastError     string    `json:"last_error,omitempty"`
+	RowsCompacted int64     `json:"rows_compacted"`
+}
+
+// Job periodically rolls up and deletes raw readings that are older than
+// the retention window of their device.
+type Job struct {
+	db *gorm.DB
+	// DefaultRetention applies to devices without a RetentionPolicy row.
+	DefaultRetention time.Duration
+	// Interval between compaction runs.
+	Interval time.Duration
+
+	mu     sync.Mutex
+	status Status
+}
+
+func NewJob(db *gorm.DB, defaultRetention time.Duration) *Job {
+	return &Job{
+		db:               db,
+		DefaultRetention: defaultRetention,
+		Interval:         time.Hour,
+	}
+}
+
+// Start runs the job immediately and then every Interval until ctx is done.
+func (j *Job) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(j.Interval)
+		defer ticker.Stop()
+		for {
+			if err := j.RunOnce(time.Now()); err != nil {
+				log.Println("retention:", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (j *Job) Status() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// RetentionFor returns the raw retention window that applies to a device.
+func (j *Job) RetentionFor(deviceID string) (time.Duration, error) {
+	var policies []models.RetentionPolicy
+	if err := j.db.Where("device_id = ?", deviceID).Limit(1).Find(&policies).Error; err != nil {
+		return 0, err
+	}
+	if len(policies) == 0 || policies[0].RawDays <= 0 {
+		return j.DefaultRetention, nil
+	}
+	return time.Duration(policies[0].RawDays) * day, nil
+}
+
+// RunOnce compacts every device's readings that are older than its
+// retention window relative to now.
+func (j *Job) RunOnce(now time.Time) error {
+	deviceIDs, err := store.NewSQL(j.db).Readings.DeviceIDs()
+
+	var total int64
+	for _, id := range deviceIDs {
+		if err != nil {
+			break
+		}
+		var keep time.Duration
+		keep, err = j.RetentionFor(id)
+		if err != nil {
+			break
+		}
+		// Only whole local days are compacted so daily rollups are complete.
+		cutoff := aggregate.BucketStart(now.Add(-keep), day)
+		var n int64
+		n, err = j.compactDevice(id, cutoff)
+		total += n
+	}
+
+	j.mu.Lock()
+	j.status.LastRun = now
+	j.status.RowsCompacted += total
+	j.status.LastError = ""
+	if err != nil {
+		j.status.LastError = err.Error()
+	}
+	j.mu.Unlock()
+	return err
+}
+
+func (j *Job) compactDevice(deviceID string, cutoff time.Time) (int64, error) {
+	var deleted int64
+	err := j.db.Transaction(func(tx *gorm.DB) error {
+		readings := store.NewSQL(tx).Readings
+		old := store.ReadingFilter{DeviceIDs: []string{deviceID}, To: cutoff}
+		// Rejected readings are deleted without counting in the rollups.
+		accepted := old
+		accepted.Quality = []string{models.QualityOK, models.QualitySuspect}
+		hourly := aggregate.NewAccumulator(time.Hour)
+		daily := aggregate.NewAccumulator(day)
+		err := readings.Each(accepted, func(data *models.SensorData) error {
+			hourly.Add(data)
+			daily.Add(data)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, b := range hourly.Buckets() {
+			if err := mergeRollup(tx, "hourly_rollups", toRollup(b)); err != nil {
+				return err
+			}
+		}
+		for _, b := range daily.Buckets() {
+			if err := mergeRollup(tx, "daily_rollups", toRollup(b)); err != nil {
+				return err
+			}
+		}
+
+		deleted, err = readings.Delete(old)
+		return err
+	})
+	return deleted, err
+}
+
+func toRollup(b aggregate.Bucket) models.SensorRollup {
+	stats := func(s aggregate.Stats) models.RollupStats {
+		return models.RollupStats{Min: s.Min, Max: s.Max, Avg: s.Avg}
+	}
+	return models.SensorRollup{
+		DeviceID:    b.DeviceID,
+		Start:       b.Start,
+		Count:       b.Count,
+		Temperature: stats(b.Temperature),
+		Humidity:    stats(b.Humidity),
+		Soil:        stats(b.Soil),
+	}
+}
+
+// mergeRollup stores r, folding it into an existing row for the same bucket.
+// That happens when late readings arrive for a period already compacted.
+func mergeRollup(tx *gorm.DB, table string, r models.SensorRollup) error {
+	var found []models.SensorRollup
+	err := tx.Table(table).Where("device_id = ? AND start = ?", r.DeviceID, r.Start).Limit(1).Find(&found).Error
+	if err != nil {
+		return err
+	}
+	if len(found) == 1 {
+		existing := found[0]
+		merge := func(a, b models.RollupStats) models.RollupStats {
+			total := float64(existing.Count + r.Count)
+			return models.RollupStats{
+				Min: min(a.Min, b.Min),
+				Max: max(a.Max, b.Max),
+				Avg: (a.Avg*float64(existing.Count) + b.Avg*float64(r.Count)) / total,
+			}
+		}
+		r.Temperature = merge(existing.Temperature, r.Temperature)
+		r.Humidity = merge(existing.Humidity, r.Humidity)
+		r.Soil = merge(existing.Soil, r.Soil)
+		r.Count += existing.Count
+	}
+	return tx.Table(table).Clauses(clause.OnConflict{UpdateAll: true}).Create(&r).Error
+}
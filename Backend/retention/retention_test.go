@@ -0,0 +1,77 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"my-smart-farm/models"
+	"my-smart-farm/store/storetest"
+)
+
+func TestRunOnceCompactsOldReadings(t *testing.T) {
+	db := storetest.SQLite(t, &models.SensorData{}, &models.HourlyRollup{}, &models.DailyRollup{}, &models.RetentionPolicy{})
+
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2025, 4, day, hour, minute, 0, 0, time.Local)
+	}
+	reading := func(deviceID string, ts time.Time, temp float64, quality string) {
+		db.Create(&models.SensorData{DeviceID: deviceID, Temperature: temp, Humidity: 60, Soil: 30, Quality: quality, Timestamp: ts})
+	}
+	reading("sensor-001", at(5, 6, 10), 20, models.QualityOK)
+	reading("sensor-001", at(5, 6, 40), 22, models.QualitySuspect)
+	reading("sensor-001", at(5, 7, 20), 30, models.QualityOK)
+	reading("sensor-001", at(5, 8, 0), 99, models.QualityRejected)
+	reading("sensor-001", at(8, 9, 0), 25, models.QualityOK)
+	// sensor-002 keeps a week, so its reading is still inside the window.
+	reading("sensor-002", at(5, 6, 0), 18, models.QualityOK)
+	db.Create(&models.RetentionPolicy{DeviceID: "sensor-002", RawDays: 7, Updated: at(1, 0, 0)})
+
+	j := NewJob(db, 3*day)
+	now := at(10, 12, 0)
+	if err := j.RunOnce(now); err != nil {
+		t.Fatal(err)
+	}
+	if got := j.Status().RowsCompacted; got != 4 {
+		t.Errorf("compacted %d rows, want 4", got)
+	}
+
+	var left []models.SensorData
+	db.Order("device_id, timestamp").Find(&left)
+	if len(left) != 2 || !left[0].Timestamp.Equal(at(8, 9, 0)) || left[1].DeviceID != "sensor-002" {
+		t.Errorf("rows inside the window not kept: %+v", left)
+	}
+
+	var hourly []models.HourlyRollup
+	db.Order("start").Find(&hourly)
+	if len(hourly) != 2 {
+		t.Fatalf("got %d hourly rollups, want 2", len(hourly))
+	}
+	if h := hourly[0]; !h.Start.Equal(at(5, 6, 0)) || h.Count != 2 ||
+		h.Temperature != (models.RollupStats{Min: 20, Max: 22, Avg: 21}) {
+		t.Errorf("bad 06:00 rollup: %+v", h)
+	}
+	if h := hourly[1]; !h.Start.Equal(at(5, 7, 0)) || h.Count != 1 || h.Temperature.Avg != 30 {
+		t.Errorf("bad 07:00 rollup: %+v", h)
+	}
+
+	var daily []models.DailyRollup
+	db.Find(&daily)
+	if len(daily) != 1 || !daily[0].Start.Equal(at(5, 0, 0)) || daily[0].Count != 3 ||
+		daily[0].Temperature != (models.RollupStats{Min: 20, Max: 30, Avg: 24}) {
+		t.Errorf("bad daily rollups: %+v", daily)
+	}
+
+	// A late reading for a compacted hour is folded into its rollups.
+	reading("sensor-001", at(5, 6, 30), 24, models.QualityOK)
+	if err := j.RunOnce(now); err != nil {
+		t.Fatal(err)
+	}
+	db.Order("start").Find(&hourly)
+	if h := hourly[0]; h.Count != 3 || h.Temperature != (models.RollupStats{Min: 20, Max: 24, Avg: 22}) {
+		t.Errorf("late reading not merged: %+v", h)
+	}
+	db.Find(&daily)
+	if daily[0].Count != 4 || daily[0].Temperature.Avg != 24 {
+		t.Errorf("late reading not merged into day: %+v", daily[0])
+	}
+}
@@ -0,0 +1,85 @@
+package schedule
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"my-smart-farm/models"
+	"my-smart-farm/relay"
+	"my-smart-farm/store/storetest"
+)
+
+var bangkok = time.FixedZone("ICT", 7*3600)
+
+func TestCronNext(t *testing.T) {
+	cases := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		// Saturday 06:30 -> Monday 06:00, skipping Sunday
+		{"0 6 * * mon-sat", time.Date(2025, 4, 5, 6, 30, 0, 0, bangkok), time.Date(2025, 4, 7, 6, 0, 0, 0, bangkok)},
+		{"*/15 * * * *", time.Date(2025, 4, 5, 6, 14, 59, 0, bangkok), time.Date(2025, 4, 5, 6, 15, 0, 0, bangkok)},
+		{"30 18 1 * *", time.Date(2025, 12, 2, 0, 0, 0, 0, bangkok), time.Date(2026, 1, 1, 18, 30, 0, 0, bangkok)},
+		{"0 0 * * 7", time.Date(2025, 4, 5, 12, 0, 0, 0, bangkok), time.Date(2025, 4, 6, 0, 0, 0, 0, bangkok)},
+	}
+	for _, tc := range cases {
+		c, err := ParseCron(tc.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.Next(tc.from); !got.Equal(tc.want) {
+			t.Errorf("%q after %v = %v, want %v", tc.expr, tc.from, got, tc.want)
+		}
+	}
+	for _, bad := range []string{"", "* * * *", "60 * * * *", "* * * * 8", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := ParseCron(bad); err == nil {
+			t.Errorf("%q should not parse", bad)
+		}
+	}
+}
+
+func TestReconcileAfterRestart(t *testing.T) {
+	var actions []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actions = append(actions, strings.TrimPrefix(r.URL.Path, "/relay/"))
+	}))
+	defer srv.Close()
+
+	db := storetest.SQLite(t, &models.RelaySchedule{}, &models.RelayDevice{})
+	db.Create(&models.RelayDevice{DeviceID: "relay-001", IP: strings.TrimPrefix(srv.URL, "http://"), Updated: time.Now()})
+	created := time.Date(2025, 4, 1, 0, 0, 0, 0, bangkok)
+	sched := models.RelaySchedule{
+		RelayID: "relay-001", Cron: "0 6 * * 1-6", DurationSeconds: 600,
+		Enabled: true, CreatedAt: created,
+	}
+	db.Create(&sched)
+
+	s := NewScheduler(db, relay.NewClient(db))
+	s.Location = bangkok
+	ctx := context.Background()
+
+	// The server comes back at 06:04 on Saturday: the 06:00 run resumes.
+	s.RunDue(ctx, time.Date(2025, 4, 5, 6, 4, 0, 0, bangkok))
+	db.First(&sched, sched.ID)
+	if sched.OffAt == nil || !sched.OffAt.Equal(time.Date(2025, 4, 5, 6, 10, 0, 0, bangkok)) {
+		t.Fatalf("run should resume until 06:10, off_at %v", sched.OffAt)
+	}
+	s.RunDue(ctx, time.Date(2025, 4, 5, 6, 5, 0, 0, bangkok))
+	s.RunDue(ctx, time.Date(2025, 4, 5, 6, 10, 0, 0, bangkok))
+
+	// Down all of Monday's window: that run is skipped, not started late.
+	s.RunDue(ctx, time.Date(2025, 4, 7, 7, 0, 0, 0, bangkok))
+	if strings.Join(actions, ",") != "on,off" {
+		t.Errorf("relay got %v, want [on off]", actions)
+	}
+
+	runs := s.Upcoming([]models.RelaySchedule{sched}, time.Date(2025, 4, 5, 7, 0, 0, 0, bangkok), 2)
+	if len(runs) != 2 || runs[0].Start.Day() != 7 || runs[1].Start.Day() != 8 {
+		t.Errorf("unexpected upcoming runs %+v", runs)
+	}
+}
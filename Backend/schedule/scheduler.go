@@ -0,0 +1,256 @@
+// Package schedule switches relays on and off at cron-style times.
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"my-smart-farm/models"
+	"my-smart-farm/relay"
+
+	"gorm.io/gorm"
+)
+
+// Scheduler starts and stops scheduled relay runs. Run state is kept on the
+// schedule rows, so after a restart a run whose window is still open is
+// resumed for its remaining time and a relay left on past its window is
+// switched off.
+type Scheduler struct {
+	db     *gorm.DB
+	relays *relay.Client
+	// Location is used for schedules without a time zone of their own.
+	Location *time.Location
+	// Tick is how often schedules are checked.
+	Tick time.Duration
+	// Monitor, when set, stops runs from starting on offline relays.
+	Monitor *relay.Monitor
+
+	mu sync.Mutex
+}
+
+func NewScheduler(db *gorm.DB, relays *relay.Client) *Scheduler {
+	return &Scheduler{db: db, relays: relays, Location: time.Local, Tick: 15 * time.Second}
+}
+
+// Run is one planned relay run.
+type Run struct {
+	ScheduleID uint      `json:"schedule_id"`
+	Name       string    `json:"name"`
+	RelayID    string    `json:"relay_id"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+}
+
+// Location resolves a schedule's time zone name, falling back to def.
+func Location(name string, def *time.Location) (*time.Location, error) {
+	if name == "" {
+		return def, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// Validate checks the cron expression, duration and time zone of s.
+func Validate(s models.RelaySchedule) error {
+	if _, err := ParseCron(s.Cron); err != nil {
+		return err
+	}
+	if s.DurationSeconds <= 0 {
+		return fmt.Errorf("duration_seconds must be positive")
+	}
+	if _, err := Location(s.TimeZone, time.UTC); err != nil {
+		return fmt.Errorf("unknown time zone %q", s.TimeZone)
+	}
+	return nil
+}
+
+// Start checks schedules immediately, to reconcile runs missed while the
+// server was down, and then on every tick until ctx is done.
+func (s *Scheduler) Start(ctx context.Context) {
+	go func() {
+		if err := s.RunDue(ctx, time.Now()); err != nil {
+			log.Println("schedule:", err)
+		}
+		ticker := time.NewTicker(s.Tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if err := s.RunDue(ctx, now); err != nil {
+					log.Println("schedule:", err)
+				}
+			}
+		}
+	}()
+}
+
+// RunDue switches off runs that have ended and starts runs whose window
+// contains now. Runs whose whole window passed unnoticed are skipped.
+func (s *Scheduler) RunDue(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var schedules []models.RelaySchedule
+	if err := s.db.Where("enabled = ? OR off_at IS NOT NULL", true).Find(&schedules).Error; err != nil {
+		return err
+	}
+	for i := range schedules {
+		sched := &schedules[i]
+		if sched.OffAt != nil {
+			// Disabled schedules still finish by switching off.
+			if !sched.Enabled || !now.Before(*sched.OffAt) {
+				s.switchOff(ctx, sched)
+			}
+			continue
+		}
+		if !sched.Enabled {
+			continue
+		}
+		start, ok := s.dueRun(sched, now)
+		if !ok {
+			continue
+		}
+		s.switchOn(ctx, sched, start, start.Add(time.Duration(sched.DurationSeconds)*time.Second))
+	}
+	return nil
+}
+
+// dueRun returns the latest run start at or before now whose window is
+// still open and that has not been handled yet.
+func (s *Scheduler) dueRun(sched *models.RelaySchedule, now time.Time) (time.Time, bool) {
+	cron, err := ParseCron(sched.Cron)
+	if err != nil {
+		return time.Time{}, false
+	}
+	loc, err := Location(sched.TimeZone, s.Location)
+	if err != nil {
+		return time.Time{}, false
+	}
+	duration := time.Duration(sched.DurationSeconds) * time.Second
+
+	// Only starts within the last duration can still be running, so begin
+	// the search there rather than at an old LastRunAt.
+	from := now.Add(-duration - time.Minute)
+	if sched.LastRunAt != nil && sched.LastRunAt.After(from) {
+		from = *sched.LastRunAt
+	} else if sched.LastRunAt == nil && sched.CreatedAt.After(from) {
+		from = sched.CreatedAt.Add(-time.Minute)
+	}
+
+	var due time.Time
+	for t := cron.Next(from.In(loc)); !t.IsZero() && !t.After(now); t = cron.Next(t) {
+		if t.Add(duration).After(now) {
+			due = t
+		}
+	}
+	return due, !due.IsZero()
+}
+
+func (s *Scheduler) switchOn(ctx context.Context, sched *models.RelaySchedule, start, end time.Time) {
+	if s.Monitor != nil {
+		if err := s.Monitor.Usable(sched.RelayID); err != nil {
+			// Retried on the next tick while the window is still open.
+			sched.LastError = err.Error()
+			s.save(sched)
+			return
+		}
+	}
+	if err := s.send(ctx, sched, relay.ActionOn); err != nil {
+		// LastRunAt is left alone so the next tick retries while the
+		// window is still open.
+		sched.LastError = err.Error()
+		s.save(sched)
+		return
+	}
+	sched.LastRunAt = &start
+	sched.OffAt = &end
+	sched.LastError = ""
+	s.save(sched)
+}
+
+func (s *Scheduler) switchOff(ctx context.Context, sched *models.RelaySchedule) {
+	if err := s.send(ctx, sched, relay.ActionOff); err != nil {
+		sched.LastError = err.Error()
+		s.save(sched)
+		return
+	}
+	sched.OffAt = nil
+	sched.LastError = ""
+	s.save(sched)
+}
+
+func (s *Scheduler) send(ctx context.Context, sched *models.RelaySchedule, action string) error {
+	resp, err := s.relays.Send(ctx, sched.RelayID, action, relay.BySchedule(sched.ID))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("relay %s answered %d", sched.RelayID, resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *Scheduler) save(sched *models.RelaySchedule) {
+	err := s.db.Model(sched).Select("LastRunAt", "OffAt", "LastError").Updates(sched).Error
+	if err != nil {
+		log.Println("schedule: save state:", err)
+	}
+}
+
+// StopSchedule switches the schedule's relay off if a run is in progress.
+func (s *Scheduler) StopSchedule(ctx context.Context, id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var sched models.RelaySchedule
+	if err := s.db.First(&sched, id).Error; err != nil {
+		return err
+	}
+	if sched.OffAt == nil {
+		return nil
+	}
+	s.switchOff(ctx, &sched)
+	if sched.OffAt != nil {
+		return fmt.Errorf("failed to switch off relay %s: %s", sched.RelayID, sched.LastError)
+	}
+	return nil
+}
+
+// Upcoming lists the next count runs across schedules, soonest first.
+func (s *Scheduler) Upcoming(schedules []models.RelaySchedule, now time.Time, count int) []Run {
+	var runs []Run
+	for _, sched := range schedules {
+		cron, err := ParseCron(sched.Cron)
+		if err != nil {
+			continue
+		}
+		loc, err := Location(sched.TimeZone, s.Location)
+		if err != nil {
+			continue
+		}
+		duration := time.Duration(sched.DurationSeconds) * time.Second
+		t := now.In(loc)
+		for i := 0; i < count; i++ {
+			if t = cron.Next(t); t.IsZero() {
+				break
+			}
+			runs = append(runs, Run{
+				ScheduleID: sched.ID,
+				Name:       sched.Name,
+				RelayID:    sched.RelayID,
+				Start:      t,
+				End:        t.Add(duration),
+			})
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Start.Before(runs[j].Start) })
+	if len(runs) > count {
+		runs = runs[:count]
+	}
+	return runs
+}
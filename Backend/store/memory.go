@@ -0,0 +1,332 @@
+package store
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"my-smart-farm/models"
+)
+
+// NewMemory returns empty repositories that live in process memory. They
+// behave like the SQL ones and are meant for tests and demos.
+func NewMemory() *Store {
+	m := &memory{
+		intervals: make(map[string]models.IntervalSetting),
+		relays:    make(map[string]models.RelayDevice),
+		devices:   make(map[string]models.Device),
+		cals:      make(map[string]models.SoilCalibration),
+	}
+	return &Store{
+		Readings:     memReadings{m},
+		Intervals:    memIntervals{m},
+		Relays:       memRelays{m},
+		Devices:      memDevices{m},
+		Calibrations: memCalibrations{m},
+	}
+}
+
+type memory struct {
+	mu        sync.RWMutex
+	readings  []models.SensorData // in insertion order, so by ID
+	intervals map[string]models.IntervalSetting
+	relays    map[string]models.RelayDevice
+	devices   map[string]models.Device
+	cals      map[string]models.SoilCalibration
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (f ReadingFilter) match(d *models.SensorData) bool {
+	if len(f.DeviceIDs) > 0 && !contains(f.DeviceIDs, d.DeviceID) {
+		return false
+	}
+	if len(f.Quality) > 0 && !contains(f.Quality, d.Quality) {
+		return false
+	}
+	return (f.From.IsZero() || !d.Timestamp.Before(f.From)) &&
+		(f.To.IsZero() || d.Timestamp.Before(f.To))
+}
+
+// before orders readings by (timestamp, id).
+func before(a, b *models.SensorData) bool {
+	if !a.Timestamp.Equal(b.Timestamp) {
+		return a.Timestamp.Before(b.Timestamp)
+	}
+	return a.ID < b.ID
+}
+
+type memReadings struct{ m *memory }
+
+func (r memReadings) insert(data *models.SensorData) {
+	data.ID = uint(len(r.m.readings) + 1)
+	if data.Quality == "" {
+		data.Quality = models.QualityOK
+	}
+	r.m.readings = append(r.m.readings, *data)
+}
+
+func (r memReadings) Create(data *models.SensorData) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	r.insert(data)
+	return nil
+}
+
+func (r memReadings) CreateNew(batch []*models.SensorData) ([]bool, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	created := make([]bool, len(batch))
+	for i, data := range batch {
+		var existing uint
+		for j := range r.m.readings {
+			stored := &r.m.readings[j]
+			if stored.DeviceID == data.DeviceID && stored.Timestamp.Equal(data.Timestamp) {
+				existing = stored.ID
+				break
+			}
+		}
+		if existing != 0 {
+			data.ID = existing
+			continue
+		}
+		r.insert(data)
+		created[i] = true
+	}
+	return created, nil
+}
+
+// matching returns copies of the readings matching f in (timestamp, id) order.
+func (r memReadings) matching(f ReadingFilter) []models.SensorData {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	var rows []models.SensorData
+	for i := range r.m.readings {
+		if f.match(&r.m.readings[i]) {
+			rows = append(rows, r.m.readings[i])
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return before(&rows[i], &rows[j]) })
+	return rows
+}
+
+func (r memReadings) Find(q ReadingQuery) ([]models.SensorData, error) {
+	rows := r.matching(q.ReadingFilter)
+	if q.Desc {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	page := make([]models.SensorData, 0, q.Limit)
+	for i := range rows {
+		if q.After != nil {
+			after := models.SensorData{ID: q.After.ID, Timestamp: q.After.Timestamp}
+			if q.Desc && !before(&rows[i], &after) || !q.Desc && !before(&after, &rows[i]) {
+				continue
+			}
+		}
+		if len(page) == q.Limit {
+			break
+		}
+		page = append(page, rows[i])
+	}
+	return page, nil
+}
+
+func (r memReadings) Each(f ReadingFilter, fn func(*models.SensorData) error) error {
+	rows := r.matching(f)
+	for i := range rows {
+		if err := fn(&rows[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r memReadings) UpdateSoil(updates map[uint]SoilUpdate) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	for i := range r.m.readings {
+		if u, ok := updates[r.m.readings[i].ID]; ok {
+			data := &r.m.readings[i]
+			data.Soil, data.Quality, data.QualityNote = u.Soil, u.Quality, u.QualityNote
+		}
+	}
+	return nil
+}
+
+func (r memReadings) Delete(f ReadingFilter) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	n := len(r.m.readings)
+	r.m.readings = slices.DeleteFunc(r.m.readings, func(d models.SensorData) bool { return f.match(&d) })
+	return int64(n - len(r.m.readings)), nil
+}
+
+func (r memReadings) DeviceIDs() ([]string, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	var ids []string
+	for i := range r.m.readings {
+		if !contains(ids, r.m.readings[i].DeviceID) {
+			ids = append(ids, r.m.readings[i].DeviceID)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (r memReadings) Latest() ([]models.SensorData, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	latest := map[string]models.SensorData{}
+	for i := range r.m.readings {
+		d := &r.m.readings[i]
+		if cur, ok := latest[d.DeviceID]; d.Quality != models.QualityRejected && (!ok || before(&cur, d)) {
+			latest[d.DeviceID] = *d
+		}
+	}
+	rows := make([]models.SensorData, 0, len(latest))
+	for _, d := range latest {
+		rows = append(rows, d)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].DeviceID < rows[j].DeviceID })
+	return rows, nil
+}
+
+type memIntervals struct{ m *memory }
+
+func (r memIntervals) GetOrCreate(deviceID string, seconds int) (models.IntervalSetting, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	setting, ok := r.m.intervals[deviceID]
+	if !ok {
+		setting = models.IntervalSetting{DeviceID: deviceID, IntervalSeconds: seconds}
+		r.m.intervals[deviceID] = setting
+	}
+	return setting, nil
+}
+
+func (r memIntervals) Put(setting models.IntervalSetting) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	r.m.intervals[setting.DeviceID] = setting
+	return nil
+}
+
+func (r memIntervals) List() ([]models.IntervalSetting, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	intervals := make([]models.IntervalSetting, 0, len(r.m.intervals))
+	for _, s := range r.m.intervals {
+		intervals = append(intervals, s)
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].DeviceID < intervals[j].DeviceID })
+	return intervals, nil
+}
+
+type memRelays struct{ m *memory }
+
+func (r memRelays) Register(deviceID, ip string, at time.Time) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	device := r.m.relays[deviceID]
+	device.DeviceID = deviceID
+	device.IP = ip
+	device.Updated = at
+	device.LastHeartbeat = &at
+	r.m.relays[deviceID] = device
+	return nil
+}
+
+func (r memRelays) Heartbeat(deviceID, ip string, at time.Time) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	device, ok := r.m.relays[deviceID]
+	if !ok {
+		return ErrNotFound
+	}
+	device.LastHeartbeat = &at
+	if ip != "" {
+		device.IP = ip
+		device.Updated = at
+	}
+	r.m.relays[deviceID] = device
+	return nil
+}
+
+func (r memRelays) Get(deviceID string) (models.RelayDevice, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	device, ok := r.m.relays[deviceID]
+	if !ok {
+		return device, ErrNotFound
+	}
+	return device, nil
+}
+
+func (r memRelays) List() ([]models.RelayDevice, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	relays := make([]models.RelayDevice, 0, len(r.m.relays))
+	for _, d := range r.m.relays {
+		relays = append(relays, d)
+	}
+	sort.Slice(relays, func(i, j int) bool { return relays[i].DeviceID < relays[j].DeviceID })
+	return relays, nil
+}
+
+type memDevices struct{ m *memory }
+
+func (r memDevices) Touch(deviceID, kind string, at time.Time) error {
+	if deviceID == "" {
+		return nil
+	}
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	device, ok := r.m.devices[deviceID]
+	if !ok {
+		device = models.Device{DeviceID: deviceID, Kind: kind, CreatedAt: at}
+	}
+	device.LastSeen = &at
+	r.m.devices[deviceID] = device
+	return nil
+}
+
+type memCalibrations struct{ m *memory }
+
+func (r memCalibrations) Get(deviceID string) (models.SoilCalibration, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+	cal, ok := r.m.cals[deviceID]
+	if !ok {
+		return cal, ErrNotFound
+	}
+	return cal, nil
+}
+
+func (r memCalibrations) Put(cal models.SoilCalibration) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	cal.Points = slices.Clone(cal.Points)
+	r.m.cals[cal.DeviceID] = cal
+	return nil
+}
+
+func (r memCalibrations) Delete(deviceID string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if _, ok := r.m.cals[deviceID]; !ok {
+		return ErrNotFound
+	}
+	delete(r.m.cals, deviceID)
+	return nil
+}
@@ -0,0 +1,270 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	"my-smart-farm/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NewSQL returns repositories backed by db. The queries are plain GORM and
+// work on both the SQLite and the Postgres dialect.
+func NewSQL(db *gorm.DB) *Store {
+	return &Store{
+		Readings:     sqlReadings{db},
+		Intervals:    sqlIntervals{db},
+		Relays:       sqlRelays{db},
+		Devices:      sqlDevices{db},
+		Calibrations: sqlCalibrations{db},
+	}
+}
+
+type sqlReadings struct{ db *gorm.DB }
+
+func (r sqlReadings) Create(data *models.SensorData) error {
+	return r.db.Create(data).Error
+}
+
+func (r sqlReadings) CreateNew(batch []*models.SensorData) ([]bool, error) {
+	created := make([]bool, len(batch))
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		for i, data := range batch {
+			var existing []models.SensorData
+			err := tx.Where("device_id = ? AND timestamp = ?", data.DeviceID, data.Timestamp).
+				Limit(1).Find(&existing).Error
+			if err != nil {
+				return err
+			}
+			if len(existing) > 0 {
+				data.ID = existing[0].ID
+				continue
+			}
+			if err := tx.Create(data).Error; err != nil {
+				return err
+			}
+			created[i] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// filter applies the device, time-range and quality conditions without
+// ordering or paging.
+func (f ReadingFilter) filter(tx *gorm.DB) *gorm.DB {
+	if len(f.DeviceIDs) > 0 {
+		tx = tx.Where("device_id IN ?", f.DeviceIDs)
+	}
+	if !f.From.IsZero() {
+		tx = tx.Where("timestamp >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		tx = tx.Where("timestamp < ?", f.To)
+	}
+	if len(f.Quality) > 0 {
+		tx = tx.Where("quality IN ?", f.Quality)
+	}
+	return tx
+}
+
+func (r sqlReadings) Find(q ReadingQuery) ([]models.SensorData, error) {
+	tx := q.filter(r.db.Model(&models.SensorData{}))
+	if q.After != nil {
+		op := ">"
+		if q.Desc {
+			op = "<"
+		}
+		tx = tx.Where("(timestamp "+op+" ? OR (timestamp = ? AND id "+op+" ?))",
+			q.After.Timestamp, q.After.Timestamp, q.After.ID)
+	}
+	if q.Desc {
+		tx = tx.Order("timestamp DESC").Order("id DESC")
+	} else {
+		tx = tx.Order("timestamp ASC").Order("id ASC")
+	}
+	var rows []models.SensorData
+	err := tx.Limit(q.Limit).Find(&rows).Error
+	return rows, err
+}
+
+func (r sqlReadings) Each(f ReadingFilter, fn func(*models.SensorData) error) error {
+	cursor, err := f.filter(r.db.Model(&models.SensorData{})).
+		Order("timestamp ASC").Order("id ASC").Rows()
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+	for cursor.Next() {
+		var data models.SensorData
+		if err := r.db.ScanRows(cursor, &data); err != nil {
+			return err
+		}
+		if err := fn(&data); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (r sqlReadings) UpdateSoil(updates map[uint]SoilUpdate) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for id, u := range updates {
+			err := tx.Model(&models.SensorData{}).Where("id = ?", id).Updates(map[string]interface{}{
+				"soil":         u.Soil,
+				"quality":      u.Quality,
+				"quality_note": u.QualityNote,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r sqlReadings) Delete(f ReadingFilter) (int64, error) {
+	res := f.filter(r.db.Model(&models.SensorData{})).Delete(&models.SensorData{})
+	return res.RowsAffected, res.Error
+}
+
+func (r sqlReadings) DeviceIDs() ([]string, error) {
+	var ids []string
+	err := r.db.Model(&models.SensorData{}).Distinct().Order("device_id").Pluck("device_id", &ids).Error
+	return ids, err
+}
+
+func (r sqlReadings) Latest() ([]models.SensorData, error) {
+	var rows []models.SensorData
+	err := r.db.Raw(`SELECT s.* FROM sensor_data s
+		JOIN (SELECT device_id, MAX(timestamp) AS ts FROM sensor_data
+			WHERE quality <> ? GROUP BY device_id) l
+		ON s.device_id = l.device_id AND s.timestamp = l.ts
+		WHERE s.quality <> ?
+		ORDER BY s.device_id, s.id DESC`, models.QualityRejected, models.QualityRejected).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	// Readings sharing the latest timestamp count once.
+	latest := rows[:0]
+	for _, d := range rows {
+		if len(latest) == 0 || latest[len(latest)-1].DeviceID != d.DeviceID {
+			latest = append(latest, d)
+		}
+	}
+	return latest, nil
+}
+
+type sqlIntervals struct{ db *gorm.DB }
+
+func (r sqlIntervals) GetOrCreate(deviceID string, seconds int) (models.IntervalSetting, error) {
+	setting := models.IntervalSetting{DeviceID: deviceID, IntervalSeconds: seconds}
+	err := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&setting).Error
+	if err != nil {
+		return setting, err
+	}
+	err = r.db.First(&setting, "device_id = ?", deviceID).Error
+	return setting, err
+}
+
+func (r sqlIntervals) Put(setting models.IntervalSetting) error {
+	return r.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&setting).Error
+}
+
+func (r sqlIntervals) List() ([]models.IntervalSetting, error) {
+	var intervals []models.IntervalSetting
+	err := r.db.Find(&intervals).Error
+	return intervals, err
+}
+
+type sqlRelays struct{ db *gorm.DB }
+
+func (r sqlRelays) Register(deviceID, ip string, at time.Time) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "device_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"ip", "updated", "last_heartbeat"}),
+	}).Create(&models.RelayDevice{
+		DeviceID:      deviceID,
+		IP:            ip,
+		Updated:       at,
+		LastHeartbeat: &at,
+	}).Error
+}
+
+func (r sqlRelays) Heartbeat(deviceID, ip string, at time.Time) error {
+	updates := map[string]interface{}{"last_heartbeat": at}
+	if ip != "" {
+		updates["ip"] = ip
+		updates["updated"] = at
+	}
+	res := r.db.Model(&models.RelayDevice{}).Where("device_id = ?", deviceID).Updates(updates)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r sqlRelays) Get(deviceID string) (models.RelayDevice, error) {
+	var device models.RelayDevice
+	err := r.db.First(&device, "device_id = ?", deviceID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = ErrNotFound
+	}
+	return device, err
+}
+
+func (r sqlRelays) List() ([]models.RelayDevice, error) {
+	var relays []models.RelayDevice
+	err := r.db.Find(&relays).Error
+	return relays, err
+}
+
+type sqlDevices struct{ db *gorm.DB }
+
+func (r sqlDevices) Touch(deviceID, kind string, at time.Time) error {
+	if deviceID == "" {
+		return nil
+	}
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "device_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"last_seen": at}),
+	}).Create(&models.Device{
+		DeviceID: deviceID,
+		Kind:     kind,
+		LastSeen: &at,
+	}).Error
+}
+
+type sqlCalibrations struct{ db *gorm.DB }
+
+func (r sqlCalibrations) Get(deviceID string) (models.SoilCalibration, error) {
+	var cal models.SoilCalibration
+	err := r.db.First(&cal, "device_id = ?", deviceID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = ErrNotFound
+	}
+	return cal, err
+}
+
+func (r sqlCalibrations) Put(cal models.SoilCalibration) error {
+	return r.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&cal).Error
+}
+
+func (r sqlCalibrations) Delete(deviceID string) error {
+	res := r.db.Delete(&models.SoilCalibration{}, "device_id = ?", deviceID)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
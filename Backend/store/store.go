@@ -0,0 +1,121 @@
+// Package store hides how readings, interval settings, relays and soil
+// calibrations are persisted behind small repository interfaces. NewSQL
+// serves SQLite and Postgres through GORM; NewMemory keeps everything in
+// process for tests.
+package store
+
+import (
+	"errors"
+	"time"
+
+	"my-smart-farm/models"
+)
+
+// ErrNotFound is returned when a requested record does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ReadingFilter selects readings by device, a half-open time range and
+// quality grade. Zero values do not filter.
+type ReadingFilter struct {
+	DeviceIDs []string
+	From      time.Time
+	To        time.Time
+	Quality   []string
+}
+
+// Cursor marks the last row of a page. Rows are ordered by (timestamp, id)
+// so the cursor stays stable when readings share a timestamp.
+type Cursor struct {
+	Timestamp time.Time
+	ID        uint
+}
+
+// ReadingQuery is a filter plus keyset paging.
+type ReadingQuery struct {
+	ReadingFilter
+	Limit int
+	Desc  bool
+	After *Cursor
+}
+
+// Readings stores sensor readings.
+type Readings interface {
+	// Create stores one reading and fills in its ID.
+	Create(data *models.SensorData) error
+	// CreateNew stores the readings in one transaction, skipping those that
+	// already exist for the same device and timestamp. Skipped readings get
+	// the existing row's ID; created reports which ones were stored.
+	CreateNew(batch []*models.SensorData) (created []bool, err error)
+	// Find returns one page of readings in (timestamp, id) order.
+	Find(q ReadingQuery) ([]models.SensorData, error)
+	// Each calls fn for every reading matching f in (timestamp, id) order
+	// without loading them all at once. It stops at the first error.
+	Each(f ReadingFilter, fn func(*models.SensorData) error) error
+	// UpdateSoil sets the soil moisture and quality grade of the readings
+	// with the given IDs in one transaction.
+	UpdateSoil(updates map[uint]SoilUpdate) error
+	// Delete removes the readings matching f and returns how many it
+	// removed.
+	Delete(f ReadingFilter) (int64, error)
+	// DeviceIDs returns the devices that have readings, sorted.
+	DeviceIDs() ([]string, error)
+	// Latest returns the last reading in (timestamp, id) order of each
+	// device that is not rejected, sorted by device.
+	Latest() ([]models.SensorData, error)
+}
+
+// SoilUpdate is the recomputed soil moisture of a stored reading and the
+// grade it earns with it.
+type SoilUpdate struct {
+	Soil        float64
+	Quality     string
+	QualityNote string
+}
+
+// Intervals stores the send interval of each sensor.
+type Intervals interface {
+	// GetOrCreate returns the device's setting, creating it with seconds
+	// when the device has none yet.
+	GetOrCreate(deviceID string, seconds int) (models.IntervalSetting, error)
+	// Put creates or replaces the device's setting.
+	Put(setting models.IntervalSetting) error
+	List() ([]models.IntervalSetting, error)
+}
+
+// Relays stores relay registrations and liveness.
+type Relays interface {
+	// Register records the relay's address and counts as a heartbeat. The
+	// relay's last known state is kept.
+	Register(deviceID, ip string, at time.Time) error
+	// Heartbeat records that the relay is alive, optionally at a new
+	// address. It returns ErrNotFound for relays that never registered.
+	Heartbeat(deviceID, ip string, at time.Time) error
+	Get(deviceID string) (models.RelayDevice, error)
+	List() ([]models.RelayDevice, error)
+}
+
+// Devices is the part of the device registry that ingestion needs.
+type Devices interface {
+	// Touch records that a device was seen at, registering it with the
+	// given kind the first time it reports.
+	Touch(deviceID, kind string, at time.Time) error
+}
+
+// Calibrations stores soil probe calibrations.
+type Calibrations interface {
+	// Get returns ErrNotFound for probes that are not calibrated.
+	Get(deviceID string) (models.SoilCalibration, error)
+	// Put creates or replaces the device's calibration.
+	Put(cal models.SoilCalibration) error
+	// Delete returns ErrNotFound for probes that are not calibrated.
+	Delete(deviceID string) error
+}
+
+// Store bundles the repositories of one backend.
+type Store struct {
+	Readings     Readings
+	Intervals    Intervals
+	Relays       Relays
+	Devices      Devices
+	Calibrations Calibrations
+}
@@ -0,0 +1,178 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"my-smart-farm/models"
+	"my-smart-farm/store/storetest"
+)
+
+var storeModels = []interface{}{
+	&models.SensorData{}, &models.IntervalSetting{}, &models.RelayDevice{}, &models.Device{}, &models.SoilCalibration{},
+}
+
+// Both backends must pass the same checks so handlers behave alike on them.
+func TestBackends(t *testing.T) {
+	db := storetest.SQLite(t, storeModels...)
+	for name, s := range map[string]*Store{"sql": NewSQL(db), "memory": NewMemory()} {
+		t.Run(name, func(t *testing.T) {
+			testBackend(t, s)
+		})
+	}
+}
+
+// TestPostgres runs the same checks against the database named by
+// FARM_TEST_POSTGRES_DSN.
+func TestPostgres(t *testing.T) {
+	testBackend(t, NewSQL(storetest.Postgres(t, storeModels...)))
+}
+
+func testBackend(t *testing.T, s *Store) {
+	testReadings(t, s.Readings)
+	testIntervals(t, s.Intervals)
+	testRelays(t, s.Relays)
+	testCalibrations(t, s.Calibrations)
+}
+
+func testReadings(t *testing.T, r Readings) {
+	base := time.Date(2025, 4, 4, 6, 0, 0, 0, time.Local)
+	for i := 0; i < 5; i++ {
+		// Two readings share each timestamp to exercise the id tie-break.
+		data := models.SensorData{DeviceID: "sensor-001", Soil: float64(i), Timestamp: base.Add(time.Duration(i/2) * time.Minute)}
+		if err := r.Create(&data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Create(&models.SensorData{DeviceID: "sensor-002", Timestamp: base})
+
+	var soils []float64
+	q := ReadingQuery{ReadingFilter: ReadingFilter{DeviceIDs: []string{"sensor-001"}}, Limit: 2, Desc: true}
+	for {
+		page, err := r.Find(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range page {
+			soils = append(soils, d.Soil)
+		}
+		if len(page) < q.Limit {
+			break
+		}
+		last := page[len(page)-1]
+		q.After = &Cursor{Timestamp: last.Timestamp, ID: last.ID}
+	}
+	if len(soils) != 5 || soils[0] != 4 || soils[1] != 3 || soils[4] != 0 {
+		t.Errorf("paged newest first, got %v", soils)
+	}
+
+	var n int
+	f := ReadingFilter{From: base.Add(time.Minute), To: base.Add(2 * time.Minute)}
+	r.Each(f, func(d *models.SensorData) error {
+		n++
+		return nil
+	})
+	if n != 2 {
+		t.Errorf("range [1m, 2m) matched %d readings, want 2", n)
+	}
+
+	batch := []*models.SensorData{
+		{DeviceID: "sensor-001", Soil: 9, Timestamp: base},
+		{DeviceID: "sensor-001", Soil: 9, Timestamp: base.Add(time.Hour)},
+	}
+	created, err := r.CreateNew(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created[0] || !created[1] || batch[0].ID == 0 || batch[1].ID == 0 {
+		t.Errorf("batch should skip the existing reading: %v %+v %+v", created, batch[0], batch[1])
+	}
+
+	update := SoilUpdate{Soil: 42, Quality: models.QualitySuspect, QualityNote: "recalibrated"}
+	if err := r.UpdateSoil(map[uint]SoilUpdate{batch[1].ID: update}); err != nil {
+		t.Fatal(err)
+	}
+	page, _ := r.Find(ReadingQuery{ReadingFilter: ReadingFilter{From: base.Add(time.Hour)}, Limit: 1})
+	if len(page) != 1 || page[0].Soil != 42 || page[0].Quality != models.QualitySuspect || page[0].QualityNote != "recalibrated" {
+		t.Errorf("soil not updated: %+v", page)
+	}
+
+	r.Create(&models.SensorData{DeviceID: "sensor-002", Quality: models.QualityRejected, Timestamp: base.Add(2 * time.Hour)})
+	latest, err := r.Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(latest) != 2 || latest[0].Soil != 42 || latest[1].DeviceID != "sensor-002" || !latest[1].Timestamp.Equal(base) {
+		t.Errorf("unexpected latest readings %+v", latest)
+	}
+	if ids, _ := r.DeviceIDs(); len(ids) != 2 || ids[0] != "sensor-001" || ids[1] != "sensor-002" {
+		t.Errorf("unexpected device IDs %v", ids)
+	}
+	deleted, err := r.Delete(ReadingFilter{DeviceIDs: []string{"sensor-002"}, To: base.Add(3 * time.Hour)})
+	if err != nil || deleted != 2 {
+		t.Errorf("deleted %d readings (%v), want 2", deleted, err)
+	}
+	if ids, _ := r.DeviceIDs(); len(ids) != 1 {
+		t.Errorf("sensor-002 readings left: %v", ids)
+	}
+}
+
+func testIntervals(t *testing.T, r Intervals) {
+	s, err := r.GetOrCreate("sensor-001", 60)
+	if err != nil || s.IntervalSeconds != 60 {
+		t.Fatalf("default interval not created: %+v %v", s, err)
+	}
+	r.Put(models.IntervalSetting{DeviceID: "sensor-001", IntervalSeconds: 300})
+	if s, _ := r.GetOrCreate("sensor-001", 60); s.IntervalSeconds != 300 {
+		t.Errorf("stored interval overwritten by default: %+v", s)
+	}
+	if list, _ := r.List(); len(list) != 1 {
+		t.Errorf("want one interval, got %+v", list)
+	}
+}
+
+func testRelays(t *testing.T, r Relays) {
+	at := time.Date(2025, 4, 4, 6, 0, 0, 0, time.Local)
+	if err := r.Heartbeat("relay-001", "", at); err != ErrNotFound {
+		t.Error("heartbeat of unregistered relay should fail, got", err)
+	}
+	if _, err := r.Get("relay-001"); err != ErrNotFound {
+		t.Error("unknown relay should not be found, got", err)
+	}
+	r.Register("relay-001", "192.168.1.50", at)
+	if err := r.Heartbeat("relay-001", "192.168.1.51", at.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	d, err := r.Get("relay-001")
+	if err != nil || d.IP != "192.168.1.51" || d.LastHeartbeat == nil || !d.LastHeartbeat.Equal(at.Add(time.Minute)) {
+		t.Errorf("unexpected relay: %+v %v", d, err)
+	}
+	if list, _ := r.List(); len(list) != 1 {
+		t.Errorf("want one relay, got %+v", list)
+	}
+}
+
+func testCalibrations(t *testing.T, r Calibrations) {
+	if _, err := r.Get("sensor-001"); err != ErrNotFound {
+		t.Error("uncalibrated probe should not be found, got", err)
+	}
+	cal := models.SoilCalibration{
+		DeviceID: "sensor-001",
+		Points:   []models.SoilCalibrationPoint{{Raw: 52000, Percent: 0}, {Raw: 21000, Percent: 100}},
+	}
+	r.Put(cal)
+	cal.Points[1].Raw = 20000
+	if err := r.Put(cal); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.Get("sensor-001")
+	if err != nil || len(got.Points) != 2 || got.Points[1].Raw != 20000 {
+		t.Errorf("unexpected calibration: %+v %v", got, err)
+	}
+	if err := r.Delete("sensor-001"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Delete("sensor-001"); err != ErrNotFound {
+		t.Error("second delete should not find the calibration, got", err)
+	}
+}
@@ -0,0 +1,65 @@
+// Package storetest opens throwaway databases for tests.
+package storetest
+
+import (
+	"os"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// PostgresEnv names the connection string of a scratch Postgres database
+// that Postgres tests run against. Its tables are dropped and recreated.
+const PostgresEnv = "FARM_TEST_POSTGRES_DSN"
+
+// SQLite opens a private in-memory SQLite database migrated for models. It
+// keeps a single connection, since every connection to :memory: would open
+// its own empty database.
+func SQLite(t testing.TB, models ...interface{}) *gorm.DB {
+	t.Helper()
+	db := open(t, sqlite.Open("file::memory:"))
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// Postgres opens the database named by PostgresEnv with fresh tables for
+// models, and skips the test when the variable is not set.
+func Postgres(t testing.TB, models ...interface{}) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv(PostgresEnv)
+	if dsn == "" {
+		t.Skip(PostgresEnv + " is not set")
+	}
+	db := open(t, postgres.Open(dsn))
+	if err := db.Migrator().DropTable(models...); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func open(t testing.TB, dialector gorm.Dialector) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger:                                   logger.Discard,
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
@@ -0,0 +1,122 @@
+// Package stream fans live readings, relay changes and alert transitions
+// out to connected dashboards.
+package stream
+
+import (
+	"sync"
+	"time"
+
+	"my-smart-farm/alerts"
+	"my-smart-farm/models"
+	"my-smart-farm/relay"
+)
+
+// Event types.
+const (
+	TypeReading     = "reading"
+	TypeRelayState  = "relay_state"
+	TypeRelayStatus = "relay_status"
+	TypeAlert       = "alert"
+)
+
+// Event is one message sent to subscribers.
+type Event struct {
+	Type     string      `json:"type"`
+	DeviceID string      `json:"device_id"`
+	Time     time.Time   `json:"time"`
+	Data     interface{} `json:"data"`
+}
+
+// Filter selects the events a subscriber receives. Empty sets match all.
+type Filter struct {
+	DeviceIDs map[string]bool
+	Types     map[string]bool
+}
+
+func (f Filter) match(ev Event) bool {
+	if len(f.DeviceIDs) > 0 && !f.DeviceIDs[ev.DeviceID] {
+		return false
+	}
+	if len(f.Types) > 0 && !f.Types[ev.Type] {
+		return false
+	}
+	return true
+}
+
+// Subscription receives matching events on C until it is closed.
+type Subscription struct {
+	C      <-chan Event
+	ch     chan Event
+	filter Filter
+	broker *Broker
+}
+
+// Close unsubscribes and closes C.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	if _, ok := s.broker.subs[s]; ok {
+		delete(s.broker.subs, s)
+		close(s.ch)
+	}
+}
+
+// Broker delivers published events to subscribers. Publishing never blocks:
+// a subscriber whose buffer is full misses the event, so one slow
+// dashboard cannot hold up ingestion.
+type Broker struct {
+	// Buffer is the channel size of new subscriptions.
+	Buffer int
+
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{Buffer: 64, subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe starts delivering events matching f.
+func (b *Broker) Subscribe(f Filter) *Subscription {
+	ch := make(chan Event, b.Buffer)
+	s := &Subscription{C: ch, ch: ch, filter: f, broker: b}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// Publish sends ev to every matching subscriber.
+func (b *Broker) Publish(ev Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		if !s.filter.match(ev) {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+		}
+	}
+}
+
+// ReadingHook publishes stored readings; it is an ingest hook.
+func (b *Broker) ReadingHook(data *models.SensorData) {
+	b.Publish(Event{Type: TypeReading, DeviceID: data.DeviceID, Time: data.Timestamp, Data: data})
+}
+
+// AlertHook publishes alert transitions.
+func (b *Broker) AlertHook(t alerts.Transition) {
+	b.Publish(Event{Type: TypeAlert, DeviceID: t.Alert.DeviceID, Time: time.Now(), Data: t.Alert})
+}
+
+// RelayStateHook publishes relay on/off changes acknowledged by the device.
+func (b *Broker) RelayStateHook(c relay.StateChange) {
+	b.Publish(Event{Type: TypeRelayState, DeviceID: c.DeviceID, Time: c.At, Data: c})
+}
+
+// RelayStatusHook publishes relay liveness changes.
+func (b *Broker) RelayStatusHook(c relay.StatusChange) {
+	b.Publish(Event{Type: TypeRelayStatus, DeviceID: c.DeviceID, Time: c.At, Data: c})
+}
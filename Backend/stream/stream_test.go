@@ -0,0 +1,80 @@
+package stream
+
+import (
+	"testing"
+	"time"
+
+	"my-smart-farm/models"
+)
+
+// drain returns the events waiting on s without blocking.
+func drain(s *Subscription) []Event {
+	var got []Event
+	for {
+		select {
+		case ev := <-s.C:
+			got = append(got, ev)
+		default:
+			return got
+		}
+	}
+}
+
+func TestBrokerFilters(t *testing.T) {
+	b := NewBroker()
+	all := b.Subscribe(Filter{})
+	one := b.Subscribe(Filter{DeviceIDs: map[string]bool{"sensor-001": true}})
+	relays := b.Subscribe(Filter{Types: map[string]bool{TypeRelayState: true}})
+
+	b.ReadingHook(&models.SensorData{DeviceID: "sensor-001", Timestamp: time.Now()})
+	b.ReadingHook(&models.SensorData{DeviceID: "sensor-002", Timestamp: time.Now()})
+	b.Publish(Event{Type: TypeRelayState, DeviceID: "relay-001"})
+
+	if got := drain(all); len(got) != 3 {
+		t.Errorf("unfiltered subscriber got %d events, want 3", len(got))
+	}
+	if got := drain(one); len(got) != 1 || got[0].DeviceID != "sensor-001" || got[0].Type != TypeReading {
+		t.Errorf("device filter got %+v", got)
+	}
+	if got := drain(relays); len(got) != 1 || got[0].Type != TypeRelayState {
+		t.Errorf("type filter got %+v", got)
+	}
+}
+
+func TestBrokerDropsForSlowSubscriber(t *testing.T) {
+	b := NewBroker()
+	b.Buffer = 2
+	slow := b.Subscribe(Filter{})
+	fast := b.Subscribe(Filter{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			b.Publish(Event{Type: TypeReading, DeviceID: "sensor-001"})
+			if len(drain(fast)) != 1 {
+				t.Errorf("event %d not delivered to the fast subscriber", i)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked on a full subscriber")
+	}
+	if got := drain(slow); len(got) != 2 {
+		t.Errorf("slow subscriber got %d events, want its buffer of 2", len(got))
+	}
+}
+
+func TestSubscriptionClose(t *testing.T) {
+	b := NewBroker()
+	s := b.Subscribe(Filter{})
+	s.Close()
+	s.Close()
+	if _, ok := <-s.C; ok {
+		t.Error("C should be closed")
+	}
+	// Publishing after the last subscriber left must not panic.
+	b.Publish(Event{Type: TypeReading})
+}
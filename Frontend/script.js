@@ -1,10 +1,59 @@
 // const relayMap = {};
+const API_BASE = "http://127.0.0.1:3000/api/v1";
+
+// ======== Login session ========
+
+let loginWaiters = [];
+
+// Shows the login form and resolves once the user has signed in.
+function requireLogin() {
+  document.getElementById("login-form").hidden = false;
+  return new Promise(resolve => loginWaiters.push(resolve));
+}
+
+document.getElementById("login-form").addEventListener("submit", async (e) => {
+  e.preventDefault();
+  const form = e.target;
+  const res = await fetch(`${API_BASE}/auth/login`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({
+      username: form.username.value,
+      password: form.password.value
+    })
+  });
+  if (!res.ok) {
+    document.getElementById("login-error").textContent = "Invalid username or password";
+    return;
+  }
+  const { token } = await res.json();
+  localStorage.setItem("token", token);
+  form.hidden = true;
+  form.reset();
+  document.getElementById("login-error").textContent = "";
+  loginWaiters.forEach(resolve => resolve());
+  loginWaiters = [];
+});
+
+// fetch wrapper that sends the session token and asks to sign in on 401.
+async function apiFetch(path, options = {}) {
+  for (;;) {
+    const token = localStorage.getItem("token");
+    const headers = { ...(options.headers || {}) };
+    if (token) headers["Authorization"] = `Bearer ${token}`;
+    const res = await fetch(`${API_BASE}${path}`, { ...options, headers });
+    if (res.status !== 401) return res;
+    localStorage.removeItem("token");
+    await requireLogin();
+  }
+}
+
 async function loadData() {
     try {
       const [dataRes, intervalRes, relayRes] = await Promise.all([
-        fetch("http://127.0.0.1:3000/api/v1/data"),
-        fetch("http://127.0.0.1:3000/api/v1/intervals"),
-        fetch("http://127.0.0.1:3000/api/v1/relays")
+        apiFetch("/data?order=desc&limit=500"),
+        apiFetch("/intervals"),
+        apiFetch("/relays")
       ]);
   
       const data = await dataRes.json();
@@ -18,7 +67,7 @@ async function loadData() {
   
         const relayMap = {};
         relays.forEach(r => {
-        relayMap[r.device_id] = r.ip;
+        relayMap[r.device_id] = r;
       });
   
       const container = document.getElementById("sensor-grid");
@@ -34,6 +83,11 @@ async function loadData() {
   
       Object.values(latestByDevice).forEach(d => {
         const currentInterval = intervalMap[d.DeviceID] || 300;
+        const relay = relayMap[d.DeviceID];
+        const relayState = relay && relay.state
+          ? `${relay.state.toUpperCase()} (${relay.state_changed_by}, ${new Date(relay.state_changed_at).toLocaleString()})`
+          : "unknown";
+        const relayStatus = relay ? ` · ${relay.status}` : "";
   
         const card = document.createElement("div");
         card.className = "card";
@@ -54,6 +108,7 @@ async function loadData() {
               <option value="3600" ${currentInterval == 3600 ? "selected" : ""}>1 hour</option>
             </select>
           </label>
+        <div>🚿 Relay: ${relayState}${relayStatus}</div>
         <div>
         <button onclick="controlRelay('${d.DeviceID}', 'on')">Relay ON</button>
         <button onclick="controlRelay('${d.DeviceID}', 'off')">Relay OFF</button>
@@ -69,21 +124,38 @@ async function loadData() {
   
   async function controlRelay(deviceID, action) {
     try {
-      const res = await fetch(`http://127.0.0.1:3000/api/v1/relay/${deviceID}/${action}`, {
+      const res = await apiFetch(`/relay/${deviceID}/${action}`, {
         method: "POST"
       });
-  
-      if (res.ok) {
-        console.log(`Relay ${action} command sent to ${deviceID}`);
-      } else {
+
+      if (!res.ok) {
         const errMsg = await res.text();
         alert("Failed to control relay: " + errMsg);
+        return;
       }
+      const cmd = await res.json();
+      console.log(`Relay ${action} command ${cmd.id} queued for ${deviceID}`);
+      const done = await waitForCommand(cmd.id);
+      if (done.status !== "delivered") {
+        alert(`Relay ${action} was not delivered (${done.status}): ${done.error || "no answer"}`);
+      }
+      loadData();
     } catch (err) {
       console.error("Relay error:", err);
       alert("Relay request failed.");
     }
   }
+
+  // Poll a queued relay command until it is delivered, failed or expired.
+  async function waitForCommand(id) {
+    for (;;) {
+      const res = await apiFetch(`/relay-commands/${id}`);
+      const cmd = await res.json();
+      if (cmd.status !== "pending") return cmd;
+      await new Promise(resolve => setTimeout(resolve, 1000));
+    }
+  }
+
   
   document.addEventListener("change", async (e) => {
     if (e.target.classList.contains("interval-select")) {
@@ -91,7 +163,7 @@ async function loadData() {
       const intervalSeconds = parseInt(e.target.value);
   
       try {
-        const res = await fetch("http://127.0.0.1:3000/api/v1/interval", {
+        const res = await apiFetch("/interval", {
           method: "POST",
           headers: { "Content-Type": "application/json" },
           body: JSON.stringify({ deviceID, intervalSeconds })
@@ -108,8 +180,43 @@ async function loadData() {
     }
   });
   
+  // ======== Live updates ========
+
+  // Refresh the cards when the Backend streams a new reading, relay change
+  // or alert. Bursts of events are coalesced into one reload.
+  let reloadTimer = null;
+  function scheduleReload() {
+    if (reloadTimer) return;
+    reloadTimer = setTimeout(() => {
+      reloadTimer = null;
+      loadData();
+    }, 500);
+  }
+
+  // EventSource cannot send the session header, so each connection uses a
+  // short-lived single-use ticket instead.
+  async function connectStream() {
+    const res = await apiFetch("/stream/ticket", { method: "POST" });
+    if (!res.ok) {
+      setTimeout(connectStream, 5000);
+      return;
+    }
+    const { ticket } = await res.json();
+    const source = new EventSource(`${API_BASE}/stream?ticket=${encodeURIComponent(ticket)}`);
+    ["reading", "relay_state", "relay_status", "alert"].forEach(type =>
+      source.addEventListener(type, scheduleReload)
+    );
+    source.onerror = async () => {
+      // The browser would retry with the spent ticket; reconnect with a
+      // fresh one instead, signing in again if the session expired.
+      source.close();
+      setTimeout(connectStream, 1000);
+    };
+  }
+
   loadData();
-  setInterval(loadData, 60000); // Refresh every 60 seconds
+  connectStream();
+  setInterval(loadData, 300000); // Safety refresh every 5 minutes
   
   // ======== Chart for Temperature & Humidity ========
   
@@ -124,15 +231,24 @@ async function loadData() {
     };
   }
   
+  // Bucket size requested from the Backend for each axis interval option.
+  function getBucketSize() {
+    const val = document.getElementById("xAxisInterval").value;
+    if (!val) return "5m";
+    const [unit, step] = val.split("-");
+    return step + (unit === "hour" ? "h" : "m");
+  }
+
   let tempChartInstance = null;
   
   async function drawTempChart() {
-    const res = await fetch('http://localhost:3000/api/v1/data/device/sensor-001');
-    const data = await res.json();
+    const from = Math.floor(Date.now() / 1000) - 24 * 3600; // last 24 hours
+    const res = await apiFetch(`/data/aggregate?device_id=sensor-001&bucket=${getBucketSize()}&from=${from}`);
+    const { series } = await res.json();
   
-    const labels = data.map(d => new Date(d.Timestamp));
-    const temps = data.map(d => d.Temperature);
-    const hums = data.map(d => d.Humidity);
+    const labels = series.map(b => new Date(b.start));
+    const temps = series.map(b => b.temperature.avg);
+    const hums = series.map(b => b.humidity.avg);
   
     if (tempChartInstance) {
       tempChartInstance.destroy();
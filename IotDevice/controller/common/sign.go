@@ -0,0 +1,55 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// SignRequest returns the hex HMAC-SHA256 signature the Backend expects in
+// the X-Signature header, computed over "timestamp\nMETHOD\npath\nbody".
+func SignRequest(secret, timestamp, method, path string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + method + "\n" + path + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ServerClock tracks the offset between the local clock, which starts at
+// boot on the Pico, and the server clock learnt from HTTP Date headers.
+type ServerClock struct {
+	offset time.Duration
+	synced bool
+}
+
+// Now returns the current time as estimated for the server.
+func (sc *ServerClock) Now() time.Time {
+	return time.Now().Add(sc.offset)
+}
+
+// Synced reports whether a Date header has been seen yet.
+func (sc *ServerClock) Synced() bool { return sc.synced }
+
+// SyncFromResponse updates the offset from the Date header of a raw HTTP
+// response. It reports whether a valid header was found.
+func (sc *ServerClock) SyncFromResponse(resp string) bool {
+	for _, line := range strings.Split(resp, "\r\n") {
+		if line == "" {
+			break // End of headers.
+		}
+		value, ok := strings.CutPrefix(line, "Date: ")
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC1123, value)
+		if err != nil {
+			return false
+		}
+		sc.offset = time.Until(t)
+		sc.synced = true
+		return true
+	}
+	return false
+}
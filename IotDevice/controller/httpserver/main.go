@@ -57,6 +57,18 @@ func HTTPHandler(respWriter io.Writer, resp *httpx.ResponseHeader, req *httpx.Re
 		respWriter.Write([]byte("OK"))
 		return
 
+	case "/relay/status":
+		// The Backend probes this to tell the relay is alive.
+		state := "off"
+		if lastLedState {
+			state = "on"
+		}
+		resp.SetStatusCode(200)
+		resp.SetContentType("text/plain")
+		respWriter.Write(resp.Header())
+		respWriter.Write([]byte(state))
+		return
+
 	default:
 		println("Path not found:", uri)
 		resp.SetStatusCode(404)
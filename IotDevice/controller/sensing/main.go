@@ -20,6 +20,7 @@ import (
 )
 
 const (
+	deviceID      = "sensor-001"
 	connTimeout   = 5 * time.Second
 	tcpbufsize    = 2030
 	serverAddrStr = "192.168.220.181:3000"
@@ -27,6 +28,15 @@ const (
 	sendInterval  = 1 * time.Minute
 )
 
+var (
+	// Put the secret from POST /api/v1/devices/<id>/secret in
+	// device_secret.text, starting from device_secret.text.example. The
+	// file is ignored by git.
+	//
+	//go:embed device_secret.text
+	deviceSecret string
+)
+
 func main() {
 	machine.Serial.Configure(machine.UARTConfig{})
 	machine.InitADC()
@@ -68,6 +78,9 @@ func main() {
 		panic("conn create:" + err.Error())
 	}
 
+	secret := strings.TrimSpace(deviceSecret)
+	var clock common.ServerClock
+
 	closeConn := func(reason string) {
 		slog.Info("closing TCP connection", slog.String("reason", reason))
 		conn.Close()
@@ -86,6 +99,8 @@ func main() {
 		}
 
 		raw := soil.Get()
+		// Uncalibrated estimate; the Backend recomputes it from the raw
+		// value once the probe has a calibration.
 		soilPercent := 100.0 - (float64(raw) * 100.0 / 65535.0)
 
 		slog.Info("Sensor data",
@@ -96,10 +111,11 @@ func main() {
 		)
 
 		payload := []byte(`{
-			"deviceID": "sensor-001",
+			"deviceID": "` + deviceID + `",
 			"temperature": ` + strconv.FormatFloat(float64(temp)/10.0, 'f', 1, 64) + `,
 			"humidity": ` + strconv.FormatFloat(float64(hum)/10.0, 'f', 1, 64) + `,
-			"soil": ` + strconv.FormatFloat(soilPercent, 'f', 1, 64) + `
+			"soil": ` + strconv.FormatFloat(soilPercent, 'f', 1, 64) + `,
+			"soilRaw": ` + strconv.Itoa(int(raw)) + `
 		}`)
 
 		var req httpx.RequestHeader
@@ -110,8 +126,14 @@ func main() {
 		headerBytes := req.Header()
 		headerWithoutCRLF := headerBytes[:len(headerBytes)-2]
 		contentLen := strconv.Itoa(len(payload))
+		// Requests are signed over the body and the server-synced timestamp.
+		timestamp := strconv.FormatInt(clock.Now().Unix(), 10)
+		signature := common.SignRequest(secret, timestamp, "POST", "/api/v1/data", payload)
 		extraHeaders := []byte("Content-Type: application/json\r\n" +
-			"Content-Length: " + contentLen + "\r\n\r\n")
+			"Content-Length: " + contentLen + "\r\n" +
+			"X-Device-ID: " + deviceID + "\r\n" +
+			"X-Timestamp: " + timestamp + "\r\n" +
+			"X-Signature: " + signature + "\r\n\r\n")
 
 		postReq := make([]byte, 0, len(headerWithoutCRLF)+len(extraHeaders)+len(payload))
 		postReq = append(postReq, headerWithoutCRLF...)
@@ -172,6 +194,19 @@ func main() {
 			IntervalSeconds int `json:"intervalSeconds"`
 		}
 		respStr := string(rxBuf[:n])
+		wasSynced := clock.Synced()
+		clock.SyncFromResponse(respStr)
+		if strings.HasPrefix(respStr, "HTTP/1.1 401") {
+			// Our clock starts at boot, so the first signed request is
+			// rejected until the server time is learnt from the Date header.
+			slog.Warn("request rejected by server, check device secret and clock")
+			if !wasSynced && clock.Synced() {
+				time.Sleep(5 * time.Second)
+			} else {
+				time.Sleep(sendInterval)
+			}
+			continue
+		}
 		splitIdx := strings.Index(respStr, "\r\n\r\n")
 		if splitIdx == -1 {
 			slog.Warn("HTTP response malformed, no header/body split found")